@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/jspohler/AnkiCards/backend/internal/api/handlers"
 	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
 	"github.com/jspohler/AnkiCards/backend/internal/services/ocr"
 	"github.com/jspohler/AnkiCards/backend/internal/services/pdf"
+	"github.com/jspohler/AnkiCards/backend/internal/services/prompts"
 )
 
 func main() {
@@ -20,7 +28,30 @@ func main() {
 	cardsDir := os.Getenv("CARDS_DIR")
 	decksDir := os.Getenv("DECKS_DIR")
 	openAIKey := os.Getenv("OPENAI_API_KEY")
-	cardsPerTopic := 5 // TODO: Load from env
+	llmProvider := os.Getenv("LLM_PROVIDER") // "openai" (default), "openai-compatible" or "fake"
+	llmBaseURL := os.Getenv("LLM_BASE_URL")
+	llmModel := os.Getenv("LLM_MODEL")
+	llmEmbeddingModel := os.Getenv("LLM_EMBEDDING_MODEL")
+	templatesDir := os.Getenv("TEMPLATES_DIR")
+	cardStore := os.Getenv("CARD_STORE")          // "fs" (default) or "sqlite"
+	cardStorePath := os.Getenv("CARD_STORE_PATH") // SQLite database, defaults to DECKS_DIR/cards.db
+	schedulerName := os.Getenv("SCHEDULER")       // "fsrs" (default) or "sm2"
+	mediaDir := os.Getenv("MEDIA_DIR")            // defaults to DECKS_DIR/media
+	cardsPerTopic := envInt("CARDS_PER_TOPIC")
+	if cardsPerTopic <= 0 {
+		cardsPerTopic = 5
+	}
+	processingConfig := pdf.ProcessingConfig{
+		Workers:            envInt("PROCESSING_WORKERS"),
+		ChunkConcurrency:   envInt("CHUNK_CONCURRENCY"),
+		RequestsPerMinute:  envInt("LLM_REQUESTS_PER_MINUTE"),
+		Burst:              envInt("LLM_BURST"),
+		MaxRetries:         envInt("LLM_MAX_RETRIES"),
+		ChunkTokens:        envInt("CHUNK_TOKENS"),
+		ChunkOverlapTokens: envInt("CHUNK_OVERLAP_TOKENS"),
+		DedupThreshold:     envFloat("MIN_SIMILARITY_THRESHOLD"),
+		JobRetention:       time.Duration(envInt("JOB_RETENTION_HOURS")) * time.Hour,
+	}
 
 	if uploadDir == "" || cardsDir == "" || decksDir == "" {
 		log.Fatal("UPLOAD_DIR, CARDS_DIR, and DECKS_DIR environment variables must be set")
@@ -37,20 +68,62 @@ func main() {
 		decksDir = filepath.Join(dir, decksDir)
 	}
 
+	if mediaDir == "" {
+		mediaDir = filepath.Join(decksDir, "media")
+	}
+
+	// Prompt templates live next to the cards unless configured otherwise
+	if templatesDir == "" {
+		templatesDir = filepath.Join(filepath.Dir(cardsDir), "templates")
+	}
+
 	// Initialize services
-	pdfService, err := pdf.NewService(uploadDir, cardsDir, openAIKey, cardsPerTopic)
+	provider, err := pdf.NewProvider(pdf.ProviderConfig{
+		Provider:       llmProvider,
+		APIKey:         openAIKey,
+		BaseURL:        llmBaseURL,
+		Model:          llmModel,
+		EmbeddingModel: llmEmbeddingModel,
+	})
 	if err != nil {
-		log.Fatalf("Failed to create PDF service: %v", err)
+		log.Fatalf("Failed to create LLM provider: %v", err)
+	}
+
+	promptService, err := prompts.NewService(templatesDir)
+	if err != nil {
+		log.Fatalf("Failed to create prompt template service: %v", err)
+	}
+
+	repo, err := anki.OpenRepository(cardStore, decksDir, cardStorePath)
+	if err != nil {
+		log.Fatalf("Failed to open card store: %v", err)
+	}
+	defer repo.Close()
+
+	// Decks generated before the card store are imported once
+	migrated, err := anki.MigrateCSV(repo, cardsDir)
+	if err != nil {
+		log.Fatalf("Failed to migrate CSV decks: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("Imported %d CSV decks into the card store", migrated)
 	}
 
 	ocrService := ocr.NewService("")
-	ankiService, err := anki.NewService(decksDir, cardsDir)
+	pdfService, err := pdf.NewService(uploadDir, cardsDir, repo, provider, cardsPerTopic, ocrService, promptService, processingConfig)
 	if err != nil {
-		log.Fatalf("Failed to create Anki service: %v", err)
+		log.Fatalf("Failed to create PDF service: %v", err)
 	}
+	pdfService.ResumeJobs()
+
+	scheduler, err := srs.New(schedulerName)
+	if err != nil {
+		log.Fatalf("Failed to create scheduler: %v", err)
+	}
+	ankiService := anki.NewService(repo, scheduler, mediaDir)
 
 	// Initialize handler
-	handler := handlers.NewHandler(pdfService, ocrService, ankiService)
+	handler := handlers.NewHandler(pdfService, ocrService, ankiService, promptService)
 
 	// Initialize Gin
 	r := gin.Default()
@@ -74,18 +147,33 @@ func main() {
 		api.POST("/upload", handler.HandlePDFUpload)
 		api.POST("/process", handler.StartProcessing)
 		api.GET("/process/:jobId", handler.GetProcessingStatus)
+		api.DELETE("/process/:jobId", handler.CancelJob)
+		api.GET("/process/:jobId/events", handler.StreamJobEvents)
 
 		// Card Management
 		api.GET("/cards/:id", handler.GetCards)
 		api.PUT("/decks/:deckId/cards/:cardId", handler.UpdateCard)
 		api.DELETE("/decks/:deckId/cards/:cardId", handler.DeleteCard)
 		api.GET("/decks", handler.GetDecks)
+		api.GET("/decks/:deckId/export", handler.ExportDeck)
+
+		// Studying
+		api.GET("/decks/:deckId/due", handler.GetDueCards)
+		api.POST("/cards/:id/review", handler.ReviewCard)
 
 		// CSV and APKG endpoints
 		api.GET("/cards/list", handler.ListCardsFromCSV)
 		api.GET("/cards/csv/:deckName", handler.GetCardsFromCSV)
 		api.PUT("/cards/csv/:deckName", handler.UpdateCardCSV)
 		api.GET("/cards/apkg/:deckName", handler.GenerateAnkiDeck)
+		api.POST("/import/apkg", handler.ImportAPKG)
+		api.GET("/media/:name", handler.GetMedia)
+
+		// Prompt templates
+		api.GET("/templates", handler.ListTemplates)
+		api.GET("/templates/:name", handler.GetTemplate)
+		api.POST("/templates", handler.CreateTemplate)
+		api.PUT("/templates/:name", handler.UpdateTemplate)
 	}
 
 	// Start server
@@ -94,8 +182,56 @@ func main() {
 		port = "8081"
 	}
 
-	log.Printf("Server starting on :%s", port)
-	log.Fatal(r.Run(":" + port))
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Server starting on :%s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+
+	// Running jobs get a grace period before the HTTP server stops, so their
+	// event streams can deliver the final status; jobs still running or
+	// queued afterwards are resumed on the next start
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := pdfService.Close(shutdownCtx); err != nil {
+		log.Printf("Warning: Jobs still running at shutdown will be resumed: %v", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Failed to shut down the server: %v", err)
+	}
+}
+
+// envInt reads an integer environment variable, returning 0 when it is unset
+func envInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
+
+// envFloat reads a decimal environment variable, returning 0 when it is unset
+func envFloat(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number: %v", name, err)
+	}
+	return f
 }
 
 func handlePDFUpload(c *gin.Context) {
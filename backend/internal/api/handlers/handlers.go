@@ -1,40 +1,84 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
 	"github.com/jspohler/AnkiCards/backend/internal/services/ocr"
 	"github.com/jspohler/AnkiCards/backend/internal/services/pdf"
+	"github.com/jspohler/AnkiCards/backend/internal/services/prompts"
 )
 
 type Handler struct {
-	pdfService  *pdf.Service
-	ocrService  *ocr.Service
-	ankiService *anki.Service
+	pdfService    *pdf.Service
+	ocrService    *ocr.Service
+	ankiService   *anki.Service
+	promptService *prompts.Service
 }
 
 type ProcessRequest struct {
 	Files             []string `json:"files"`
 	IncludeTopicCards bool     `json:"includeTopicCards"`
 	CardsPerTopic     int      `json:"cardsPerTopic"`
+	// Card counts, see pdf.GenerationParams; unset values use the defaults
+	TargetTotal       int     `json:"targetTotal"`
+	CardsPerPage      float64 `json:"cardsPerPage"`
+	CardsPer1000Words float64 `json:"cardsPer1000Words"`
+	MinPerChunk       int     `json:"minPerChunk"`
+	MaxPerChunk       int     `json:"maxPerChunk"`
+	Density           string  `json:"density"`  // "balanced", "exhaustive" or "key_concepts"
+	CardType          string  `json:"cardType"` // "basic", "cloze" or "mixed"
+	NoteType          string  `json:"noteType"` // "basic", "reversed" or "optional_reversed"
+	// MergeDecks combines all files into one deck named DeckName
+	MergeDecks bool   `json:"mergeDecks"`
+	DeckName   string `json:"deckName"`
+	// ChunkConcurrency overrides how many chunks are sent to the LLM at once
+	ChunkConcurrency int `json:"chunkConcurrency"`
+	// Template selects the prompt template, see GET /api/templates
+	Template string `json:"template"`
+	// Dedup is "flag" (default), "drop", "merge" or "off"; DedupScope is
+	// "deck" (default) or "all"; DedupThreshold overrides the similarity of
+	// semantic duplicates
+	Dedup          string  `json:"dedup"`
+	DedupScope     string  `json:"dedupScope"`
+	DedupThreshold float64 `json:"dedupThreshold"`
+	// Verify checks answers against their source: "off" (default), "llm"
+	// or "lexical"
+	Verify string `json:"verify"`
+	// Tags are added to every card; SubdeckDepth files cards into subdecks
+	// following up to that many levels of the outline of a PDF or EPUB
+	Tags         []string `json:"tags"`
+	SubdeckDepth int      `json:"subdeckDepth"`
 }
 
-func NewHandler(pdfService *pdf.Service, ocrService *ocr.Service, ankiService *anki.Service) *Handler {
+// TemplateRequest creates or edits a prompt template
+type TemplateRequest struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+func NewHandler(pdfService *pdf.Service, ocrService *ocr.Service, ankiService *anki.Service, promptService *prompts.Service) *Handler {
 	return &Handler{
-		pdfService:  pdfService,
-		ocrService:  ocrService,
-		ankiService: ankiService,
+		pdfService:    pdfService,
+		ocrService:    ocrService,
+		ankiService:   ankiService,
+		promptService: promptService,
 	}
 }
 
+// HandlePDFUpload saves uploaded files of every supported input format
 func (h *Handler) HandlePDFUpload(c *gin.Context) {
 	form, err := c.MultipartForm()
 	if err != nil {
@@ -48,30 +92,34 @@ func (h *Handler) HandlePDFUpload(c *gin.Context) {
 		return
 	}
 
-	uploadedFiles := make([]string, 0, len(files))
-	for _, file := range files {
-		if filepath.Ext(file.Filename) != ".pdf" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File %s is not a PDF", file.Filename)})
-			return
-		}
-
-		// Read the file data
+	// Every file is checked before any is saved, so a rejected upload
+	// leaves nothing behind
+	contents := make([][]byte, len(files))
+	for i, file := range files {
 		fileContent, err := file.Open()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", file.Filename, err)})
 			return
 		}
-		defer fileContent.Close()
-
-		// Read all bytes
 		fileBytes, err := io.ReadAll(fileContent)
+		fileContent.Close()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", file.Filename, err)})
 			return
 		}
 
+		// The format is sniffed from the content, not the extension
+		if _, err := pdf.DetectInput(fileBytes, file.Filename); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		contents[i] = fileBytes
+	}
+
+	uploadedFiles := make([]string, 0, len(files))
+	for i, file := range files {
 		// Save the file using the PDF service
-		filePath, err := h.pdfService.SaveUploadedFile(fileBytes, file.Filename)
+		filePath, err := h.pdfService.SaveUploadedFile(contents[i], file.Filename)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save %s: %v", file.Filename, err)})
 			return
@@ -111,7 +159,44 @@ func (h *Handler) StartProcessing(c *gin.Context) {
 		}
 	}
 
-	jobID, err := h.pdfService.StartProcessing(fullPaths, req.IncludeTopicCards)
+	jobID, err := h.pdfService.StartProcessing(fullPaths, pdf.JobOptions{
+		IncludeTopicCards: req.IncludeTopicCards,
+		MergeDecks:        req.MergeDecks,
+		DeckName:          req.DeckName,
+		ChunkConcurrency:  req.ChunkConcurrency,
+		Template:          req.Template,
+		Generation: pdf.GenerationParams{
+			TargetTotal:       req.TargetTotal,
+			CardsPerPage:      req.CardsPerPage,
+			CardsPer1000Words: req.CardsPer1000Words,
+			MinPerChunk:       req.MinPerChunk,
+			MaxPerChunk:       req.MaxPerChunk,
+			Density:           req.Density,
+			CardsPerTopic:     req.CardsPerTopic,
+			CardType:          req.CardType,
+			NoteType:          req.NoteType,
+		},
+		Dedup: pdf.DedupOptions{
+			Mode:      req.Dedup,
+			Scope:     req.DedupScope,
+			Threshold: req.DedupThreshold,
+		},
+		Verify:       req.Verify,
+		Tags:         req.Tags,
+		SubdeckDepth: req.SubdeckDepth,
+	})
+	if errors.Is(err, prompts.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown template: %s", req.Template)})
+		return
+	}
+	if errors.Is(err, pdf.ErrInvalidGeneration) || errors.Is(err, pdf.ErrUnsupportedFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, pdf.ErrClosed) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to start processing: %v", err)})
 		return
@@ -135,11 +220,69 @@ func (h *Handler) GetProcessingStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
+// StreamJobEvents streams the progress events of a job as Server-Sent Events
+func (h *Handler) StreamJobEvents(c *gin.Context) {
+	jobID := c.Param("jobId")
+
+	events, unsubscribe, err := h.pdfService.SubscribeJob(jobID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// A subscriber that fell behind can miss the final status, which is
+	// sent once the channel closes instead
+	final := false
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if status := h.pdfService.GetJobStatus(jobID); !final && status != nil && pdf.IsTerminalStatus(status.Status) {
+					c.SSEvent(pdf.EventStatus, pdf.JobEvent{Type: pdf.EventStatus, JobID: jobID, Time: time.Now(), Status: status})
+				}
+				return false
+			}
+			if event.Type == pdf.EventStatus && event.Status != nil && pdf.IsTerminalStatus(event.Status.Status) {
+				final = true
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// CancelJob stops a running processing job
+func (h *Handler) CancelJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+
+	err := h.pdfService.CancelJob(jobID)
+	switch {
+	case errors.Is(err, pdf.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	case errors.Is(err, pdf.ErrJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Job already finished"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to cancel job: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+}
+
 func (h *Handler) GetCards(c *gin.Context) {
 	deckID := c.Param("id")
 	deck, err := h.ankiService.GetDeck(deckID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		respondDeckError(c, err, "Failed to read deck")
 		return
 	}
 
@@ -156,12 +299,13 @@ func (h *Handler) UpdateCard(c *gin.Context) {
 		return
 	}
 
-	if err := h.ankiService.UpdateCard(deckID, cardID, card); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update card"})
+	updated, err := h.ankiService.UpdateCard(deckID, cardID, card)
+	if err != nil {
+		respondDeckError(c, err, "Failed to update card")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Card updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Card updated successfully", "card": updated})
 }
 
 func (h *Handler) DeleteCard(c *gin.Context) {
@@ -169,7 +313,7 @@ func (h *Handler) DeleteCard(c *gin.Context) {
 	cardID := c.Param("cardId")
 
 	if err := h.ankiService.DeleteCard(deckID, cardID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete card"})
+		respondDeckError(c, err, "Failed to delete card")
 		return
 	}
 
@@ -186,150 +330,292 @@ func (h *Handler) GetDecks(c *gin.Context) {
 	c.JSON(http.StatusOK, decks)
 }
 
-// UpdateCardCSV updates the CSV file with reviewed cards
-func (h *Handler) UpdateCardCSV(c *gin.Context) {
-	deckName := c.Param("deckName")
-	// Ensure we only use the base name
-	deckName = filepath.Base(deckName)
-
-	var cards []struct {
-		Question string `json:"question"`
-		Answer   string `json:"answer"`
+// GetDueCards returns the cards of a deck due for study now, learning and
+// review cards first, then new ones
+func (h *Handler) GetDueCards(c *gin.Context) {
+	deckID := c.Param("deckId")
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+			return
+		}
+		limit = n
 	}
 
-	if err := c.BindJSON(&cards); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	cards, err := h.ankiService.DueCards(deckID, time.Now(), limit)
+	if err != nil {
+		respondDeckError(c, err, "Failed to read due cards")
 		return
 	}
 
-	// Create CSV content
-	csvContent := "Question,Answer\n"
+	newCards := 0
 	for _, card := range cards {
-		// Escape quotes and newlines in question and answer
-		q := strings.ReplaceAll(card.Question, "\"", "\"\"")
-		a := strings.ReplaceAll(card.Answer, "\"", "\"\"")
-		csvContent += fmt.Sprintf("\"%s\",\"%s\"\n", q, a)
+		if card.Review == nil {
+			newCards++
+		}
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"deckId": deckID,
+		"cards":  cards,
+		"due":    len(cards) - newCards,
+		"new":    newCards,
+	})
+}
+
+// ReviewRequest is the answer to a card: "again", "hard", "good" or "easy",
+// or 1 to 4
+type ReviewRequest struct {
+	Rating srs.Rating `json:"rating"`
+}
 
-	// Write to CSV file
-	csvPath := filepath.Join(h.ankiService.GetCardsDir(), deckName+".csv")
-	if err := os.WriteFile(csvPath, []byte(csvContent), 0644); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update CSV file"})
+// ReviewCard records the answer to a card and returns it with its next due
+// time
+func (h *Handler) ReviewCard(c *gin.Context) {
+	var req ReviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid review: %v", err)})
+		return
+	}
+	if req.Rating == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating is required"})
+		return
+	}
+
+	card, err := h.ankiService.ReviewCard(c.Param("id"), req.Rating, time.Now())
+	if err != nil {
+		respondDeckError(c, err, "Failed to record review")
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+// ImportAPKG creates decks from an uploaded .apkg or .colpkg file. The
+// optional deckName form field puts all notes into one deck.
+func (h *Handler) ImportAPKG(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	switch strings.ToLower(filepath.Ext(header.Filename)) {
+	case ".apkg", ".colpkg":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File %s is not an .apkg or .colpkg file", header.Filename)})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", header.Filename, err)})
+		return
+	}
+	defer file.Close()
+
+	result, err := h.ankiService.ImportAPKG(file, header.Size, strings.TrimSpace(c.PostForm("deckName")))
+	if err != nil {
+		if errors.Is(err, anki.ErrInvalidPackage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondDeckError(c, err, fmt.Sprintf("Failed to import %s", header.Filename))
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// GetMedia serves a media file referenced from card fields
+func (h *Handler) GetMedia(c *gin.Context) {
+	path := h.ankiService.MediaPath(c.Param("name"))
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media file name"})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+	c.File(path)
+}
+
+// respondDeckError maps the errors of deck and card operations to a response
+func respondDeckError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, anki.ErrDeckNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+	case errors.Is(err, anki.ErrCardNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+	case errors.Is(err, anki.ErrInvalidDeckID),
+		errors.Is(err, srs.ErrInvalidRating),
+		errors.Is(err, anki.ErrInvalidCloze),
+		errors.Is(err, anki.ErrInvalidNoteType),
+		errors.Is(err, anki.ErrInvalidCardType):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// UpdateCardCSV replaces the cards of a deck with the reviewed cards. Cards
+// keep their IDs and timestamps unless they were changed.
+func (h *Handler) UpdateCardCSV(c *gin.Context) {
+	deckID := c.Param("deckName")
+
+	var cards []anki.Card
+	if err := c.BindJSON(&cards); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.ankiService.SaveDeck(&anki.Deck{ID: deckID, Cards: cards}); err != nil {
+		respondDeckError(c, err, "Failed to update cards")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Cards updated successfully"})
 }
 
-// GenerateAnkiDeck generates an .apkg file from the reviewed CSV
+// GenerateAnkiDeck streams a deck as an .apkg file
 func (h *Handler) GenerateAnkiDeck(c *gin.Context) {
-	deckName := c.Param("deckName")
-	// Ensure we only use the base name
-	deckName = filepath.Base(deckName)
-	csvPath := filepath.Join(h.ankiService.GetCardsDir(), deckName+".csv")
+	h.streamExport(c, c.Param("deckName"), anki.FormatAPKG)
+}
 
-	// Generate APKG file using genanki-like functionality
-	apkgPath, err := h.ankiService.GenerateAPKG(csvPath, deckName)
+// ExportDeck streams a deck as apkg (default), csv, tsv, txt or json
+func (h *Handler) ExportDeck(c *gin.Context) {
+	h.streamExport(c, c.Param("deckId"), c.DefaultQuery("format", anki.FormatAPKG))
+}
+
+// streamExport writes a deck export as a download. Errors found after the
+// download started can only be logged.
+func (h *Handler) streamExport(c *gin.Context, deckID, format string) {
+	export, err := h.ankiService.ExportDeck(deckID, format)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-			"details": map[string]string{
-				"deckName": deckName,
-				"csvPath":  csvPath,
-				"message":  "Failed to generate Anki deck",
-			},
-		})
+		if errors.Is(err, anki.ErrInvalidFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondDeckError(c, err, "Failed to export deck")
 		return
 	}
 
-	// Set headers for file download
 	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.apkg", deckName))
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(apkgPath)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": export.Filename()}))
+	c.Header("Content-Type", export.ContentType)
+	c.Status(http.StatusOK)
+	if err := export.Write(c.Writer); err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Del("Content-Type")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Export of deck %s failed: %v", deckID, err)
+	}
 }
 
-// GetCardsFromCSV retrieves cards from a CSV file
+// GetCardsFromCSV retrieves the cards of a deck, including the source each
+// card was generated from
 func (h *Handler) GetCardsFromCSV(c *gin.Context) {
-	deckName := c.Param("deckName")
-	// Ensure we only use the base name
-	deckName = filepath.Base(deckName)
-	csvPath := filepath.Join(h.ankiService.GetCardsDir(), deckName+".csv")
-
-	// Read CSV file
-	file, err := os.Open(csvPath)
+	deck, err := h.ankiService.GetDeck(c.Param("deckName"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "CSV file not found"})
+		respondDeckError(c, err, "Failed to read deck")
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	c.JSON(http.StatusOK, gin.H{"cards": deck.Cards})
+}
+
+// ListCardsFromCSV lists all decks with their card counts
+func (h *Handler) ListCardsFromCSV(c *gin.Context) {
+	decks, err := h.ankiService.ListDecks()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read CSV file"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list decks"})
 		return
 	}
 
-	// Skip header row and convert to JSON
-	var cards []map[string]string
-	for i, record := range records {
-		if i == 0 { // Skip header row
-			continue
-		}
-		if len(record) >= 2 {
-			cards = append(cards, map[string]string{
-				"question": record[0],
-				"answer":   record[1],
-			})
-		}
+	summaries := make([]gin.H, 0, len(decks))
+	for _, deck := range decks {
+		summaries = append(summaries, gin.H{
+			"id":         deck.ID,
+			"name":       deck.Name,
+			"totalCards": deck.CardCount,
+			"created":    deck.Created,
+			"updated":    deck.Updated,
+		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"cards": cards})
+	c.JSON(http.StatusOK, gin.H{"decks": summaries})
 }
 
-// ListCardsFromCSV lists all available CSV files and their card counts
-func (h *Handler) ListCardsFromCSV(c *gin.Context) {
-	files, err := os.ReadDir(h.ankiService.GetCardsDir())
+func (h *Handler) ListTemplates(c *gin.Context) {
+	templates, err := h.promptService.List()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cards directory"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list templates: %v", err)})
 		return
 	}
 
-	var decks []map[string]interface{}
-	for _, file := range files {
-		if filepath.Ext(file.Name()) != ".csv" {
-			continue
-		}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
 
-		// Read CSV file to count cards
-		csvPath := filepath.Join(h.ankiService.GetCardsDir(), file.Name())
-		file, err := os.Open(csvPath)
-		if err != nil {
-			continue
-		}
-		defer file.Close()
+func (h *Handler) GetTemplate(c *gin.Context) {
+	tmpl, err := h.promptService.Get(c.Param("name"))
+	if errors.Is(err, prompts.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read template: %v", err)})
+		return
+	}
 
-		reader := csv.NewReader(file)
-		records, err := reader.ReadAll()
-		if err != nil {
-			continue
-		}
+	c.JSON(http.StatusOK, tmpl)
+}
 
-		// Subtract 1 for header row
-		cardCount := len(records) - 1
-		if cardCount < 0 {
-			cardCount = 0
-		}
+func (h *Handler) CreateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 
-		// Use only the base name without extension
-		deckName := strings.TrimSuffix(file.Name(), ".csv")
-		decks = append(decks, map[string]interface{}{
-			"name":       deckName,
-			"totalCards": cardCount,
-		})
+	tmpl, err := h.promptService.Create(req.Name, req.Content)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"decks": decks})
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+func (h *Handler) UpdateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tmpl, err := h.promptService.Update(c.Param("name"), req.Content)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// templateErrorStatus maps prompt template errors to HTTP status codes
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, prompts.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, prompts.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, prompts.ErrInvalidName), errors.Is(err, prompts.ErrInvalidTemplate):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
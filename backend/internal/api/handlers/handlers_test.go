@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jspohler/AnkiCards/backend/internal/services/pdf"
+)
+
+func TestHandlePDFUploadRejectsBatchBeforeSaving(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	uploadDir := t.TempDir()
+	pdfService, err := pdf.NewService(uploadDir, t.TempDir(), nil, pdf.NewFakeProvider(), 3, nil, nil, pdf.ProcessingConfig{})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	defer pdfService.Close(context.Background())
+	h := NewHandler(pdfService, nil, nil, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	// The valid file comes first
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"notes.txt", []byte("Cells are the basic unit of life.")},
+		{"archive.bin", []byte{0x00, 0x01, 0x02, 0xff, 0xfe, 0x00, 0x10, 0x80}},
+	} {
+		part, err := form.CreateFormFile("files", file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.data)
+	}
+	form.Close()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/upload", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	h.HandlePDFUpload(c)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusBadRequest, recorder.Body)
+	}
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("upload directory holds %d files after a rejected upload", len(entries))
+	}
+}
@@ -1,21 +1,99 @@
 package anki
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"html"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/apkg"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
 )
 
 // Card represents an Anki flashcard
 type Card struct {
-	ID       string    `json:"id"`
-	Question string    `json:"question"`
-	Answer   string    `json:"answer"`
-	Created  time.Time `json:"created"`
+	ID       string `json:"id"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	// Type is "basic" or "cloze"; empty means basic
+	Type string `json:"type,omitempty"`
+	// NoteType decides in which directions a basic card is asked
+	NoteType string `json:"noteType,omitempty"`
+	// AddReverse asks an optional reversed card in both directions
+	AddReverse bool `json:"addReverse,omitempty"`
+	// Tags are topic keywords without spaces
+	Tags []string `json:"tags,omitempty"`
+	// Subdeck is the path of the card's subdeck below its deck, such as
+	// "Chapter 2::Cells", empty for cards in the deck itself
+	Subdeck string  `json:"subdeck,omitempty"`
+	Source  *Source `json:"source,omitempty"`
+	// Duplicate is set on cards flagged as duplicates of another card
+	Duplicate *Duplicate `json:"duplicate,omitempty"`
+	// Verification is set when the answer was checked against the source
+	Verification *Verification `json:"verification,omitempty"`
+	// Review is the spaced repetition state, nil for cards never studied.
+	// It only changes through reviews.
+	Review  *srs.State `json:"review,omitempty"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+}
+
+// Card types. Cards without a type are basic cards.
+const (
+	CardTypeBasic = "basic"
+	// CardTypeCloze cards keep their text with {{c1::...}} deletions in
+	// Question and optional extra information in Answer
+	CardTypeCloze = "cloze"
+)
+
+// Note types of basic cards. Cards without a note type are asked in one
+// direction only.
+const (
+	NoteTypeBasic = "basic"
+	// NoteTypeReversed asks every card in both directions
+	NoteTypeReversed = "reversed"
+	// NoteTypeOptionalReversed asks the reverse direction only of cards
+	// with AddReverse set, which can still be changed in Anki
+	NoteTypeOptionalReversed = "optional_reversed"
+)
+
+// NoteTypes lists the valid note types
+var NoteTypes = []string{NoteTypeBasic, NoteTypeReversed, NoteTypeOptionalReversed}
+
+// Verification is the outcome of checking a card's answer against the text
+// it was generated from
+type Verification struct {
+	Grounded bool `json:"grounded"`
+	// Confidence is between 0 and 1
+	Confidence    float64 `json:"confidence"`
+	Justification string  `json:"justification,omitempty"`
+	// Method is "llm" or "lexical"
+	Method string `json:"method"`
+}
+
+// Duplicate describes the card a card duplicates
+type Duplicate struct {
+	// Of is the question of the other card
+	Of string `json:"of"`
+	// Deck names the other card's deck when it is in a different deck
+	Deck string `json:"deck,omitempty"`
+	// Kind is "exact", "normalized" or "semantic"
+	Kind       string  `json:"kind"`
+	Similarity float64 `json:"similarity"`
+}
+
+// Source records where in the input a generated card comes from
+type Source struct {
+	File string `json:"file,omitempty"`
+	// Pages is a page number or range such as "4" or "4-6"
+	Pages   string `json:"pages,omitempty"`
+	Section string `json:"section,omitempty"`
+	// Excerpt is the passage of the input that supports the answer
+	Excerpt string `json:"excerpt,omitempty"`
 }
 
 // Deck represents an Anki deck
@@ -24,38 +102,45 @@ type Deck struct {
 	Name    string    `json:"name"`
 	Cards   []Card    `json:"cards"`
 	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// CardCount is set instead of Cards when decks are listed
+	CardCount int `json:"cardCount,omitempty"`
 }
 
 // Service handles Anki card and deck operations
 type Service struct {
-	decksDir string
-	cardsDir string
+	repo      Repository
+	scheduler srs.Scheduler
+	// mediaDir holds the media files referenced from card fields
+	mediaDir string
+	// reviewMu keeps concurrent reviews of a card from losing one another
+	reviewMu sync.Mutex
 }
 
-// NewService creates a new Anki service
-func NewService(decksDir string, cardsDir string) (*Service, error) {
-	if err := os.MkdirAll(decksDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create decks directory: %w", err)
-	}
-	if err := os.MkdirAll(cardsDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create cards directory: %w", err)
+// NewService creates a new Anki service working on the decks of repo,
+// scheduling reviews with scheduler and keeping media files in mediaDir
+func NewService(repo Repository, scheduler srs.Scheduler, mediaDir string) *Service {
+	return &Service{repo: repo, scheduler: scheduler, mediaDir: mediaDir}
+}
+
+// MediaPath returns the path of a media file, or "" for names that are not
+// plain file names
+func (s *Service) MediaPath(name string) string {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return ""
 	}
-	return &Service{
-		decksDir: decksDir,
-		cardsDir: cardsDir,
-	}, nil
+	return filepath.Join(s.mediaDir, name)
 }
 
 // CreateDeck creates a new Anki deck
 func (s *Service) CreateDeck(name string, cards []Card) (*Deck, error) {
 	deck := &Deck{
-		ID:      fmt.Sprintf("%d", time.Now().UnixNano()),
-		Name:    name,
-		Cards:   cards,
-		Created: time.Now(),
+		ID:    fmt.Sprintf("%d", time.Now().UnixNano()),
+		Name:  name,
+		Cards: cards,
 	}
 
-	if err := s.saveDeck(deck); err != nil {
+	if err := s.repo.SaveDeck(deck); err != nil {
 		return nil, err
 	}
 
@@ -64,205 +149,228 @@ func (s *Service) CreateDeck(name string, cards []Card) (*Deck, error) {
 
 // GetDeck retrieves a deck by ID
 func (s *Service) GetDeck(id string) (*Deck, error) {
-	filename := filepath.Join(s.decksDir, id+".json")
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("deck not found: %w", err)
-	}
-	defer file.Close()
-
-	var deck Deck
-	if err := json.NewDecoder(file).Decode(&deck); err != nil {
-		return nil, fmt.Errorf("failed to read deck: %w", err)
-	}
-
-	return &deck, nil
+	return s.repo.GetDeck(id)
 }
 
-// ListDecks returns all available decks
+// ListDecks returns all available decks without their cards
 func (s *Service) ListDecks() ([]Deck, error) {
-	files, err := os.ReadDir(s.decksDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read decks directory: %w", err)
-	}
-
-	var decks []Deck
-	for _, file := range files {
-		if filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
-
-		deck, err := s.GetDeck(strings.TrimSuffix(file.Name(), ".json"))
-		if err != nil {
-			continue
-		}
-		decks = append(decks, *deck)
-	}
-
-	return decks, nil
+	return s.repo.ListDecks()
 }
 
-// UpdateCard updates a card in a deck
-func (s *Service) UpdateCard(deckID string, cardID string, updatedCard Card) error {
-	deck, err := s.GetDeck(deckID)
-	if err != nil {
+// SaveDeck validates the cards of a deck and stores it, replacing the
+// deck's previous cards
+func (s *Service) SaveDeck(deck *Deck) error {
+	if err := ValidateCards(deck.Cards); err != nil {
 		return err
 	}
+	return s.repo.SaveDeck(deck)
+}
 
-	found := false
-	for i, card := range deck.Cards {
-		if card.ID == cardID {
-			updatedCard.ID = cardID            // Preserve the original ID
-			updatedCard.Created = card.Created // Preserve creation time
-			deck.Cards[i] = updatedCard
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return fmt.Errorf("card not found")
+// UpdateCard updates a card in a deck
+func (s *Service) UpdateCard(deckID string, cardID string, updatedCard Card) (*Card, error) {
+	if err := validateCard(updatedCard); err != nil {
+		return nil, err
 	}
-
-	return s.saveDeck(deck)
+	updatedCard.ID = cardID
+	return s.repo.UpdateCard(deckID, updatedCard)
 }
 
 // DeleteCard removes a card from a deck
 func (s *Service) DeleteCard(deckID string, cardID string) error {
-	deck, err := s.GetDeck(deckID)
+	return s.repo.DeleteCard(deckID, cardID)
+}
+
+// DueCards returns the cards of a deck due for review at now: learning and
+// review cards by due time, then new cards in deck order. limit caps the
+// number of cards, 0 returns all.
+func (s *Service) DueCards(deckID string, now time.Time, limit int) ([]Card, error) {
+	deck, err := s.repo.GetDeck(deckID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for i, card := range deck.Cards {
-		if card.ID == cardID {
-			// Remove the card by replacing it with the last element and truncating
-			deck.Cards[i] = deck.Cards[len(deck.Cards)-1]
-			deck.Cards = deck.Cards[:len(deck.Cards)-1]
-			return s.saveDeck(deck)
+	var due, fresh []Card
+	for _, card := range deck.Cards {
+		switch {
+		case card.Review == nil:
+			fresh = append(fresh, card)
+		case srs.IsDue(card.Review, now):
+			due = append(due, card)
 		}
 	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Review.Due.Before(due[j].Review.Due) })
 
-	return fmt.Errorf("card not found")
+	cards := append(due, fresh...)
+	if limit > 0 && len(cards) > limit {
+		cards = cards[:limit]
+	}
+	return cards, nil
 }
 
-// saveDeck saves a deck to disk
-func (s *Service) saveDeck(deck *Deck) error {
-	filename := filepath.Join(s.decksDir, deck.ID+".json")
-	file, err := os.Create(filename)
+// ReviewCard records an answer to a card, looked up in all decks, and
+// schedules its next review
+func (s *Service) ReviewCard(cardID string, rating srs.Rating, now time.Time) (*Card, error) {
+	s.reviewMu.Lock()
+	defer s.reviewMu.Unlock()
+
+	deckID, card, err := s.repo.FindCard(cardID)
 	if err != nil {
-		return fmt.Errorf("failed to create deck file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-
-	if err := json.NewEncoder(file).Encode(deck); err != nil {
-		return fmt.Errorf("failed to save deck: %w", err)
+	state, err := s.scheduler.Schedule(card.Review, rating, now)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
-}
-
-// ExportDeck exports a deck to the Anki .apkg format
-func (s *Service) ExportDeck(id string) (string, error) {
-	// TODO: Implement .apkg export
-	// We'll need to use a library or implement the Anki package format
-	return "", fmt.Errorf("not implemented yet")
-}
-
-// GetCardsDir returns the cards directory path
-func (s *Service) GetCardsDir() string {
-	return s.cardsDir
+	return s.repo.SaveReview(deckID, cardID, state)
 }
 
-// GenerateAPKG generates an Anki package file from a CSV file
-func (s *Service) GenerateAPKG(csvPath string, deckName string) (string, error) {
-	// Create a temporary directory for the APKG files
-	tmpDir := filepath.Join(s.decksDir, "tmp")
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %w", err)
+// deckPackage converts a deck into an Anki package with stable deck and
+// model IDs. Notes keep the card's ID as their GUID, so Anki updates them on
+// a later import, and its creation time as their ID. Plain text fields are
+// converted to HTML like in the text export.
+func deckPackage(deck *Deck) *apkg.Package {
+	pkg := &apkg.Package{}
+	deckIDs := make(map[string]int64)
+	addDeck := func(name string) int64 {
+		id, ok := deckIDs[name]
+		if !ok {
+			id = apkg.DeckID(name)
+			deckIDs[name] = id
+			pkg.Decks = append(pkg.Decks, apkg.Deck{ID: id, Name: name})
+		}
+		return id
 	}
+	addDeck(deck.Name)
 
-	// Generate the APKG file path
-	apkgPath := filepath.Join(tmpDir, deckName+".apkg")
+	used := make(map[int64]bool)
+	noteIDs := make(map[int64]bool)
+	guids := noteGUIDs(deck)
+	for i, card := range deck.Cards {
+		model := cardModel(card)
+		if !used[model.ID] {
+			used[model.ID] = true
+			pkg.Models = append(pkg.Models, model)
+		}
 
-	// Get the current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return "", fmt.Errorf("failed to get current working directory: %w", err)
-	}
+		// Parent decks are added before their subdecks
+		deckID := deckIDs[deck.Name]
+		name := deck.Name
+		for _, title := range strings.Split(card.Subdeck, "::") {
+			if title = strings.TrimSpace(title); title != "" {
+				name += "::" + title
+				deckID = addDeck(name)
+			}
+		}
 
-	// Find the project root by looking for the venv directory
-	projectRoot := ""
-	currentDir := cwd
-	for i := 0; i < 5; i++ { // Limit the search to 5 levels up
-		if _, err := os.Stat(filepath.Join(currentDir, "venv")); err == nil {
-			projectRoot = currentDir
-			break
+		fields := []string{htmlField(card.Question), htmlField(card.Answer), sourceField(card.Source)}
+		if card.Type != CardTypeCloze && card.NoteType == NoteTypeOptionalReversed {
+			addReverse := ""
+			if card.AddReverse {
+				addReverse = "y"
+			}
+			fields = append(fields, addReverse)
 		}
-		parentDir := filepath.Dir(currentDir)
-		if parentDir == currentDir {
-			break
+		note := apkg.Note{
+			GUID:     guids[i],
+			ModelID:  model.ID,
+			DeckID:   deckID,
+			Fields:   fields,
+			Tags:     noteTags(card.Tags),
+			Modified: card.Updated,
 		}
-		currentDir = parentDir
-	}
-
-	if projectRoot == "" {
-		// Try to find project root relative to the executable path
-		if execPath, err := os.Executable(); err == nil {
-			execDir := filepath.Dir(execPath)
-			for i := 0; i < 5; i++ {
-				if _, err := os.Stat(filepath.Join(execDir, "venv")); err == nil {
-					projectRoot = execDir
-					break
-				}
-				parentDir := filepath.Dir(execDir)
-				if parentDir == execDir {
-					break
-				}
-				execDir = parentDir
+		if note.Modified.IsZero() {
+			note.Modified = card.Created
+		}
+		if !card.Created.IsZero() {
+			// Cards saved together share their creation time
+			note.ID = card.Created.UnixMilli()
+			for noteIDs[note.ID] {
+				note.ID++
 			}
+			noteIDs[note.ID] = true
 		}
+		pkg.Notes = append(pkg.Notes, note)
 	}
-
-	if projectRoot == "" {
-		return "", fmt.Errorf("could not find project root directory containing venv (searched from %s)", cwd)
+	if len(pkg.Models) == 0 {
+		pkg.Models = append(pkg.Models, apkg.BasicModel())
 	}
+	return pkg
+}
 
-	// Get the path to the virtual environment's Python interpreter
-	venvPython := filepath.Join(projectRoot, "venv", "bin", "python3")
-	if _, err := os.Stat(venvPython); err != nil {
-		return "", fmt.Errorf("virtual environment Python not found at %s (project root: %s): %w", venvPython, projectRoot, err)
+// noteGUIDs returns the GUID of each card's note: the card's ID, or for a
+// card without one a GUID derived from the deck and the card's text. Cards
+// with the same text still need notes of their own, so repeated GUIDs get a
+// counter.
+func noteGUIDs(deck *Deck) []string {
+	guids := make([]string, len(deck.Cards))
+	seen := make(map[string]bool, len(deck.Cards))
+	for i, card := range deck.Cards {
+		guid := card.ID
+		if guid == "" {
+			guid = apkg.GUID(deck.Name, card.Question, card.Answer)
+		}
+		for n := 2; seen[guid]; n++ {
+			guid = apkg.GUID(deck.Name, card.Question, card.Answer, strconv.Itoa(n))
+		}
+		seen[guid] = true
+		guids[i] = guid
 	}
+	return guids
+}
 
-	// Get the path of the Python script
-	scriptPath := filepath.Join(projectRoot, "backend", "internal", "services", "anki", "generate_deck.py")
-	if _, err := os.Stat(scriptPath); err != nil {
-		return "", fmt.Errorf("Python script not found at %s (project root: %s): %w", scriptPath, projectRoot, err)
+// noteTags replaces the spaces in tags, which separate tags in Anki
+func noteTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.Join(strings.Fields(tag), "_"); tag != "" {
+			result = append(result, tag)
+		}
 	}
+	return result
+}
 
-	// Check if the CSV file exists
-	if _, err := os.Stat(csvPath); err != nil {
-		return "", fmt.Errorf("CSV file not found at %s: %w", csvPath, err)
+// cardModel returns the note type a card is exported with
+func cardModel(card Card) apkg.Model {
+	if card.Type == CardTypeCloze {
+		return apkg.ClozeModel()
 	}
-
-	// Make the script executable
-	if err := os.Chmod(scriptPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to make script executable at %s: %w", scriptPath, err)
+	switch card.NoteType {
+	case NoteTypeReversed:
+		return apkg.ReversedModel()
+	case NoteTypeOptionalReversed:
+		return apkg.OptionalReversedModel()
+	default:
+		return apkg.BasicModel()
 	}
+}
 
-	// Run the Python script with error logging using the virtual environment's Python
-	cmd := exec.Command(venvPython, scriptPath, csvPath, apkgPath)
-	cmd.Dir = projectRoot // Set working directory to project root
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate Anki deck: %w\nCommand output: %s\nWorking dir: %s\nScript path: %s\nCSV path: %s\nAPKG path: %s\nPython path: %s",
-			err, string(output), projectRoot, scriptPath, csvPath, apkgPath, venvPython)
+// sourceField renders a card's source as the HTML of the note's Source field
+func sourceField(source *Source) string {
+	if source == nil {
+		return ""
 	}
 
-	// Verify the file was created
-	if _, err := os.Stat(apkgPath); err != nil {
-		return "", fmt.Errorf("APKG file was not created at %s: %w\nScript output: %s", apkgPath, err, string(output))
+	var location []string
+	if source.File != "" {
+		location = append(location, html.EscapeString(source.File))
+	}
+	if source.Pages != "" {
+		prefix := "p. "
+		if strings.Contains(source.Pages, "-") {
+			prefix = "pp. "
+		}
+		location = append(location, prefix+html.EscapeString(source.Pages))
+	}
+	if source.Section != "" {
+		location = append(location, html.EscapeString(source.Section))
 	}
 
-	return apkgPath, nil
+	field := strings.Join(location, ", ")
+	if source.Excerpt != "" {
+		if field != "" {
+			field += "<br>"
+		}
+		field += "<i>" + html.EscapeString(source.Excerpt) + "</i>"
+	}
+	return field
 }
@@ -0,0 +1,30 @@
+package anki
+
+import "testing"
+
+func TestDeckPackageFields(t *testing.T) {
+	deck := &Deck{Name: "Math", Cards: []Card{
+		{Question: "Is a < b?", Answer: "Yes\nif b is larger"},
+		{Question: "Is a < b?", Answer: "Yes\nif b is larger"},
+		{Question: "<b>Bold</b> question", Answer: "x &amp; y"},
+	}}
+	pkg := deckPackage(deck)
+
+	if got := pkg.Notes[0].Fields[0]; got != "Is a &lt; b?" {
+		t.Errorf("question field = %q", got)
+	}
+	if got := pkg.Notes[0].Fields[1]; got != "Yes<br>if b is larger" {
+		t.Errorf("answer field = %q", got)
+	}
+	if got := pkg.Notes[2].Fields[0]; got != "<b>Bold</b> question" {
+		t.Errorf("HTML field = %q", got)
+	}
+
+	guids := make(map[string]bool)
+	for _, note := range pkg.Notes {
+		if guids[note.GUID] {
+			t.Errorf("GUID %q is used twice", note.GUID)
+		}
+		guids[note.GUID] = true
+	}
+}
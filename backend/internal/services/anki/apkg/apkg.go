@@ -0,0 +1,291 @@
+// Package apkg reads and writes Anki package files (.apkg) without any
+// external tooling. A package is a zip archive holding a SQLite collection
+// (collection.anki2), a "media" manifest and the numbered media files.
+package apkg
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelType distinguishes standard note types from cloze note types
+type ModelType int
+
+const (
+	ModelStandard ModelType = 0
+	ModelCloze    ModelType = 1
+)
+
+// Template is a card template of a note type
+type Template struct {
+	Name string
+	QFmt string
+	AFmt string
+}
+
+// Model is an Anki note type
+type Model struct {
+	ID        int64
+	Name      string
+	Type      ModelType
+	Fields    []string
+	Templates []Template
+	CSS       string
+}
+
+// Deck is an Anki deck
+type Deck struct {
+	ID          int64
+	Name        string
+	Description string
+}
+
+// Card holds the scheduling state of a single card generated from a note.
+// Type is 0 for new, 1 for learning, 2 for review and 3 for relearning
+// cards. Due is a position for new cards, a Unix time for learning cards and
+// a day counted from the collection's creation for review cards. Interval is
+// in days, or in seconds when negative, and Factor is the ease in permille.
+type Card struct {
+	ID       int64
+	Ord      int
+	DeckID   int64
+	Type     int
+	Queue    int
+	Due      int64
+	Interval int
+	Factor   int
+	Reps     int
+	Lapses   int
+}
+
+// Note is a single Anki note. When Cards is empty the writer generates new
+// cards from the note's model.
+type Note struct {
+	ID       int64
+	GUID     string
+	ModelID  int64
+	DeckID   int64
+	Fields   []string
+	Tags     []string
+	Modified time.Time
+	Cards    []Card
+}
+
+// Media is a file referenced from note fields
+type Media struct {
+	Name string
+	Data []byte
+}
+
+// Package is the in-memory representation of an .apkg file
+type Package struct {
+	Decks  []Deck
+	Models []Model
+	Notes  []Note
+	Media  []Media
+	// Created is the collection's creation day, which the due days of
+	// review cards count from. It is only set on packages that were read.
+	Created time.Time
+}
+
+// defaultCSS matches the styling Anki uses for its built-in note types
+const defaultCSS = `.card {
+  font-family: arial;
+  font-size: 20px;
+  text-align: center;
+  color: black;
+  background-color: white;
+}
+`
+
+// BasicModel returns the front/back note type used for generated cards. The
+// Source field shows where a card comes from below the answer.
+func BasicModel() Model {
+	return Model{
+		ID:        ModelID("AnkiCards Basic"),
+		Name:      "AnkiCards Basic",
+		Type:      ModelStandard,
+		Fields:    []string{"Question", "Answer", "Source"},
+		Templates: []Template{forwardTemplate},
+		CSS:       defaultCSS + sourceCSS,
+	}
+}
+
+// ReversedModel returns the note type of cards asked in both directions,
+// like Anki's "Basic (and reversed card)"
+func ReversedModel() Model {
+	return Model{
+		ID:        ModelID("AnkiCards Basic (and reversed card)"),
+		Name:      "AnkiCards Basic (and reversed card)",
+		Type:      ModelStandard,
+		Fields:    []string{"Question", "Answer", "Source"},
+		Templates: []Template{forwardTemplate, reverseTemplate("{{Answer}}")},
+		CSS:       defaultCSS + sourceCSS,
+	}
+}
+
+// OptionalReversedModel returns the note type of cards asked in reverse only
+// when their Add Reverse field is filled in, like Anki's "Basic (optional
+// reversed card)"
+func OptionalReversedModel() Model {
+	return Model{
+		ID:        ModelID("AnkiCards Basic (optional reversed card)"),
+		Name:      "AnkiCards Basic (optional reversed card)",
+		Type:      ModelStandard,
+		Fields:    []string{"Question", "Answer", "Source", "Add Reverse"},
+		Templates: []Template{forwardTemplate, reverseTemplate("{{#Add Reverse}}{{Answer}}{{/Add Reverse}}")},
+		CSS:       defaultCSS + sourceCSS,
+	}
+}
+
+// forwardTemplate asks for the answer to a question
+var forwardTemplate = Template{
+	Name: "Card 1",
+	QFmt: "{{Question}}",
+	AFmt: `{{FrontSide}}<hr id="answer">{{Answer}}{{#Source}}<div class="source">{{Source}}</div>{{/Source}}`,
+}
+
+// reverseTemplate asks for the question to an answer. Anki only creates the
+// card when the fields in front are not empty.
+func reverseTemplate(front string) Template {
+	return Template{
+		Name: "Card 2",
+		QFmt: front,
+		AFmt: `{{FrontSide}}<hr id="answer">{{Question}}{{#Source}}<div class="source">{{Source}}</div>{{/Source}}`,
+	}
+}
+
+// ClozeModel returns the cloze note type used for cloze cards. Text holds
+// the {{c1::...}} deletions, one card is generated per cloze number.
+func ClozeModel() Model {
+	return Model{
+		ID:     ModelID("AnkiCards Cloze"),
+		Name:   "AnkiCards Cloze",
+		Type:   ModelCloze,
+		Fields: []string{"Text", "Back Extra", "Source"},
+		Templates: []Template{{
+			Name: "Cloze",
+			QFmt: "{{cloze:Text}}",
+			AFmt: `{{cloze:Text}}{{#Back Extra}}<br>{{Back Extra}}{{/Back Extra}}{{#Source}}<div class="source">{{Source}}</div>{{/Source}}`,
+		}},
+		CSS: defaultCSS + clozeCSS + sourceCSS,
+	}
+}
+
+// clozeCSS matches the styling of Anki's built-in cloze note type
+const clozeCSS = `.cloze {
+  font-weight: bold;
+  color: blue;
+}
+.nightMode .cloze {
+  color: lightblue;
+}
+`
+
+// sourceCSS styles the Source field as a small note below the answer
+const sourceCSS = `.source {
+  margin-top: 1em;
+  font-size: 14px;
+  color: grey;
+}
+`
+
+// DeckID derives a stable deck ID from the deck name, so exporting the same
+// deck twice updates it in Anki instead of creating a copy.
+func DeckID(name string) int64 {
+	return stableID("deck\x1f" + name)
+}
+
+// ModelID derives a stable note type ID from the note type name
+func ModelID(name string) int64 {
+	return stableID("model\x1f" + name)
+}
+
+// stableID maps a key into the range Anki and genanki use for generated IDs
+func stableID(key string) int64 {
+	sum := sha256.Sum256([]byte(key))
+	n := binary.BigEndian.Uint32(sum[:4])
+	return int64(n%(1<<30)) + 1<<30
+}
+
+const guidAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&()*+,-./:;<=>?@[]^_`{|}~"
+
+// GUID derives a stable note GUID from the given values
+func GUID(values ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x1f")))
+	n := binary.BigEndian.Uint64(sum[:8])
+
+	var sb strings.Builder
+	for n > 0 {
+		sb.WriteByte(guidAlphabet[n%uint64(len(guidAlphabet))])
+		n /= uint64(len(guidAlphabet))
+	}
+
+	// Digits were produced least significant first
+	digits := []byte(sb.String())
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML removes tags the way Anki does for the sort field and checksum
+func stripHTML(s string) string {
+	return strings.TrimSpace(htmlTagPattern.ReplaceAllString(s, ""))
+}
+
+// fieldChecksum computes the "csum" column Anki uses for duplicate checks
+func fieldChecksum(field string) int64 {
+	sum := sha1.Sum([]byte(stripHTML(field)))
+	n, _ := strconv.ParseInt(hex.EncodeToString(sum[:])[:8], 16, 64)
+	return n
+}
+
+var templateFieldPattern = regexp.MustCompile(`{{([#^/]?)([^}]+)}}`)
+
+// templateFields returns the fields a template references, split into
+// conditional section fields and plain replacements
+func templateFields(format string) (sections []string, plain []string) {
+	for _, m := range templateFieldPattern.FindAllStringSubmatch(format, -1) {
+		name := strings.TrimSpace(m[2])
+		// Drop filters like "text:" or "type:" and keep the field name
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+		switch m[1] {
+		case "#":
+			sections = append(sections, name)
+		case "":
+			plain = append(plain, name)
+		}
+	}
+	return sections, plain
+}
+
+var clozePattern = regexp.MustCompile(`{{c(\d+)::`)
+
+// clozeOrdinals returns the distinct cloze numbers used in the fields, as
+// zero-based card ordinals
+func clozeOrdinals(fields []string) []int {
+	seen := make(map[int]bool)
+	var ords []int
+	for _, field := range fields {
+		for _, m := range clozePattern.FindAllStringSubmatch(field, -1) {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n < 1 || seen[n] {
+				continue
+			}
+			seen[n] = true
+			ords = append(ords, n-1)
+		}
+	}
+	return ords
+}
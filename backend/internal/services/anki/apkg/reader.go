@@ -0,0 +1,328 @@
+package apkg
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedFormat is returned for packages that only contain the
+// compressed collection format introduced in Anki 2.1.50
+var ErrUnsupportedFormat = errors.New("unsupported package format: export with \"Support older Anki versions\" enabled")
+
+// ErrTooLarge is returned for packages whose collection or media exceed the
+// size limits below once decompressed
+var ErrTooLarge = errors.New("package too large")
+
+// Limits of the decompressed package contents, so a small upload cannot
+// fill the disk or memory
+const (
+	maxCollectionSize = 1 << 30
+	maxMediaFileSize  = 100 << 20
+	maxMediaSize      = 1 << 30
+)
+
+// ReadFile reads an .apkg or .colpkg file from disk
+func ReadFile(path string) (*Package, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat package: %w", err)
+	}
+	return Read(file, info.Size())
+}
+
+// Read decodes an .apkg or .colpkg archive
+func Read(r io.ReaderAt, size int64) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	entries := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	// Newer exports carry both a legacy collection and the real one
+	collection := entries["collection.anki21"]
+	if collection == nil {
+		collection = entries["collection.anki2"]
+	}
+	if collection == nil {
+		if entries["collection.anki21b"] != nil {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, fmt.Errorf("archive contains no Anki collection")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "apkg-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "collection.anki2")
+	if err := extractFile(collection, dbPath); err != nil {
+		return nil, err
+	}
+
+	pkg, err := readCollection(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest := entries["media"]; manifest != nil {
+		media, err := readMedia(manifest, entries)
+		if err != nil {
+			return nil, err
+		}
+		pkg.Media = media
+	}
+
+	return pkg, nil
+}
+
+func extractFile(f *zip.File, dest string) error {
+	src, err := openLimited(f, maxCollectionSize)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	if n > maxCollectionSize {
+		return fmt.Errorf("%w: %s exceeds %d bytes", ErrTooLarge, f.Name, int64(maxCollectionSize))
+	}
+	return nil
+}
+
+// openLimited opens a zip entry for reading at most limit+1 bytes, so
+// callers can tell an entry that exceeds limit. Entries whose header
+// already declares a larger size are rejected.
+func openLimited(f *zip.File, limit int64) (io.ReadCloser, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrTooLarge, f.Name, limit)
+	}
+	src, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(src, limit+1), src}, nil
+}
+
+func readMedia(manifest *zip.File, entries map[string]*zip.File) ([]Media, error) {
+	src, err := openLimited(manifest, maxMediaFileSize)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var names map[string]string
+	if err := json.NewDecoder(src).Decode(&names); err != nil {
+		return nil, fmt.Errorf("failed to read media manifest: %w", err)
+	}
+
+	keys := make([]string, 0, len(names))
+	for key := range names {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+
+	media := make([]Media, 0, len(keys))
+	var total int64
+	for _, key := range keys {
+		f := entries[key]
+		if f == nil {
+			continue
+		}
+		rc, err := openLimited(f, maxMediaFileSize)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read media %s: %w", names[key], err)
+		}
+		if len(data) > maxMediaFileSize {
+			return nil, fmt.Errorf("%w: media %s exceeds %d bytes", ErrTooLarge, names[key], maxMediaFileSize)
+		}
+		if total += int64(len(data)); total > maxMediaSize {
+			return nil, fmt.Errorf("%w: media exceeds %d bytes", ErrTooLarge, int64(maxMediaSize))
+		}
+		media = append(media, Media{Name: names[key], Data: data})
+	}
+	return media, nil
+}
+
+// flexInt accepts IDs stored either as JSON numbers or strings, as older
+// collections do both
+type flexInt int64
+
+func (f *flexInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+type modelJSON struct {
+	ID   flexInt `json:"id"`
+	Name string  `json:"name"`
+	Type int     `json:"type"`
+	CSS  string  `json:"css"`
+	Flds []struct {
+		Name string `json:"name"`
+		Ord  int    `json:"ord"`
+	} `json:"flds"`
+	Tmpls []struct {
+		Name string `json:"name"`
+		Ord  int    `json:"ord"`
+		QFmt string `json:"qfmt"`
+		AFmt string `json:"afmt"`
+	} `json:"tmpls"`
+}
+
+type deckJSON struct {
+	ID   flexInt `json:"id"`
+	Name string  `json:"name"`
+	Desc string  `json:"desc"`
+}
+
+func readCollection(dbPath string) (*Package, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open collection: %w", err)
+	}
+	defer db.Close()
+
+	var crt int64
+	var modelsData, decksData string
+	if err := db.QueryRow(`SELECT crt, models, decks FROM col LIMIT 1`).Scan(&crt, &modelsData, &decksData); err != nil {
+		return nil, fmt.Errorf("failed to read collection: %w", err)
+	}
+
+	pkg := &Package{Created: time.Unix(crt, 0)}
+
+	var models map[string]modelJSON
+	if err := json.Unmarshal([]byte(modelsData), &models); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+	for _, m := range models {
+		model := Model{ID: int64(m.ID), Name: m.Name, Type: ModelType(m.Type), CSS: m.CSS}
+		sort.Slice(m.Flds, func(i, j int) bool { return m.Flds[i].Ord < m.Flds[j].Ord })
+		for _, f := range m.Flds {
+			model.Fields = append(model.Fields, f.Name)
+		}
+		sort.Slice(m.Tmpls, func(i, j int) bool { return m.Tmpls[i].Ord < m.Tmpls[j].Ord })
+		for _, t := range m.Tmpls {
+			model.Templates = append(model.Templates, Template{Name: t.Name, QFmt: t.QFmt, AFmt: t.AFmt})
+		}
+		pkg.Models = append(pkg.Models, model)
+	}
+	sort.Slice(pkg.Models, func(i, j int) bool { return pkg.Models[i].ID < pkg.Models[j].ID })
+
+	var decks map[string]deckJSON
+	if err := json.Unmarshal([]byte(decksData), &decks); err != nil {
+		return nil, fmt.Errorf("failed to decode decks: %w", err)
+	}
+	for _, d := range decks {
+		pkg.Decks = append(pkg.Decks, Deck{ID: int64(d.ID), Name: d.Name, Description: d.Desc})
+	}
+	sort.Slice(pkg.Decks, func(i, j int) bool { return pkg.Decks[i].Name < pkg.Decks[j].Name })
+
+	cards, err := readCards(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, guid, mid, mod, tags, flds FROM notes ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note Note
+		var mod int64
+		var tags, flds string
+		if err := rows.Scan(&note.ID, &note.GUID, &note.ModelID, &mod, &tags, &flds); err != nil {
+			return nil, fmt.Errorf("failed to read note: %w", err)
+		}
+		note.Modified = time.Unix(mod, 0)
+		note.Tags = strings.Fields(tags)
+		note.Fields = strings.Split(flds, "\x1f")
+		note.Cards = cards[note.ID]
+		if len(note.Cards) > 0 {
+			note.DeckID = note.Cards[0].DeckID
+		}
+		pkg.Notes = append(pkg.Notes, note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+
+	return pkg, nil
+}
+
+// readCards loads every card grouped by note ID
+func readCards(db *sql.DB) (map[int64][]Card, error) {
+	rows, err := db.Query(`SELECT id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses FROM cards ORDER BY nid, ord`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	defer rows.Close()
+
+	cards := make(map[int64][]Card)
+	for rows.Next() {
+		var card Card
+		var noteID int64
+		if err := rows.Scan(&card.ID, &noteID, &card.DeckID, &card.Ord, &card.Type, &card.Queue,
+			&card.Due, &card.Interval, &card.Factor, &card.Reps, &card.Lapses); err != nil {
+			return nil, fmt.Errorf("failed to read card: %w", err)
+		}
+		cards[noteID] = append(cards[noteID], card)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	return cards, nil
+}
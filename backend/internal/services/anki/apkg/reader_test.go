@@ -0,0 +1,25 @@
+package apkg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadRejectsOversizedMedia(t *testing.T) {
+	deck := Deck{ID: DeckID("Big"), Name: "Big"}
+	pkg := &Package{
+		Decks:  []Deck{deck},
+		Models: []Model{BasicModel()},
+		Media:  []Media{{Name: "big.bin", Data: make([]byte, maxMediaFileSize+1)}},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, pkg); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Read error = %v, want ErrTooLarge", err)
+	}
+}
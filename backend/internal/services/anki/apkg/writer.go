@@ -0,0 +1,372 @@
+package apkg
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Write encodes the package as an .apkg archive
+func Write(w io.Writer, pkg *Package) error {
+	if len(pkg.Decks) == 0 {
+		return fmt.Errorf("package has no decks")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "apkg-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "collection.anki2")
+	if err := writeCollection(dbPath, pkg); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	if err := addFile(zw, "collection.anki2", dbPath); err != nil {
+		return err
+	}
+
+	// The media manifest maps archive entry names to the original file names
+	manifest := make(map[string]string, len(pkg.Media))
+	for i, media := range pkg.Media {
+		entry := strconv.Itoa(i)
+		manifest[entry] = media.Name
+		fw, err := zw.Create(entry)
+		if err != nil {
+			return fmt.Errorf("failed to add media %s: %w", media.Name, err)
+		}
+		if _, err := fw.Write(media.Data); err != nil {
+			return fmt.Errorf("failed to add media %s: %w", media.Name, err)
+		}
+	}
+
+	fw, err := zw.Create("media")
+	if err != nil {
+		return fmt.Errorf("failed to add media manifest: %w", err)
+	}
+	if err := json.NewEncoder(fw).Encode(manifest); err != nil {
+		return fmt.Errorf("failed to add media manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// WriteFile writes the package to an .apkg file at path
+func WriteFile(path string, pkg *Package) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+
+	if err := Write(file, pkg); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func addFile(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer src.Close()
+
+	fw, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := io.Copy(fw, src); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeCollection creates the SQLite collection database at dbPath
+func writeCollection(dbPath string, pkg *Package) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open collection: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(collectionSchema); err != nil {
+		return fmt.Errorf("failed to create collection schema: %w", err)
+	}
+
+	now := time.Now()
+	models := make(map[int64]Model, len(pkg.Models))
+	for _, model := range pkg.Models {
+		models[model.ID] = model
+	}
+
+	modelsJSON, err := encodeModels(pkg, now)
+	if err != nil {
+		return err
+	}
+	decksJSON, err := encodeDecks(pkg.Decks, now)
+	if err != nil {
+		return err
+	}
+
+	crt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+	if _, err := tx.Exec(
+		`INSERT INTO col VALUES (1, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		crt, now.UnixMilli(), now.UnixMilli(), schemaVersion,
+		defaultConf, modelsJSON, decksJSON, defaultDeckConf,
+	); err != nil {
+		return fmt.Errorf("failed to write collection: %w", err)
+	}
+
+	// Anki IDs are millisecond timestamps; hand out consecutive values so
+	// notes and cards written in the same call never collide
+	nextID := now.UnixMilli()
+	newID := func() int64 {
+		nextID++
+		return nextID
+	}
+
+	defaultDeck := pkg.Decks[0].ID
+	for i, note := range pkg.Notes {
+		model, ok := models[note.ModelID]
+		if !ok {
+			return fmt.Errorf("note %d uses unknown model %d", i, note.ModelID)
+		}
+		if len(note.Fields) != len(model.Fields) {
+			return fmt.Errorf("note %d has %d fields, model %q expects %d", i, len(note.Fields), model.Name, len(model.Fields))
+		}
+
+		noteID := note.ID
+		if noteID == 0 {
+			noteID = newID()
+		}
+		guid := note.GUID
+		if guid == "" {
+			guid = GUID(note.Fields...)
+		}
+		deckID := note.DeckID
+		if deckID == 0 {
+			deckID = defaultDeck
+		}
+		mod := note.Modified
+		if mod.IsZero() {
+			mod = now
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+			noteID, guid, model.ID, mod.Unix(), encodeTags(note.Tags),
+			strings.Join(note.Fields, "\x1f"), stripHTML(note.Fields[0]), fieldChecksum(note.Fields[0]),
+		); err != nil {
+			return fmt.Errorf("failed to write note %d: %w", i, err)
+		}
+
+		cards := note.Cards
+		if len(cards) == 0 {
+			for _, ord := range cardOrdinals(model, note.Fields) {
+				cards = append(cards, Card{Ord: ord, Due: int64(i + 1)})
+			}
+		}
+		for _, card := range cards {
+			cardID := card.ID
+			if cardID == 0 {
+				cardID = newID()
+			}
+			cardDeck := card.DeckID
+			if cardDeck == 0 {
+				cardDeck = deckID
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO cards VALUES (?, ?, ?, ?, ?, -1, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, 0, '')`,
+				cardID, noteID, cardDeck, card.Ord, mod.Unix(), card.Type, card.Queue,
+				card.Due, card.Interval, card.Factor, card.Reps, card.Lapses,
+			); err != nil {
+				return fmt.Errorf("failed to write card for note %d: %w", i, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit collection: %w", err)
+	}
+	return nil
+}
+
+func encodeTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(tags, " ") + " "
+}
+
+// cardOrdinals returns the template ordinals Anki would generate cards for
+func cardOrdinals(model Model, fields []string) []int {
+	if model.Type == ModelCloze {
+		return clozeOrdinals(fields)
+	}
+
+	values := make(map[string]string, len(model.Fields))
+	for i, name := range model.Fields {
+		values[name] = strings.TrimSpace(fields[i])
+	}
+
+	var ords []int
+	for ord, tmpl := range model.Templates {
+		kind, required := templateRequirement(model, tmpl)
+		matched := 0
+		for _, idx := range required {
+			if values[model.Fields[idx]] != "" {
+				matched++
+			}
+		}
+		if (kind == "all" && matched == len(required) && matched > 0) || (kind == "any" && matched > 0) {
+			ords = append(ords, ord)
+		}
+	}
+	return ords
+}
+
+// templateRequirement computes the "req" entry of a template: which fields
+// must be non-empty for Anki to generate the card
+func templateRequirement(model Model, tmpl Template) (string, []int) {
+	index := make(map[string]int, len(model.Fields))
+	for i, name := range model.Fields {
+		index[name] = i
+	}
+
+	sections, plain := templateFields(tmpl.QFmt)
+	kind := "any"
+	names := plain
+	if len(sections) > 0 {
+		kind = "all"
+		names = append(sections, plain...)
+	}
+
+	seen := make(map[int]bool)
+	required := []int{}
+	for _, name := range names {
+		if i, ok := index[name]; ok && !seen[i] {
+			seen[i] = true
+			required = append(required, i)
+		}
+	}
+	return kind, required
+}
+
+func encodeModels(pkg *Package, now time.Time) (string, error) {
+	models := make(map[string]interface{}, len(pkg.Models))
+	for _, model := range pkg.Models {
+		flds := make([]map[string]interface{}, len(model.Fields))
+		for i, name := range model.Fields {
+			flds[i] = map[string]interface{}{
+				"name":   name,
+				"ord":    i,
+				"font":   "Arial",
+				"size":   20,
+				"media":  []string{},
+				"rtl":    false,
+				"sticky": false,
+			}
+		}
+
+		tmpls := make([]map[string]interface{}, len(model.Templates))
+		req := make([]interface{}, 0, len(model.Templates))
+		for i, tmpl := range model.Templates {
+			tmpls[i] = map[string]interface{}{
+				"name":  tmpl.Name,
+				"ord":   i,
+				"qfmt":  tmpl.QFmt,
+				"afmt":  tmpl.AFmt,
+				"bqfmt": "",
+				"bafmt": "",
+				"did":   nil,
+			}
+			kind, required := templateRequirement(model, tmpl)
+			req = append(req, []interface{}{i, kind, required})
+		}
+
+		css := model.CSS
+		if css == "" {
+			css = defaultCSS
+		}
+
+		models[strconv.FormatInt(model.ID, 10)] = map[string]interface{}{
+			"id":        model.ID,
+			"name":      model.Name,
+			"type":      int(model.Type),
+			"mod":       now.Unix(),
+			"usn":       -1,
+			"sortf":     0,
+			"did":       pkg.Decks[0].ID,
+			"tmpls":     tmpls,
+			"flds":      flds,
+			"css":       css,
+			"latexPre":  latexPre,
+			"latexPost": latexPost,
+			"req":       req,
+			"tags":      []string{},
+			"vers":      []string{},
+		}
+	}
+
+	data, err := json.Marshal(models)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode models: %w", err)
+	}
+	return string(data), nil
+}
+
+func encodeDecks(decks []Deck, now time.Time) (string, error) {
+	entries := map[string]interface{}{
+		"1": deckEntry(Deck{ID: 1, Name: "Default"}, now),
+	}
+	for _, deck := range decks {
+		entries[strconv.FormatInt(deck.ID, 10)] = deckEntry(deck, now)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode decks: %w", err)
+	}
+	return string(data), nil
+}
+
+func deckEntry(deck Deck, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        deck.ID,
+		"name":      deck.Name,
+		"desc":      deck.Description,
+		"mod":       now.Unix(),
+		"usn":       -1,
+		"conf":      1,
+		"dyn":       0,
+		"collapsed": false,
+		"extendNew": 10,
+		"extendRev": 50,
+		"newToday":  []int{0, 0},
+		"revToday":  []int{0, 0},
+		"lrnToday":  []int{0, 0},
+		"timeToday": []int{0, 0},
+	}
+}
@@ -0,0 +1,105 @@
+package apkg
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	basic, reversed, optional, cloze := BasicModel(), ReversedModel(), OptionalReversedModel(), ClozeModel()
+	deck := Deck{ID: DeckID("Biology"), Name: "Biology", Description: "Cells"}
+	subdeck := Deck{ID: DeckID("Biology::Cells"), Name: "Biology::Cells"}
+
+	pkg := &Package{
+		Decks:  []Deck{deck, subdeck},
+		Models: []Model{basic, reversed, optional, cloze},
+		Notes: []Note{
+			{ModelID: basic.ID, DeckID: deck.ID, Fields: []string{"What is ATP?", "Energy currency", ""}, Tags: []string{"energy"}},
+			{ModelID: reversed.ID, DeckID: subdeck.ID, Fields: []string{"Zelle", "cell", "p. 3"}},
+			{ModelID: optional.ID, DeckID: deck.ID, Fields: []string{"Mitosis", "Cell division", "", ""}},
+			{ModelID: optional.ID, DeckID: deck.ID, Fields: []string{"Osmosis", "Diffusion of water", "", "y"}},
+			{ModelID: cloze.ID, DeckID: deck.ID, Fields: []string{`{{c1::ATP}} is made in {{c2::mitochondria}} <img src="cell.png">`, "", ""}},
+		},
+		Media: []Media{
+			{Name: "cell.png", Data: []byte("\x89PNG fake image")},
+			{Name: "sound.mp3", Data: []byte("fake audio")},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, pkg); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	models := make(map[int64]Model)
+	for _, model := range got.Models {
+		models[model.ID] = model
+	}
+	for _, want := range pkg.Models {
+		model, ok := models[want.ID]
+		if !ok {
+			t.Errorf("model %q is missing", want.Name)
+			continue
+		}
+		if model.Name != want.Name || model.Type != want.Type || !reflect.DeepEqual(model.Fields, want.Fields) {
+			t.Errorf("model %q = %+v, want name, type and fields of %+v", want.Name, model, want)
+		}
+		if !reflect.DeepEqual(model.Templates, want.Templates) {
+			t.Errorf("model %q templates = %+v, want %+v", want.Name, model.Templates, want.Templates)
+		}
+	}
+
+	// The writer adds Anki's default deck
+	wantDecks := []Deck{deck, subdeck, {ID: 1, Name: "Default"}}
+	decks := make(map[int64]Deck)
+	for _, d := range got.Decks {
+		decks[d.ID] = d
+	}
+	if len(got.Decks) != len(wantDecks) {
+		t.Errorf("got %d decks, want %d: %+v", len(got.Decks), len(wantDecks), got.Decks)
+	}
+	for _, want := range wantDecks {
+		if decks[want.ID] != want {
+			t.Errorf("deck %d = %+v, want %+v", want.ID, decks[want.ID], want)
+		}
+	}
+
+	if len(got.Notes) != len(pkg.Notes) {
+		t.Fatalf("got %d notes, want %d", len(got.Notes), len(pkg.Notes))
+	}
+	wantOrds := [][]int{{0}, {0, 1}, {0}, {0, 1}, {0, 1}}
+	for i, note := range got.Notes {
+		want := pkg.Notes[i]
+		if note.ModelID != want.ModelID || note.DeckID != want.DeckID {
+			t.Errorf("note %d has model %d and deck %d, want %d and %d", i, note.ModelID, note.DeckID, want.ModelID, want.DeckID)
+		}
+		if !reflect.DeepEqual(note.Fields, want.Fields) {
+			t.Errorf("note %d fields = %q, want %q", i, note.Fields, want.Fields)
+		}
+		if note.GUID == "" {
+			t.Errorf("note %d has no GUID", i)
+		}
+		var ords []int
+		for _, card := range note.Cards {
+			ords = append(ords, card.Ord)
+			if card.DeckID != want.DeckID {
+				t.Errorf("card %d of note %d is in deck %d, want %d", card.Ord, i, card.DeckID, want.DeckID)
+			}
+		}
+		if !reflect.DeepEqual(ords, wantOrds[i]) {
+			t.Errorf("note %d card ordinals = %v, want %v", i, ords, wantOrds[i])
+		}
+	}
+	if !reflect.DeepEqual(got.Notes[0].Tags, []string{"energy"}) {
+		t.Errorf("note tags = %q", got.Notes[0].Tags)
+	}
+
+	if !reflect.DeepEqual(got.Media, pkg.Media) {
+		t.Errorf("media = %+v, want %+v", got.Media, pkg.Media)
+	}
+}
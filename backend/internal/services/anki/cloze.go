@@ -0,0 +1,89 @@
+package anki
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Errors returned for cards that cannot be exported
+var (
+	// ErrInvalidCloze is returned for cloze text Anki would not accept
+	ErrInvalidCloze    = errors.New("invalid cloze")
+	ErrInvalidNoteType = errors.New("invalid note type")
+	ErrInvalidCardType = errors.New("invalid card type")
+)
+
+// ValidateCloze checks the cloze deletions of a cloze card's text. Every
+// deletion has the form {{cN::text}} or {{cN::text::hint}} with N starting
+// at 1, and deletions may be nested.
+func ValidateCloze(text string) error {
+	deletions, open := 0, 0
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "{{c"):
+			j := i + 3
+			for j < len(text) && text[j] >= '0' && text[j] <= '9' {
+				j++
+			}
+			if j == i+3 {
+				// A field reference such as {{cause}}, not a deletion
+				i += 2
+				continue
+			}
+			n, err := strconv.Atoi(text[i+3 : j])
+			if err != nil || n < 1 {
+				return fmt.Errorf("%w: %q, cloze numbers start at 1", ErrInvalidCloze, text[i:j])
+			}
+			if !strings.HasPrefix(text[j:], "::") {
+				return fmt.Errorf("%w: %q is not followed by \"::\"", ErrInvalidCloze, text[i:j])
+			}
+			i = j + 2
+			if strings.HasPrefix(text[i:], "}}") || strings.HasPrefix(text[i:], "::") {
+				return fmt.Errorf("%w: c%d deletes nothing", ErrInvalidCloze, n)
+			}
+			deletions++
+			open++
+		case strings.HasPrefix(text[i:], "}}") && open > 0:
+			open--
+			i += 2
+		default:
+			i++
+		}
+	}
+
+	switch {
+	case deletions == 0:
+		return fmt.Errorf("%w: no {{c1::...}} deletion", ErrInvalidCloze)
+	case open > 0:
+		return fmt.Errorf("%w: %d deletion(s) not closed with \"}}\"", ErrInvalidCloze, open)
+	}
+	return nil
+}
+
+// ValidateCards checks the type of every card, the cloze text of cloze
+// cards and the note type of basic cards
+func ValidateCards(cards []Card) error {
+	for i, card := range cards {
+		if err := validateCard(card); err != nil {
+			return fmt.Errorf("card %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func validateCard(card Card) error {
+	switch card.Type {
+	case CardTypeCloze:
+		return ValidateCloze(card.Question)
+	case "", CardTypeBasic:
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidCardType, card.Type)
+	}
+	if card.NoteType != "" && !slices.Contains(NoteTypes, card.NoteType) {
+		return fmt.Errorf("%w: %q", ErrInvalidNoteType, card.NoteType)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package anki
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateCard(t *testing.T) {
+	tests := []struct {
+		card Card
+		want error
+	}{
+		{Card{Question: "Q", Answer: "A"}, nil},
+		{Card{Type: CardTypeBasic, NoteType: NoteTypeReversed, Question: "Q", Answer: "A"}, nil},
+		{Card{Type: CardTypeCloze, Question: "{{c1::ATP}} stores energy"}, nil},
+		{Card{Type: CardTypeCloze, Question: "ATP stores energy"}, ErrInvalidCloze},
+		{Card{NoteType: "sideways", Question: "Q", Answer: "A"}, ErrInvalidNoteType},
+		{Card{Type: "Cloze", Question: "{{c1::ATP}} stores energy"}, ErrInvalidCardType},
+		{Card{Type: "image_occlusion", Question: "Q", Answer: "A"}, ErrInvalidCardType},
+	}
+	for _, test := range tests {
+		err := validateCard(test.card)
+		if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("validateCard(%+v) = %v, want %v", test.card, err, test.want)
+		}
+	}
+}
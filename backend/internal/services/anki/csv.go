@@ -0,0 +1,237 @@
+package anki
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV columns of a deck file. Files written before provenance was recorded
+// only have the question and answer columns. Cloze cards keep their text in
+// the question column and extra information in the answer column. Tags are
+// separated by spaces as in Anki. Exported files also carry each card's ID
+// and its created and updated times.
+const (
+	columnID          = "ID"
+	columnQuestion    = "Question"
+	columnAnswer      = "Answer"
+	columnType        = "Type"
+	columnNoteType    = "Note Type"
+	columnAddReverse  = "Add Reverse"
+	columnTags        = "Tags"
+	columnSubdeck     = "Subdeck"
+	columnSourceFile  = "Source File"
+	columnSourcePages = "Source Pages"
+	columnSection     = "Section"
+	columnExcerpt     = "Excerpt"
+	columnDuplicateOf = "Duplicate Of"
+	columnDuplicateIn = "Duplicate Deck"
+	columnDupKind     = "Duplicate Kind"
+	columnSimilarity  = "Similarity"
+	columnGrounded    = "Grounded"
+	columnConfidence  = "Confidence"
+	columnJustify     = "Justification"
+	columnVerifiedBy  = "Verified By"
+	columnCreated     = "Created"
+	columnUpdated     = "Updated"
+)
+
+var csvColumns = []string{
+	columnID, columnQuestion, columnAnswer, columnType, columnNoteType, columnAddReverse, columnTags, columnSubdeck,
+	columnSourceFile, columnSourcePages, columnSection, columnExcerpt,
+	columnDuplicateOf, columnDuplicateIn, columnDupKind, columnSimilarity,
+	columnGrounded, columnConfidence, columnJustify, columnVerifiedBy,
+	columnCreated, columnUpdated,
+}
+
+// WriteCSV writes cards as a deck CSV file with a header row
+func WriteCSV(w io.Writer, cards []Card) error {
+	return writeTable(w, cards, ',')
+}
+
+// WriteTSV writes cards with the columns of a deck CSV file separated by
+// tabs
+func WriteTSV(w io.Writer, cards []Card) error {
+	return writeTable(w, cards, '\t')
+}
+
+func writeTable(w io.Writer, cards []Card, comma rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+
+	if err := writer.Write(csvColumns); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, card := range cards {
+		source := card.Source
+		if source == nil {
+			source = &Source{}
+		}
+		addReverse := ""
+		if card.AddReverse {
+			addReverse = "y"
+		}
+		record := []string{card.ID, card.Question, card.Answer, card.Type, card.NoteType, addReverse,
+			strings.Join(card.Tags, " "), card.Subdeck, source.File, source.Pages, source.Section, source.Excerpt}
+		if dup := card.Duplicate; dup != nil {
+			record = append(record, dup.Of, dup.Deck, dup.Kind, strconv.FormatFloat(dup.Similarity, 'f', -1, 64))
+		} else {
+			record = append(record, "", "", "", "")
+		}
+		if v := card.Verification; v != nil {
+			record = append(record, strconv.FormatBool(v.Grounded), strconv.FormatFloat(v.Confidence, 'f', -1, 64), v.Justification, v.Method)
+		} else {
+			record = append(record, "", "", "", "")
+		}
+		record = append(record, formatTime(card.Created), formatTime(card.Updated))
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write card to CSV: %w", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadCSVDeck loads a deck from a CSV file. Columns are looked up by their
+// header, so files with only "Question,Answer" can still be read. Cards
+// without an ID column are numbered by row.
+func ReadCSVDeck(csvPath string, deckName string) (*Deck, error) {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("CSV file not found at %s: %w", csvPath, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// Old files and hand-edited ones may have rows of different lengths
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat CSV file: %w", err)
+	}
+
+	deck := &Deck{
+		ID:      deckName,
+		Name:    deckName,
+		Created: info.ModTime(),
+	}
+	if len(records) == 0 {
+		return deck, nil
+	}
+
+	columns := csvColumnIndex(records[0])
+	for i, record := range records[1:] {
+		field := func(name string) string {
+			if index, ok := columns[name]; ok && index < len(record) {
+				return record[index]
+			}
+			return ""
+		}
+
+		card := Card{
+			ID:         field(columnID),
+			Question:   field(columnQuestion),
+			Answer:     field(columnAnswer),
+			Type:       strings.ToLower(field(columnType)),
+			NoteType:   strings.ToLower(field(columnNoteType)),
+			AddReverse: parseFlag(field(columnAddReverse)),
+			Tags:       strings.Fields(field(columnTags)),
+			Subdeck:    field(columnSubdeck),
+			Created:    parseTime(field(columnCreated), info.ModTime()),
+			Updated:    parseTime(field(columnUpdated), info.ModTime()),
+		}
+		if card.Question == "" && card.Answer == "" {
+			continue
+		}
+		if card.ID == "" {
+			card.ID = fmt.Sprintf("%d", i+1)
+		}
+		source := Source{
+			File:    field(columnSourceFile),
+			Pages:   field(columnSourcePages),
+			Section: field(columnSection),
+			Excerpt: field(columnExcerpt),
+		}
+		if source != (Source{}) {
+			card.Source = &source
+		}
+		if of := field(columnDuplicateOf); of != "" {
+			similarity, _ := strconv.ParseFloat(field(columnSimilarity), 64)
+			card.Duplicate = &Duplicate{
+				Of:         of,
+				Deck:       field(columnDuplicateIn),
+				Kind:       field(columnDupKind),
+				Similarity: similarity,
+			}
+		}
+		if grounded, err := strconv.ParseBool(field(columnGrounded)); err == nil {
+			confidence, _ := strconv.ParseFloat(field(columnConfidence), 64)
+			card.Verification = &Verification{
+				Grounded:      grounded,
+				Confidence:    confidence,
+				Justification: field(columnJustify),
+				Method:        field(columnVerifiedBy),
+			}
+		}
+		deck.Cards = append(deck.Cards, card)
+	}
+
+	return deck, nil
+}
+
+// csvColumnIndex maps column names to their index. Headers are matched
+// case-insensitively; a file without the known headers is read as
+// question and answer columns.
+func csvColumnIndex(header []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range header {
+		for _, column := range csvColumns {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				columns[column] = i
+			}
+		}
+	}
+	if _, ok := columns[columnQuestion]; !ok {
+		columns = map[string]int{columnQuestion: 0, columnAnswer: 1}
+	}
+	return columns
+}
+
+// formatTime writes a timestamp column, empty for unknown times
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTime reads a timestamp column, returning fallback for empty or
+// invalid values
+func parseTime(value string, fallback time.Time) time.Time {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return t
+}
+
+// parseFlag reads a yes/no column. Like Anki's "Add Reverse" field, any value
+// counts as yes unless it clearly says no.
+func parseFlag(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "n", "no", "false", "0":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package anki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/apkg"
+)
+
+// ErrInvalidFormat is returned for unknown export formats
+var ErrInvalidFormat = errors.New("invalid export format")
+
+// Export formats
+const (
+	FormatAPKG = "apkg"
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+	FormatJSON = "json"
+	// FormatText is Anki's "Notes in Plain Text" format
+	FormatText = "txt"
+)
+
+// exportFormat describes how a deck is written in a format
+type exportFormat struct {
+	contentType string
+	write       func(s *Service, w io.Writer, deck *Deck) error
+}
+
+var exportFormats = map[string]exportFormat{
+	FormatAPKG: {"application/octet-stream", (*Service).writeAPKG},
+	FormatCSV: {"text/csv; charset=utf-8", func(s *Service, w io.Writer, deck *Deck) error {
+		return WriteCSV(w, deck.Cards)
+	}},
+	FormatTSV: {"text/tab-separated-values; charset=utf-8", func(s *Service, w io.Writer, deck *Deck) error {
+		return WriteTSV(w, deck.Cards)
+	}},
+	FormatText: {"text/plain; charset=utf-8", func(s *Service, w io.Writer, deck *Deck) error {
+		return WriteNotesText(w, deck)
+	}},
+	FormatJSON: {"application/json", func(s *Service, w io.Writer, deck *Deck) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(deck)
+	}},
+}
+
+// Export is a deck ready to be written in an export format
+type Export struct {
+	Deck        *Deck
+	Format      string
+	ContentType string
+	service     *Service
+}
+
+// Filename is the name the export is downloaded as
+func (e *Export) Filename() string {
+	return e.Deck.Name + "." + e.Format
+}
+
+// Write writes the deck in the export's format. Card IDs, timestamps, tags
+// and note types are kept in every format.
+func (e *Export) Write(w io.Writer) error {
+	if err := exportFormats[e.Format].write(e.service, w, e.Deck); err != nil {
+		return fmt.Errorf("failed to export deck %s as %s: %w", e.Deck.ID, e.Format, err)
+	}
+	return nil
+}
+
+// ExportDeck prepares a deck for export as apkg, csv, tsv, txt or json. The
+// deck is loaded before anything is written, so a missing deck is reported
+// before the export starts.
+func (s *Service) ExportDeck(id string, format string) (*Export, error) {
+	format = strings.ToLower(format)
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q, expected apkg, csv, tsv, txt or json", ErrInvalidFormat, format)
+	}
+	deck, err := s.repo.GetDeck(id)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{Deck: deck, Format: format, ContentType: exportFormat.contentType, service: s}, nil
+}
+
+// writeAPKG writes a deck as an Anki package with the media files its cards
+// reference
+func (s *Service) writeAPKG(w io.Writer, deck *Deck) error {
+	pkg := deckPackage(deck)
+	media, err := s.deckMedia(deck)
+	if err != nil {
+		return err
+	}
+	pkg.Media = media
+	return apkg.Write(w, pkg)
+}
+
+// deckMedia reads the media files referenced from the cards of a deck.
+// References to files that are not in the media directory are left out.
+func (s *Service) deckMedia(deck *Deck) ([]apkg.Media, error) {
+	seen := make(map[string]bool)
+	var media []apkg.Media
+	for _, card := range deck.Cards {
+		for _, match := range mediaReference.FindAllStringSubmatch(card.Question+"\n"+card.Answer, -1) {
+			name := match[2] + match[3]
+			path := s.MediaPath(name)
+			if path == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read media file %s: %w", name, err)
+			}
+			media = append(media, apkg.Media{Name: name, Data: data})
+		}
+	}
+	return media, nil
+}
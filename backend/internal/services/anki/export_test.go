@@ -0,0 +1,273 @@
+package anki
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/apkg"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exportDeck has a card of every type and note type, and cards with the
+// fields that need quoting, escaping or a generated GUID
+func exportDeck() *Deck {
+	created := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	updated := created.Add(48 * time.Hour)
+	return &Deck{ID: "biology", Name: "Biology", Created: created, Updated: updated, Cards: []Card{
+		{
+			ID: "c1", Question: "What is ATP?", Answer: "The energy currency\nof the cell",
+			Tags: []string{"energy", "cell biology"}, Subdeck: "Cells::Energy",
+			Source:       &Source{File: "biology.pdf", Pages: "4-5", Section: "2.1 Respiration", Excerpt: "ATP stores energy"},
+			Verification: &Verification{Grounded: true, Confidence: 0.9, Justification: "Stated on page 4", Method: "llm"},
+			Review:       &srs.State{Phase: srs.PhaseReview, Due: updated.Add(72 * time.Hour), LastReview: updated, Interval: 3, Reps: 2},
+			Created:      created, Updated: updated,
+		},
+		{
+			ID: "c2", Question: "Is a < b?", Answer: "Yes, \"always\"\tif b is larger",
+			Type: CardTypeBasic, NoteType: NoteTypeReversed,
+			Duplicate: &Duplicate{Of: "Is b > a?", Deck: "Math", Kind: "semantic", Similarity: 0.93},
+			Created:   created, Updated: created,
+		},
+		{
+			ID: "c3", Question: "Mitochondria", Answer: "<b>Powerhouse</b> of the cell",
+			NoteType: NoteTypeOptionalReversed, AddReverse: true, Created: created, Updated: created,
+		},
+		{
+			ID: "c4", Question: "{{c1::Ribosomes}} make proteins", Answer: "Extra: on the ER",
+			Type: CardTypeCloze, Tags: []string{"proteins"}, Subdeck: " Cells ", Created: created, Updated: created,
+		},
+		// Cards without an ID, the second one with the same text
+		{Question: "What is DNA?", Answer: "A double helix", Created: created, Updated: created},
+		{Question: "What is DNA?", Answer: "A double helix", Created: created, Updated: created},
+	}}
+}
+
+// basicDeck only has cards of Anki's Basic note type
+func basicDeck() *Deck {
+	created := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	return &Deck{ID: "math", Name: "Math", Created: created, Updated: created, Cards: []Card{
+		{ID: "m1", Question: "2 + 2", Answer: "4", Tags: []string{"arithmetic"}, Created: created, Updated: created},
+		{ID: "m2", Question: "Pi to two decimals", Answer: "3.14", NoteType: NoteTypeBasic, Subdeck: "Constants", Created: created, Updated: created},
+	}}
+}
+
+// checkGolden compares got with testdata/name, or rewrites the file with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestExportGolden(t *testing.T) {
+	s := NewService(nil, nil, t.TempDir())
+	tests := []struct {
+		golden string
+		format string
+		deck   *Deck
+	}{
+		{"export.csv", FormatCSV, exportDeck()},
+		{"export.tsv", FormatTSV, exportDeck()},
+		{"export.json", FormatJSON, exportDeck()},
+		{"export.txt", FormatText, exportDeck()},
+		{"export_basic.txt", FormatText, basicDeck()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := exportFormats[tt.format].write(s, &buf, tt.deck); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteNotesTextHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		deck *Deck
+		want []string
+	}{
+		{
+			name: "one note type",
+			deck: basicDeck(),
+			want: []string{"#separator:tab", "#html:true", "#guid column:1", "#notetype:Basic", "#deck column:2", "#tags column:3"},
+		},
+		{
+			name: "cloze cards only",
+			deck: &Deck{Name: "Cloze", Cards: []Card{{ID: "x", Question: "{{c1::A}}", Type: CardTypeCloze}}},
+			want: []string{"#separator:tab", "#html:true", "#guid column:1", "#notetype:Cloze", "#deck column:2", "#tags column:3"},
+		},
+		{
+			name: "mixed note types",
+			deck: exportDeck(),
+			want: []string{"#separator:tab", "#html:true", "#guid column:1", "#notetype column:2", "#deck column:3", "#tags column:4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteNotesText(&buf, tt.deck); err != nil {
+				t.Fatalf("WriteNotesText: %v", err)
+			}
+			lines := strings.Split(buf.String(), "\n")
+			if got := lines[:len(tt.want)]; !slices.Equal(got, tt.want) {
+				t.Errorf("headers = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportGUIDs(t *testing.T) {
+	deck := exportDeck()
+
+	var text bytes.Buffer
+	if err := WriteNotesText(&text, deck); err != nil {
+		t.Fatalf("WriteNotesText: %v", err)
+	}
+	var textGUIDs []string
+	for _, line := range strings.Split(strings.TrimSpace(text.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			guid, _, _ := strings.Cut(line, "\t")
+			textGUIDs = append(textGUIDs, strings.Trim(guid, `"`))
+		}
+	}
+
+	var packageGUIDs []string
+	for _, note := range deckPackage(deck).Notes {
+		packageGUIDs = append(packageGUIDs, note.GUID)
+	}
+
+	// Both exports give a note the same GUID, so importing either one
+	// updates the notes of the other
+	if !slices.Equal(textGUIDs, packageGUIDs) {
+		t.Errorf("text GUIDs %q differ from package GUIDs %q", textGUIDs, packageGUIDs)
+	}
+	if textGUIDs[0] != "c1" {
+		t.Errorf("card ID was not kept as GUID: %q", textGUIDs[0])
+	}
+	generated := textGUIDs[4:]
+	if generated[0] == "" || generated[1] == "" || generated[0] == generated[1] {
+		t.Errorf("cards without ID got GUIDs %q, want two different ones", generated)
+	}
+	if want := apkg.GUID(deck.Name, "What is DNA?", "A double helix"); generated[0] != want {
+		t.Errorf("GUID = %q, want %q derived from the card's text", generated[0], want)
+	}
+
+	// GUIDs are stable across exports
+	var again bytes.Buffer
+	if err := WriteNotesText(&again, exportDeck()); err != nil {
+		t.Fatalf("WriteNotesText: %v", err)
+	}
+	if again.String() != text.String() {
+		t.Errorf("exporting the same deck twice gave different files")
+	}
+}
+
+func TestExportAPKG(t *testing.T) {
+	mediaDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(mediaDir, "cell.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(nil, nil, mediaDir)
+	deck := exportDeck()
+	deck.Cards[0].Answer += `<img src="cell.png"><img src="missing.png">`
+
+	var buf bytes.Buffer
+	if err := s.writeAPKG(&buf, deck); err != nil {
+		t.Fatalf("writeAPKG: %v", err)
+	}
+	pkg, err := apkg.Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("apkg.Read: %v", err)
+	}
+
+	var decks []string
+	deckNames := make(map[int64]string)
+	for _, d := range pkg.Decks {
+		decks = append(decks, d.Name)
+		deckNames[d.ID] = d.Name
+	}
+	for _, name := range []string{"Biology", "Biology::Cells", "Biology::Cells::Energy"} {
+		if !slices.Contains(decks, name) {
+			t.Errorf("package decks %q lack %q", decks, name)
+		}
+	}
+
+	if len(pkg.Notes) != len(deck.Cards) {
+		t.Fatalf("package has %d notes, want %d", len(pkg.Notes), len(deck.Cards))
+	}
+	notes := make(map[string]apkg.Note)
+	for _, note := range pkg.Notes {
+		notes[note.GUID] = note
+	}
+	first, ok := notes["c1"]
+	if !ok {
+		t.Fatalf("no note with the card's ID as GUID")
+	}
+	if deckNames[first.DeckID] != "Biology::Cells::Energy" {
+		t.Errorf("note is in deck %q", deckNames[first.DeckID])
+	}
+	if first.Fields[0] != "What is ATP?" || !strings.Contains(first.Fields[1], `<img src="cell.png">`) {
+		t.Errorf("fields = %q", first.Fields)
+	}
+	if !slices.Equal(first.Tags, []string{"energy", "cell_biology"}) {
+		t.Errorf("tags = %q", first.Tags)
+	}
+	if deckNames[notes["c4"].DeckID] != "Biology::Cells" {
+		t.Errorf("cloze note is in deck %q", deckNames[notes["c4"].DeckID])
+	}
+
+	if len(pkg.Media) != 1 || pkg.Media[0].Name != "cell.png" || string(pkg.Media[0].Data) != "png" {
+		t.Errorf("media = %+v, want cell.png only", pkg.Media)
+	}
+}
+
+func TestExportDeckFormats(t *testing.T) {
+	repo, err := NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileRepository: %v", err)
+	}
+	if err := repo.SaveDeck(basicDeck()); err != nil {
+		t.Fatalf("SaveDeck: %v", err)
+	}
+	s := NewService(repo, nil, t.TempDir())
+
+	export, err := s.ExportDeck("math", "CSV")
+	if err != nil {
+		t.Fatalf("ExportDeck: %v", err)
+	}
+	if export.Filename() != "Math.csv" || export.ContentType != "text/csv; charset=utf-8" {
+		t.Errorf("export = %s as %s", export.Filename(), export.ContentType)
+	}
+	if _, err := s.ExportDeck("math", "pdf"); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("ExportDeck as pdf = %v, want ErrInvalidFormat", err)
+	}
+	if _, err := s.ExportDeck("missing", "csv"); !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("ExportDeck of a missing deck = %v, want ErrDeckNotFound", err)
+	}
+}
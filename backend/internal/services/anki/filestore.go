@@ -0,0 +1,281 @@
+package anki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+// FileRepository stores every deck as a <id>.json file
+type FileRepository struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileRepository creates a repository storing decks in dir
+func NewFileRepository(dir string) (*FileRepository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create decks directory: %w", err)
+	}
+	return &FileRepository{dir: dir}, nil
+}
+
+// ListDecks implements Repository
+func (r *FileRepository) ListDecks() ([]Deck, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decks directory: %w", err)
+	}
+
+	decks := []Deck{}
+	for _, file := range files {
+		deck, err := r.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			continue
+		}
+		deck.CardCount = len(deck.Cards)
+		deck.Cards = nil
+		decks = append(decks, *deck)
+	}
+	sort.Slice(decks, func(i, j int) bool { return decks[i].Name < decks[j].Name })
+	return decks, nil
+}
+
+// GetDeck implements Repository
+func (r *FileRepository) GetDeck(id string) (*Deck, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read(id)
+}
+
+// SaveDeck implements Repository
+func (r *FileRepository) SaveDeck(deck *Deck) error {
+	if deck.ID == "" {
+		deck.ID = deck.Name
+	}
+	if err := validateDeckID(deck.ID); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var previous []Card
+	stored, err := r.read(deck.ID)
+	switch {
+	case err == nil:
+		previous = stored.Cards
+		deck.Created = stored.Created
+		if deck.Name == "" {
+			deck.Name = stored.Name
+		}
+	case errors.Is(err, ErrDeckNotFound):
+		if deck.Created.IsZero() {
+			deck.Created = now
+		}
+	default:
+		return err
+	}
+	if deck.Name == "" {
+		deck.Name = deck.ID
+	}
+
+	taken, err := r.cardIDs(deck.ID)
+	if err != nil {
+		return err
+	}
+	deck.Cards = stampCards(previous, deck.Cards, taken, now)
+	deck.Updated = now
+	return r.write(deck)
+}
+
+// cardIDs returns the IDs of the cards of all decks but the one with ID
+// except
+func (r *FileRepository) cardIDs(except string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decks directory: %w", err)
+	}
+	ids := make(map[string]bool)
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		if id == except {
+			continue
+		}
+		deck, err := r.read(id)
+		if err != nil {
+			continue
+		}
+		for _, card := range deck.Cards {
+			ids[card.ID] = true
+		}
+	}
+	return ids, nil
+}
+
+// UpdateCard implements Repository
+func (r *FileRepository) UpdateCard(deckID string, card Card) (*Card, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deck, err := r.read(deckID)
+	if err != nil {
+		return nil, err
+	}
+	for i, stored := range deck.Cards {
+		if stored.ID == card.ID {
+			now := time.Now()
+			deck.Cards[i] = updatedCard(stored, card, now)
+			deck.Updated = now
+			if err := r.write(deck); err != nil {
+				return nil, err
+			}
+			return &deck.Cards[i], nil
+		}
+	}
+	return nil, ErrCardNotFound
+}
+
+// FindCard implements Repository
+func (r *FileRepository) FindCard(cardID string) (string, *Card, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read decks directory: %w", err)
+	}
+	for _, file := range files {
+		deck, err := r.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			continue
+		}
+		for i := range deck.Cards {
+			if deck.Cards[i].ID == cardID {
+				return deck.ID, &deck.Cards[i], nil
+			}
+		}
+	}
+	return "", nil, ErrCardNotFound
+}
+
+// SaveReview implements Repository
+func (r *FileRepository) SaveReview(deckID, cardID string, state srs.State) (*Card, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deck, err := r.read(deckID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deck.Cards {
+		if deck.Cards[i].ID == cardID {
+			deck.Cards[i].Review = &state
+			if err := r.write(deck); err != nil {
+				return nil, err
+			}
+			return &deck.Cards[i], nil
+		}
+	}
+	return nil, ErrCardNotFound
+}
+
+// DeleteCard implements Repository
+func (r *FileRepository) DeleteCard(deckID, cardID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deck, err := r.read(deckID)
+	if err != nil {
+		return err
+	}
+	for i, card := range deck.Cards {
+		if card.ID == cardID {
+			deck.Cards = append(deck.Cards[:i], deck.Cards[i+1:]...)
+			deck.Updated = time.Now()
+			return r.write(deck)
+		}
+	}
+	return ErrCardNotFound
+}
+
+// DeleteDeck implements Repository
+func (r *FileRepository) DeleteDeck(id string) error {
+	if err := validateDeckID(id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := os.Remove(r.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrDeckNotFound
+	}
+	return err
+}
+
+// Close implements Repository
+func (r *FileRepository) Close() error {
+	return nil
+}
+
+func (r *FileRepository) path(id string) string {
+	return filepath.Join(r.dir, id+".json")
+}
+
+func (r *FileRepository) read(id string) (*Deck, error) {
+	if err := validateDeckID(id); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(r.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDeckNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deck: %w", err)
+	}
+	defer file.Close()
+
+	var deck Deck
+	if err := json.NewDecoder(file).Decode(&deck); err != nil {
+		return nil, fmt.Errorf("failed to read deck: %w", err)
+	}
+	// Decks written before IDs were file names may name another ID
+	deck.ID = id
+	return &deck, nil
+}
+
+// write replaces a deck file through a temporary file, so readers never see
+// a partly written deck
+func (r *FileRepository) write(deck *Deck) error {
+	tmp, err := os.CreateTemp(r.dir, "."+deck.ID+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create deck file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(deck); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save deck: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save deck: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.path(deck.ID)); err != nil {
+		return fmt.Errorf("failed to save deck: %w", err)
+	}
+	return nil
+}
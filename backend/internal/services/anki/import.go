@@ -0,0 +1,303 @@
+package anki
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/apkg"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+// ErrInvalidPackage is returned for uploads that are not readable Anki
+// packages
+var ErrInvalidPackage = errors.New("invalid Anki package")
+
+// ImportResult summarizes an imported package
+type ImportResult struct {
+	// Decks are the created decks without their cards
+	Decks []Deck `json:"decks"`
+	Notes int    `json:"notes"`
+	Media int    `json:"media"`
+}
+
+// Fields of the exported note types that are not part of a card's text
+var skippedFields = map[string]bool{"Source": true, "Add Reverse": true}
+
+// ImportAPKG creates decks from an .apkg or .colpkg file. Every top-level
+// Anki deck becomes a deck with its subdecks kept as the cards' subdeck,
+// unless deckName is given, which puts all notes into one deck of that name.
+// Media files are stored in the media directory. Cards keep one review
+// state, so the scheduling of a note's reverse and further cloze cards is
+// not imported.
+func (s *Service) ImportAPKG(r io.ReaderAt, size int64, deckName string) (*ImportResult, error) {
+	pkg, err := apkg.Read(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPackage, err)
+	}
+
+	renamed, err := s.saveMedia(pkg.Media)
+	if err != nil {
+		return nil, err
+	}
+
+	decks := packageDecks(pkg, deckName)
+	result := &ImportResult{Decks: []Deck{}, Media: len(pkg.Media)}
+	for _, deck := range decks {
+		for i := range deck.Cards {
+			deck.Cards[i].Question = renameMedia(deck.Cards[i].Question, renamed)
+			deck.Cards[i].Answer = renameMedia(deck.Cards[i].Answer, renamed)
+		}
+		id, err := s.freeDeckID(deck.Name)
+		if err != nil {
+			return nil, err
+		}
+		deck.ID = id
+		if err := s.repo.SaveDeck(deck); err != nil {
+			return nil, fmt.Errorf("failed to save deck %s: %w", deck.Name, err)
+		}
+		result.Notes += len(deck.Cards)
+		result.Decks = append(result.Decks, Deck{
+			ID:        deck.ID,
+			Name:      deck.Name,
+			Created:   deck.Created,
+			Updated:   deck.Updated,
+			CardCount: len(deck.Cards),
+		})
+	}
+	return result, nil
+}
+
+// packageDecks converts the notes of a package into decks in the order their
+// Anki decks are first used
+func packageDecks(pkg *apkg.Package, deckName string) []*Deck {
+	models := make(map[int64]apkg.Model, len(pkg.Models))
+	for _, model := range pkg.Models {
+		models[model.ID] = model
+	}
+	deckNames := make(map[int64]string, len(pkg.Decks))
+	for _, deck := range pkg.Decks {
+		deckNames[deck.ID] = deck.Name
+	}
+
+	var decks []*Deck
+	byName := make(map[string]*Deck)
+	for _, note := range pkg.Notes {
+		model, ok := models[note.ModelID]
+		if !ok {
+			continue
+		}
+
+		path := strings.Split(deckNames[note.DeckID], "::")
+		name, subdeck := path[0], strings.Join(path[1:], "::")
+		if deckName != "" {
+			name, subdeck = deckName, strings.Join(path, "::")
+		}
+		if name == "" {
+			name = "Imported"
+		}
+		deck := byName[name]
+		if deck == nil {
+			deck = &Deck{Name: name}
+			byName[name] = deck
+			decks = append(decks, deck)
+		}
+
+		card := noteCard(note, model)
+		card.Subdeck = subdeck
+		// Notes are read with their cards by ordinal, so this is the
+		// front-to-back or first cloze card
+		if len(note.Cards) > 0 {
+			card.Review = reviewState(note.Cards[0], pkg.Created)
+		}
+		deck.Cards = append(deck.Cards, card)
+	}
+
+	for _, deck := range decks {
+		deck.Created = deck.Cards[0].Created
+		for _, card := range deck.Cards[1:] {
+			if card.Created.Before(deck.Created) {
+				deck.Created = card.Created
+			}
+		}
+	}
+	return decks
+}
+
+// conditionField matches the field a template is conditional on
+var conditionField = regexp.MustCompile(`\{\{#([^}]+)\}\}`)
+
+// noteCard converts a note into a card by its note type. Fields after the
+// first two are added to the answer.
+func noteCard(note apkg.Note, model apkg.Model) Card {
+	field := func(i int) string {
+		if i < len(note.Fields) {
+			return note.Fields[i]
+		}
+		return ""
+	}
+
+	card := Card{
+		Question: field(0),
+		Answer:   field(1),
+		Tags:     note.Tags,
+		// Note IDs are their creation time in milliseconds
+		Created: time.UnixMilli(note.ID),
+	}
+	for i := 2; i < len(note.Fields) && i < len(model.Fields); i++ {
+		if value := strings.TrimSpace(note.Fields[i]); value != "" && !skippedFields[model.Fields[i]] {
+			card.Answer += "<br><br>" + value
+		}
+	}
+
+	switch {
+	case model.Type == apkg.ModelCloze:
+		card.Type = CardTypeCloze
+	case len(model.Templates) >= 2 && conditionField.MatchString(model.Templates[1].QFmt):
+		// The reverse card of "Basic (optional reversed card)" is only
+		// generated when its condition field is filled in
+		card.NoteType = NoteTypeOptionalReversed
+		condition := conditionField.FindStringSubmatch(model.Templates[1].QFmt)[1]
+		for i, name := range model.Fields {
+			if name == condition {
+				card.AddReverse = strings.TrimSpace(field(i)) != ""
+			}
+		}
+	case len(model.Templates) >= 2:
+		card.NoteType = NoteTypeReversed
+	default:
+		card.NoteType = NoteTypeBasic
+	}
+	return card
+}
+
+// reviewState converts the scheduling of an Anki card, nil for new cards
+func reviewState(card apkg.Card, collectionCreated time.Time) *srs.State {
+	state := &srs.State{Reps: card.Reps, Lapses: card.Lapses}
+	switch card.Type {
+	case 1:
+		state.Phase = srs.PhaseLearning
+	case 2:
+		state.Phase = srs.PhaseReview
+	case 3:
+		state.Phase = srs.PhaseRelearning
+	default:
+		return nil
+	}
+
+	// Cards in the learning and preview queues are due at a Unix time,
+	// those in the review and day learning queues on a day. Suspended and
+	// buried cards keep the due of their type, which is only a day for
+	// review cards.
+	var timestamp bool
+	switch card.Queue {
+	case 1, 4:
+		timestamp = true
+	case 2, 3:
+		timestamp = false
+	default:
+		timestamp = card.Type != 2 && card.Due > 1_000_000_000
+	}
+	if timestamp {
+		state.Due = time.Unix(card.Due, 0)
+	} else {
+		state.Due = collectionCreated.AddDate(0, 0, int(card.Due))
+	}
+	if card.Interval > 0 {
+		state.Interval = float64(card.Interval)
+		// Anki's cards table keeps no last review time; the due day minus
+		// the interval is when the card was last answered
+		state.LastReview = state.Due.AddDate(0, 0, -card.Interval)
+	}
+	if card.Factor > 0 {
+		state.Ease = float64(card.Factor) / 1000
+	}
+	return state
+}
+
+// freeDeckID returns an unused deck ID made from a deck name
+func (s *Service) freeDeckID(name string) (string, error) {
+	base := strings.NewReplacer("/", "-", `\`, "-").Replace(name)
+	if base == "." || base == ".." {
+		base = "deck"
+	}
+	id := base
+	for i := 2; ; i++ {
+		_, err := s.repo.GetDeck(id)
+		if errors.Is(err, ErrDeckNotFound) {
+			return id, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		id = fmt.Sprintf("%s (%d)", base, i)
+	}
+}
+
+// saveMedia stores media files in the media directory. Files whose name is
+// taken by a different file are stored under a name with their hash; the
+// returned map holds these new names.
+func (s *Service) saveMedia(media []apkg.Media) (map[string]string, error) {
+	if len(media) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(s.mediaDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	renamed := make(map[string]string)
+	for _, file := range media {
+		name := filepath.Base(file.Name)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			continue
+		}
+
+		path := filepath.Join(s.mediaDir, name)
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read media file %s: %w", name, err)
+		}
+		if err == nil && !bytes.Equal(existing, file.Data) {
+			sum := sha1.Sum(file.Data)
+			ext := filepath.Ext(name)
+			name = strings.TrimSuffix(name, ext) + "_" + hex.EncodeToString(sum[:4]) + ext
+			path = filepath.Join(s.mediaDir, name)
+		}
+		if name != file.Name {
+			renamed[file.Name] = name
+		}
+
+		if err := os.WriteFile(path, file.Data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to save media file %s: %w", name, err)
+		}
+	}
+	return renamed, nil
+}
+
+// mediaReference matches the file names in <img src="..."> and [sound:...]
+var mediaReference = regexp.MustCompile(`(src=["']?)([^"'>\s]+)|\[sound:([^\]]+)\]`)
+
+// renameMedia replaces the references to renamed media files in a field
+func renameMedia(field string, renamed map[string]string) string {
+	if len(renamed) == 0 {
+		return field
+	}
+	return mediaReference.ReplaceAllStringFunc(field, func(ref string) string {
+		match := mediaReference.FindStringSubmatch(ref)
+		if name, ok := renamed[match[3]]; ok && match[3] != "" {
+			return "[sound:" + name + "]"
+		}
+		if name, ok := renamed[match[2]]; ok && match[2] != "" {
+			return match[1] + name
+		}
+		return ref
+	})
+}
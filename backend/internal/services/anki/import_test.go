@@ -0,0 +1,44 @@
+package anki
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/apkg"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+func TestReviewStateDue(t *testing.T) {
+	created := time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC)
+	learningDue := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		card  apkg.Card
+		phase srs.Phase
+		due   time.Time
+	}{
+		{"learning", apkg.Card{Type: 1, Queue: 1, Due: learningDue.Unix()}, srs.PhaseLearning, learningDue},
+		{"day learning", apkg.Card{Type: 1, Queue: 3, Due: 40}, srs.PhaseLearning, created.AddDate(0, 0, 40)},
+		{"review", apkg.Card{Type: 2, Queue: 2, Due: 100, Interval: 10}, srs.PhaseReview, created.AddDate(0, 0, 100)},
+		{"relearning", apkg.Card{Type: 3, Queue: 1, Due: learningDue.Unix()}, srs.PhaseRelearning, learningDue},
+		{"day relearning", apkg.Card{Type: 3, Queue: 3, Due: 60}, srs.PhaseRelearning, created.AddDate(0, 0, 60)},
+		{"preview", apkg.Card{Type: 1, Queue: 4, Due: learningDue.Unix()}, srs.PhaseLearning, learningDue},
+		{"suspended review", apkg.Card{Type: 2, Queue: -1, Due: 100}, srs.PhaseReview, created.AddDate(0, 0, 100)},
+		{"buried learning", apkg.Card{Type: 1, Queue: -2, Due: learningDue.Unix()}, srs.PhaseLearning, learningDue},
+	}
+	for _, test := range tests {
+		state := reviewState(test.card, created)
+		if state == nil {
+			t.Errorf("%s: state is nil", test.name)
+			continue
+		}
+		if state.Phase != test.phase || !state.Due.Equal(test.due) {
+			t.Errorf("%s: phase %v due %v, want %v due %v", test.name, state.Phase, state.Due, test.phase, test.due)
+		}
+	}
+
+	if state := reviewState(apkg.Card{Type: 0, Queue: 0, Due: 5}, created); state != nil {
+		t.Errorf("new card has state %+v", state)
+	}
+}
@@ -0,0 +1,212 @@
+package anki
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+// Errors returned by repositories
+var (
+	ErrDeckNotFound  = errors.New("deck not found")
+	ErrCardNotFound  = errors.New("card not found")
+	ErrInvalidDeckID = errors.New("invalid deck ID")
+)
+
+// Repository stores decks and their cards. Jobs write the decks they
+// generate to it and every endpoint reads from it.
+type Repository interface {
+	// ListDecks returns every deck without its cards, with CardCount set
+	ListDecks() ([]Deck, error)
+	// GetDeck returns a deck with its cards
+	GetDeck(id string) (*Deck, error)
+	// SaveDeck creates a deck or replaces its cards. Cards without an ID
+	// or with one used in another deck get a new one, so card IDs are
+	// unique across decks; the timestamps of cards are kept unless they
+	// changed. An empty name keeps the stored one.
+	SaveDeck(deck *Deck) error
+	// UpdateCard replaces a card of a deck, keeping its creation time and
+	// review state
+	UpdateCard(deckID string, card Card) (*Card, error)
+	// FindCard looks up a card by ID in all decks and returns it with the ID
+	// of its deck
+	FindCard(cardID string) (string, *Card, error)
+	// SaveReview stores the review state of a card. Reviews do not change
+	// the updated time of the card or deck.
+	SaveReview(deckID, cardID string, state srs.State) (*Card, error)
+	DeleteCard(deckID, cardID string) error
+	DeleteDeck(id string) error
+	Close() error
+}
+
+// Repository backends
+const (
+	StoreFiles  = "fs"
+	StoreSQLite = "sqlite"
+)
+
+// OpenRepository opens the repository of the given backend. Files are stored
+// in dir; path overrides the location of the SQLite database.
+func OpenRepository(backend, dir, path string) (Repository, error) {
+	switch backend {
+	case "", StoreFiles:
+		return NewFileRepository(dir)
+	case StoreSQLite:
+		if path == "" {
+			path = filepath.Join(dir, "cards.db")
+		}
+		return NewSQLiteRepository(path)
+	default:
+		return nil, fmt.Errorf("unknown card store %q", backend)
+	}
+}
+
+// validateDeckID rejects IDs that cannot be used as file names
+func validateDeckID(id string) error {
+	if id == "" || id == "." || id == ".." || id != filepath.Base(id) || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidDeckID, id)
+	}
+	return nil
+}
+
+// newCardID returns a random card ID
+func newCardID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// ContentCardID derives a stable card ID from a deck, the card type and its
+// question, ignoring case and whitespace. Generating a card into the same deck
+// again yields the same ID, so the stored card is found. Cards of the same
+// run that share an ID pass the number of earlier ones as n.
+func ContentCardID(deckID string, card Card, n int) string {
+	question := strings.Join(strings.Fields(strings.ToLower(card.Question)), " ")
+	cardType := card.Type
+	if cardType == "" {
+		cardType = CardTypeBasic
+	}
+	values := []string{deckID, cardType, question}
+	if n > 0 {
+		values = append(values, fmt.Sprintf("%d", n))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x1f")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// stampCards prepares the cards of a deck being saved over the previous
+// cards. New cards and cards whose ID is taken by another deck get an ID;
+// cards keep their creation time and review state and are only marked as
+// updated when their content changed. New cards keep the review state they
+// come with, such as imported ones.
+func stampCards(previous, cards []Card, taken map[string]bool, now time.Time) []Card {
+	old := make(map[string]Card, len(previous))
+	for _, card := range previous {
+		old[card.ID] = card
+	}
+
+	seen := make(map[string]bool, len(cards))
+	stamped := make([]Card, len(cards))
+	for i, card := range cards {
+		for card.ID == "" || seen[card.ID] || taken[card.ID] {
+			card.ID = newCardID()
+		}
+		seen[card.ID] = true
+
+		if prev, ok := old[card.ID]; ok {
+			card.Created = prev.Created
+			card.Updated = prev.Updated
+			card.Review = prev.Review
+			if !sameContent(prev, card) {
+				card.Updated = now
+			}
+		} else {
+			if card.Created.IsZero() {
+				card.Created = now
+			}
+			card.Updated = now
+		}
+		stamped[i] = card
+	}
+	return stamped
+}
+
+// updatedCard merges an edited card into the stored one
+func updatedCard(stored, card Card, now time.Time) Card {
+	card.ID = stored.ID
+	card.Created = stored.Created
+	card.Updated = now
+	card.Review = stored.Review
+	if card.Source == nil {
+		card.Source = stored.Source
+	}
+	return card
+}
+
+// sameContent reports whether two cards differ only in their timestamps and
+// review state
+func sameContent(a, b Card) bool {
+	a.Created, a.Updated, a.Review = time.Time{}, time.Time{}, nil
+	b.Created, b.Updated, b.Review = time.Time{}, time.Time{}, nil
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(dataA) == string(dataB)
+}
+
+// MigrateCSV imports the CSV decks in cardsDir that are not in the
+// repository yet. Imported files are renamed to <name>.csv.migrated so they
+// are kept but not imported again.
+func MigrateCSV(repo Repository, cardsDir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(cardsDir, "*.csv"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list CSV decks: %w", err)
+	}
+
+	imported := 0
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".csv")
+		if err := validateDeckID(name); err != nil {
+			log.Printf("Warning: Skipping CSV deck %s: %v", file, err)
+			continue
+		}
+
+		_, err := repo.GetDeck(name)
+		switch {
+		case errors.Is(err, ErrDeckNotFound):
+			deck, err := ReadCSVDeck(file, name)
+			if err != nil {
+				return imported, err
+			}
+			// Row numbers are not stable IDs, the repository assigns new ones
+			for i := range deck.Cards {
+				deck.Cards[i].ID = ""
+			}
+			if err := repo.SaveDeck(deck); err != nil {
+				return imported, fmt.Errorf("failed to import %s: %w", file, err)
+			}
+			imported++
+		case err != nil:
+			return imported, err
+		default:
+			log.Printf("Warning: Deck %s already exists, not importing %s", name, file)
+		}
+
+		if err := os.Rename(file, file+".migrated"); err != nil {
+			return imported, fmt.Errorf("failed to mark %s as migrated: %w", file, err)
+		}
+	}
+	return imported, nil
+}
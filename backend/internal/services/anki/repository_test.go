@@ -0,0 +1,264 @@
+package anki
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+)
+
+// testRepositories runs a test against every repository backend
+func testRepositories(t *testing.T, test func(t *testing.T, repo Repository)) {
+	for _, backend := range []string{StoreFiles, StoreSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			repo, err := OpenRepository(backend, t.TempDir(), "")
+			if err != nil {
+				t.Fatalf("OpenRepository: %v", err)
+			}
+			defer repo.Close()
+			test(t, repo)
+		})
+	}
+}
+
+func TestRepositorySaveAndGet(t *testing.T) {
+	testRepositories(t, func(t *testing.T, repo Repository) {
+		deck := &Deck{Name: "Biology", Cards: []Card{
+			{Question: "What is ATP?", Answer: "Energy currency", Tags: []string{"energy"}},
+			{Question: "{{c1::Mitochondria}} make ATP", Type: CardTypeCloze, Source: &Source{File: "bio.pdf", Pages: "3"}},
+		}}
+		if err := repo.SaveDeck(deck); err != nil {
+			t.Fatalf("SaveDeck: %v", err)
+		}
+		if deck.ID != "Biology" || deck.Created.IsZero() {
+			t.Errorf("saved deck has ID %q and creation time %v", deck.ID, deck.Created)
+		}
+
+		got, err := repo.GetDeck("Biology")
+		if err != nil {
+			t.Fatalf("GetDeck: %v", err)
+		}
+		if got.Name != "Biology" || len(got.Cards) != 2 {
+			t.Fatalf("GetDeck = %+v", got)
+		}
+		for i, card := range got.Cards {
+			if card.ID == "" || card.ID != deck.Cards[i].ID || card.Created.IsZero() || card.Updated.IsZero() {
+				t.Errorf("card %d = %+v, want the ID and timestamps it was saved with", i, card)
+			}
+		}
+		if got.Cards[1].Source == nil || got.Cards[1].Source.Pages != "3" || got.Cards[0].Tags[0] != "energy" {
+			t.Errorf("cards lost their fields: %+v", got.Cards)
+		}
+
+		decks, err := repo.ListDecks()
+		if err != nil {
+			t.Fatalf("ListDecks: %v", err)
+		}
+		if len(decks) != 1 || decks[0].CardCount != 2 || decks[0].Cards != nil {
+			t.Errorf("ListDecks = %+v", decks)
+		}
+
+		if _, err := repo.GetDeck("Chemistry"); !errors.Is(err, ErrDeckNotFound) {
+			t.Errorf("GetDeck of a missing deck = %v", err)
+		}
+		if err := repo.SaveDeck(&Deck{ID: "../escape"}); !errors.Is(err, ErrInvalidDeckID) {
+			t.Errorf("SaveDeck with an invalid ID = %v", err)
+		}
+	})
+}
+
+func TestRepositoryResaveKeepsCards(t *testing.T) {
+	testRepositories(t, func(t *testing.T, repo Repository) {
+		deck := &Deck{Name: "Biology", Cards: []Card{{Question: "Q1", Answer: "A1"}, {Question: "Q2", Answer: "A2"}}}
+		if err := repo.SaveDeck(deck); err != nil {
+			t.Fatal(err)
+		}
+		first := deck.Cards[0]
+		state := srs.State{Phase: srs.PhaseReview, Interval: 3, Reps: 2}
+		if _, err := repo.SaveReview("Biology", first.ID, state); err != nil {
+			t.Fatalf("SaveReview: %v", err)
+		}
+
+		time.Sleep(time.Millisecond)
+		resaved := &Deck{ID: "Biology", Cards: []Card{
+			{ID: first.ID, Question: "Q1", Answer: "A1"},
+			{ID: deck.Cards[1].ID, Question: "Q2", Answer: "A2 edited"},
+		}}
+		if err := repo.SaveDeck(resaved); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetDeck("Biology")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Biology" {
+			t.Errorf("resaving without a name changed it to %q", got.Name)
+		}
+		unchanged, edited := got.Cards[0], got.Cards[1]
+		if unchanged.Review == nil || unchanged.Review.Interval != 3 {
+			t.Errorf("resaved card lost its review state: %+v", unchanged.Review)
+		}
+		if !unchanged.Updated.Equal(first.Updated) || !unchanged.Created.Equal(first.Created) {
+			t.Errorf("unchanged card has new timestamps: %v %v, want %v %v", unchanged.Created, unchanged.Updated, first.Created, first.Updated)
+		}
+		if !edited.Updated.After(deck.Cards[1].Updated) {
+			t.Errorf("edited card was not marked as updated")
+		}
+	})
+}
+
+func TestRepositoryCardIDsAreUnique(t *testing.T) {
+	testRepositories(t, func(t *testing.T, repo Repository) {
+		biology := &Deck{Name: "Biology", Cards: []Card{{ID: "shared", Question: "Q1", Answer: "A1"}}}
+		if err := repo.SaveDeck(biology); err != nil {
+			t.Fatal(err)
+		}
+		chemistry := &Deck{Name: "Chemistry", Cards: []Card{
+			{ID: "shared", Question: "Q2", Answer: "A2"},
+			{ID: "twice", Question: "Q3", Answer: "A3"},
+			{ID: "twice", Question: "Q4", Answer: "A4"},
+		}}
+		if err := repo.SaveDeck(chemistry); err != nil {
+			t.Fatal(err)
+		}
+		ids := map[string]bool{"shared": true}
+		for _, card := range chemistry.Cards {
+			if ids[card.ID] {
+				t.Errorf("card ID %q is used twice", card.ID)
+			}
+			ids[card.ID] = true
+		}
+
+		deckID, card, err := repo.FindCard("shared")
+		if err != nil {
+			t.Fatalf("FindCard: %v", err)
+		}
+		if deckID != "Biology" || card.Question != "Q1" {
+			t.Errorf("FindCard = %s %+v", deckID, card)
+		}
+		deckID, card, err = repo.FindCard(chemistry.Cards[0].ID)
+		if err != nil || deckID != "Chemistry" || card.Question != "Q2" {
+			t.Errorf("FindCard = %s %+v %v", deckID, card, err)
+		}
+		if _, _, err := repo.FindCard("missing"); !errors.Is(err, ErrCardNotFound) {
+			t.Errorf("FindCard of a missing card = %v", err)
+		}
+	})
+}
+
+func TestRepositoryUpdateAndDelete(t *testing.T) {
+	testRepositories(t, func(t *testing.T, repo Repository) {
+		deck := &Deck{Name: "Biology", Cards: []Card{
+			{Question: "Q1", Answer: "A1", Source: &Source{File: "bio.pdf"}},
+			{Question: "Q2", Answer: "A2"},
+		}}
+		if err := repo.SaveDeck(deck); err != nil {
+			t.Fatal(err)
+		}
+		id := deck.Cards[0].ID
+		if _, err := repo.SaveReview("Biology", id, srs.State{Phase: srs.PhaseLearning, Reps: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		updated, err := repo.UpdateCard("Biology", Card{ID: id, Question: "Q1 edited", Answer: "A1"})
+		if err != nil {
+			t.Fatalf("UpdateCard: %v", err)
+		}
+		if updated.Question != "Q1 edited" || updated.Review == nil || updated.Source == nil || !updated.Created.Equal(deck.Cards[0].Created) {
+			t.Errorf("UpdateCard = %+v, want the edit with the stored creation time, review and source", updated)
+		}
+		if _, err := repo.UpdateCard("Biology", Card{ID: "missing"}); !errors.Is(err, ErrCardNotFound) {
+			t.Errorf("UpdateCard of a missing card = %v", err)
+		}
+		if _, err := repo.UpdateCard("Chemistry", Card{ID: id}); !errors.Is(err, ErrDeckNotFound) {
+			t.Errorf("UpdateCard in a missing deck = %v", err)
+		}
+		if _, err := repo.SaveReview("Biology", "missing", srs.State{}); !errors.Is(err, ErrCardNotFound) {
+			t.Errorf("SaveReview of a missing card = %v", err)
+		}
+
+		if err := repo.DeleteCard("Biology", id); err != nil {
+			t.Fatalf("DeleteCard: %v", err)
+		}
+		if err := repo.DeleteCard("Biology", id); !errors.Is(err, ErrCardNotFound) {
+			t.Errorf("deleting a card twice = %v", err)
+		}
+		got, err := repo.GetDeck("Biology")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Cards) != 1 || got.Cards[0].Question != "Q2" {
+			t.Errorf("cards after delete = %+v", got.Cards)
+		}
+
+		if err := repo.DeleteDeck("Biology"); err != nil {
+			t.Fatalf("DeleteDeck: %v", err)
+		}
+		if err := repo.DeleteDeck("Biology"); !errors.Is(err, ErrDeckNotFound) {
+			t.Errorf("deleting a deck twice = %v", err)
+		}
+		if _, _, err := repo.FindCard(got.Cards[0].ID); !errors.Is(err, ErrCardNotFound) {
+			t.Errorf("card of a deleted deck is still found: %v", err)
+		}
+	})
+}
+
+func TestMigrateCSV(t *testing.T) {
+	testRepositories(t, func(t *testing.T, repo Repository) {
+		dir := t.TempDir()
+		files := map[string]string{
+			"Biology.csv":  "Question,Answer,Tags,Source File,Source Pages\nWhat is ATP?,Energy currency,energy cells,bio.pdf,3\n\"Multi\nline\",Answer\n",
+			"Old.csv":      "Question,Answer\nWhat is DNA?,Genetic material\n",
+			"Existing.csv": "Question,Answer\nQ,A\n",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.SaveDeck(&Deck{Name: "Existing", Cards: []Card{{Question: "Kept", Answer: "A"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		imported, err := MigrateCSV(repo, dir)
+		if err != nil {
+			t.Fatalf("MigrateCSV: %v", err)
+		}
+		if imported != 2 {
+			t.Errorf("imported %d decks, want 2", imported)
+		}
+
+		biology, err := repo.GetDeck("Biology")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(biology.Cards) != 2 || biology.Cards[1].Question != "Multi\nline" {
+			t.Fatalf("Biology cards = %+v", biology.Cards)
+		}
+		card := biology.Cards[0]
+		if card.ID == "1" || len(card.Tags) != 2 || card.Source == nil || card.Source.File != "bio.pdf" || card.Source.Pages != "3" {
+			t.Errorf("migrated card = %+v", card)
+		}
+		old, err := repo.GetDeck("Old")
+		if err != nil || len(old.Cards) != 1 || old.Cards[0].Answer != "Genetic material" {
+			t.Errorf("deck with only question and answer columns = %+v, %v", old, err)
+		}
+		existing, err := repo.GetDeck("Existing")
+		if err != nil || len(existing.Cards) != 1 || existing.Cards[0].Question != "Kept" {
+			t.Errorf("existing deck was overwritten: %+v, %v", existing, err)
+		}
+
+		for name := range files {
+			if _, err := os.Stat(filepath.Join(dir, name+".migrated")); err != nil {
+				t.Errorf("%s was not marked as migrated: %v", name, err)
+			}
+		}
+		// A second run finds nothing left to import
+		if imported, err := MigrateCSV(repo, dir); err != nil || imported != 0 {
+			t.Errorf("second MigrateCSV imported %d: %v", imported, err)
+		}
+	})
+}
@@ -0,0 +1,354 @@
+package anki
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS decks (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created INTEGER NOT NULL,
+	updated INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cards (
+	deck_id TEXT NOT NULL REFERENCES decks(id) ON DELETE CASCADE,
+	id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	created INTEGER NOT NULL,
+	updated INTEGER NOT NULL,
+	PRIMARY KEY (deck_id, id)
+);
+CREATE INDEX IF NOT EXISTS cards_by_id ON cards (id);
+`
+
+// SQLiteRepository stores decks in a SQLite database. Cards are kept as JSON
+// next to the columns they are looked up and ordered by.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository opens or creates the database at path
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open card database: %w", err)
+	}
+	// SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create card database schema: %w", err)
+	}
+	return &SQLiteRepository{db: db}, nil
+}
+
+// ListDecks implements Repository
+func (r *SQLiteRepository) ListDecks() ([]Deck, error) {
+	rows, err := r.db.Query(`
+		SELECT d.id, d.name, d.created, d.updated, COUNT(c.id)
+		FROM decks d LEFT JOIN cards c ON c.deck_id = d.id
+		GROUP BY d.id ORDER BY d.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list decks: %w", err)
+	}
+	defer rows.Close()
+
+	decks := []Deck{}
+	for rows.Next() {
+		var deck Deck
+		var created, updated int64
+		if err := rows.Scan(&deck.ID, &deck.Name, &created, &updated, &deck.CardCount); err != nil {
+			return nil, fmt.Errorf("failed to read deck: %w", err)
+		}
+		deck.Created, deck.Updated = time.Unix(0, created), time.Unix(0, updated)
+		decks = append(decks, deck)
+	}
+	return decks, rows.Err()
+}
+
+// GetDeck implements Repository
+func (r *SQLiteRepository) GetDeck(id string) (*Deck, error) {
+	return r.getDeck(r.db, id)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (r *SQLiteRepository) getDeck(q querier, id string) (*Deck, error) {
+	var deck Deck
+	var created, updated int64
+	err := q.QueryRow(`SELECT id, name, created, updated FROM decks WHERE id = ?`, id).
+		Scan(&deck.ID, &deck.Name, &created, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeckNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deck: %w", err)
+	}
+	deck.Created, deck.Updated = time.Unix(0, created), time.Unix(0, updated)
+
+	rows, err := q.Query(`SELECT data, created, updated FROM cards WHERE deck_id = ? ORDER BY position`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		deck.Cards = append(deck.Cards, *card)
+	}
+	return &deck, rows.Err()
+}
+
+func scanCard(row interface{ Scan(...any) error }) (*Card, error) {
+	var data string
+	var created, updated int64
+	if err := row.Scan(&data, &created, &updated); err != nil {
+		return nil, err
+	}
+	var card Card
+	if err := json.Unmarshal([]byte(data), &card); err != nil {
+		return nil, fmt.Errorf("failed to decode card: %w", err)
+	}
+	card.Created, card.Updated = time.Unix(0, created), time.Unix(0, updated)
+	return &card, nil
+}
+
+// SaveDeck implements Repository
+func (r *SQLiteRepository) SaveDeck(deck *Deck) error {
+	if deck.ID == "" {
+		deck.ID = deck.Name
+	}
+	if err := validateDeckID(deck.ID); err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var previous []Card
+	stored, err := r.getDeck(tx, deck.ID)
+	switch {
+	case err == nil:
+		previous = stored.Cards
+		deck.Created = stored.Created
+		if deck.Name == "" {
+			deck.Name = stored.Name
+		}
+	case errors.Is(err, ErrDeckNotFound):
+		if deck.Created.IsZero() {
+			deck.Created = now
+		}
+	default:
+		return err
+	}
+	if deck.Name == "" {
+		deck.Name = deck.ID
+	}
+	taken, err := cardIDs(tx, deck.ID)
+	if err != nil {
+		return err
+	}
+	deck.Cards = stampCards(previous, deck.Cards, taken, now)
+	deck.Updated = now
+
+	if _, err := tx.Exec(`
+		INSERT INTO decks (id, name, created, updated) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, updated = excluded.updated`,
+		deck.ID, deck.Name, deck.Created.UnixNano(), deck.Updated.UnixNano()); err != nil {
+		return fmt.Errorf("failed to save deck: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM cards WHERE deck_id = ?`, deck.ID); err != nil {
+		return fmt.Errorf("failed to replace cards: %w", err)
+	}
+	for i, card := range deck.Cards {
+		if err := insertCard(tx, deck.ID, i, card); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deck: %w", err)
+	}
+	return nil
+}
+
+// cardIDs returns the IDs of the cards of all decks but the one with ID
+// except
+func cardIDs(q querier, except string) (map[string]bool, error) {
+	rows, err := q.Query(`SELECT id FROM cards WHERE deck_id != ?`, except)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read card IDs: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read card IDs: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func insertCard(tx *sql.Tx, deckID string, position int, card Card) error {
+	data, err := json.Marshal(card)
+	if err != nil {
+		return fmt.Errorf("failed to encode card: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO cards (deck_id, id, position, data, created, updated) VALUES (?, ?, ?, ?, ?, ?)`,
+		deckID, card.ID, position, string(data), card.Created.UnixNano(), card.Updated.UnixNano()); err != nil {
+		return fmt.Errorf("failed to save card: %w", err)
+	}
+	return nil
+}
+
+// UpdateCard implements Repository
+func (r *SQLiteRepository) UpdateCard(deckID string, card Card) (*Card, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT data, created, updated FROM cards WHERE deck_id = ? AND id = ?`, deckID, card.ID)
+	stored, err := scanCard(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := r.getDeck(tx, deckID); err != nil {
+			return nil, err
+		}
+		return nil, ErrCardNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read card: %w", err)
+	}
+
+	now := time.Now()
+	updated := updatedCard(*stored, card, now)
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode card: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE cards SET data = ?, updated = ? WHERE deck_id = ? AND id = ?`,
+		string(data), now.UnixNano(), deckID, card.ID); err != nil {
+		return nil, fmt.Errorf("failed to save card: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE decks SET updated = ? WHERE id = ?`, now.UnixNano(), deckID); err != nil {
+		return nil, fmt.Errorf("failed to update deck: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit card: %w", err)
+	}
+	return &updated, nil
+}
+
+// FindCard implements Repository
+func (r *SQLiteRepository) FindCard(cardID string) (string, *Card, error) {
+	var deckID, data string
+	var created, updated int64
+	// SaveDeck keeps IDs unique across decks; databases written before it
+	// did resolve to the first deck
+	err := r.db.QueryRow(`SELECT deck_id, data, created, updated FROM cards WHERE id = ? ORDER BY deck_id LIMIT 1`, cardID).
+		Scan(&deckID, &data, &created, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, ErrCardNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read card: %w", err)
+	}
+	var card Card
+	if err := json.Unmarshal([]byte(data), &card); err != nil {
+		return "", nil, fmt.Errorf("failed to decode card: %w", err)
+	}
+	card.Created, card.Updated = time.Unix(0, created), time.Unix(0, updated)
+	return deckID, &card, nil
+}
+
+// SaveReview implements Repository
+func (r *SQLiteRepository) SaveReview(deckID, cardID string, state srs.State) (*Card, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT data, created, updated FROM cards WHERE deck_id = ? AND id = ?`, deckID, cardID)
+	card, err := scanCard(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCardNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read card: %w", err)
+	}
+
+	card.Review = &state
+	data, err := json.Marshal(card)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode card: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE cards SET data = ? WHERE deck_id = ? AND id = ?`, string(data), deckID, cardID); err != nil {
+		return nil, fmt.Errorf("failed to save review: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit review: %w", err)
+	}
+	return card, nil
+}
+
+// DeleteCard implements Repository
+func (r *SQLiteRepository) DeleteCard(deckID, cardID string) error {
+	result, err := r.db.Exec(`DELETE FROM cards WHERE deck_id = ? AND id = ?`, deckID, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to delete card: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := r.GetDeck(deckID); err != nil {
+			return err
+		}
+		return ErrCardNotFound
+	}
+	_, err = r.db.Exec(`UPDATE decks SET updated = ? WHERE id = ?`, time.Now().UnixNano(), deckID)
+	return err
+}
+
+// DeleteDeck implements Repository
+func (r *SQLiteRepository) DeleteDeck(id string) error {
+	result, err := r.db.Exec(`DELETE FROM decks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete deck: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDeckNotFound
+	}
+	return nil
+}
+
+// Close implements Repository
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,178 @@
+package srs
+
+import (
+	"math"
+	"time"
+)
+
+// FSRS-4.5 default weights, fitted on Anki review logs
+var fsrsDefaultWeights = [17]float64{
+	0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031, 1.6474,
+	0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755,
+}
+
+// Forgetting curve R(t, S) = (1 + factor * t / S) ^ decay, which makes S the
+// interval after which recall drops to 90%
+const (
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0
+)
+
+// FSRS learning steps, the same as SM-2's. Learning cards graduate when
+// answered Good after the Good step, relearning cards after one step.
+const (
+	fsrsAgainStep   = time.Minute
+	fsrsHardStep    = 6 * time.Minute
+	fsrsGoodStep    = 10 * time.Minute
+	fsrsRelearnStep = 10 * time.Minute
+)
+
+// FSRS is the Free Spaced Repetition Scheduler (version 4.5). It models each
+// card's memory by its stability, the interval in days at which recall
+// drops to 90%, and its difficulty from 1 to 10, and schedules reviews at
+// the interval where recall is expected to drop to the requested retention.
+type FSRS struct {
+	Weights [17]float64
+	// RequestRetention is the probability of recall reviews are scheduled at
+	RequestRetention float64
+	// MaximumInterval caps intervals, in days
+	MaximumInterval float64
+}
+
+// NewFSRS creates an FSRS scheduler with the default weights, aiming at 90%
+// retention
+func NewFSRS() *FSRS {
+	return &FSRS{
+		Weights:          fsrsDefaultWeights,
+		RequestRetention: 0.9,
+		MaximumInterval:  36500,
+	}
+}
+
+// Name implements Scheduler
+func (f *FSRS) Name() string {
+	return SchedulerFSRS
+}
+
+// Schedule implements Scheduler
+func (f *FSRS) Schedule(state *State, rating Rating, now time.Time) (State, error) {
+	if err := validRating(rating); err != nil {
+		return State{}, err
+	}
+
+	next := State{Phase: PhaseNew}
+	if state != nil {
+		next = *state
+	}
+	if next.Phase != PhaseNew && next.Stability == 0 {
+		// Cards scheduled by SM-2 start from their interval
+		next.Stability = math.Max(next.Interval, f.Weights[Good-1])
+		next.Difficulty = f.initDifficulty(Good)
+	}
+	elapsed := elapsedDays(next, now)
+	lastStep := next.Due.Sub(next.LastReview)
+	next.Reps++
+	next.LastReview = now
+	next.LastRating = rating
+
+	if next.Phase == PhaseNew {
+		next.Stability = f.Weights[rating-1]
+		next.Difficulty = f.initDifficulty(rating)
+		switch rating {
+		case Again:
+			return learn(next, PhaseLearning, fsrsAgainStep, now), nil
+		case Hard:
+			return learn(next, PhaseLearning, fsrsHardStep, now), nil
+		case Good:
+			return learn(next, PhaseLearning, fsrsGoodStep, now), nil
+		}
+		next.Interval = f.interval(next.Stability)
+		return review(next, now), nil
+	}
+
+	stability, difficulty := next.Stability, next.Difficulty
+	r := f.retrievability(elapsed, stability)
+	next.Stability, next.Difficulty = f.memory(stability, difficulty, r, rating)
+
+	if next.Phase == PhaseReview {
+		if rating == Again {
+			next.Lapses++
+			return learn(next, PhaseRelearning, fsrsRelearnStep, now), nil
+		}
+		// Harder answers never get longer intervals than easier ones
+		hard, _ := f.memory(stability, difficulty, r, Hard)
+		good, _ := f.memory(stability, difficulty, r, Good)
+		hardIvl := math.Min(f.interval(hard), f.interval(good))
+		goodIvl := math.Max(f.interval(good), hardIvl+1)
+		switch rating {
+		case Hard:
+			next.Interval = hardIvl
+		case Good:
+			next.Interval = goodIvl
+		default:
+			next.Interval = math.Max(f.interval(next.Stability), goodIvl+1)
+		}
+		return review(next, now), nil
+	}
+
+	// Learning and relearning cards
+	switch rating {
+	case Again:
+		return learn(next, next.Phase, fsrsAgainStep, now), nil
+	case Hard:
+		return learn(next, next.Phase, fsrsHardStep, now), nil
+	case Good:
+		if next.Phase == PhaseLearning && lastStep < fsrsGoodStep {
+			return learn(next, next.Phase, fsrsGoodStep, now), nil
+		}
+		next.Interval = f.interval(next.Stability)
+	default:
+		good, _ := f.memory(stability, difficulty, r, Good)
+		next.Interval = math.Max(f.interval(next.Stability), f.interval(good)+1)
+	}
+	return review(next, now), nil
+}
+
+// memory returns the stability and difficulty after an answer given at
+// retrievability r
+func (f *FSRS) memory(stability, difficulty, r float64, rating Rating) (float64, float64) {
+	w := f.Weights
+	d := difficulty - w[6]*(float64(rating)-3)
+	// Mean reversion towards the difficulty of a new card answered Good
+	d = clamp(w[7]*f.initDifficulty(Good)+(1-w[7])*d, 1, 10)
+
+	if rating == Again {
+		s := w[11] * math.Pow(difficulty, -w[12]) * (math.Pow(stability+1, w[13]) - 1) * math.Exp(w[14]*(1-r))
+		return math.Min(s, stability), d
+	}
+
+	factor := math.Exp(w[8]) * (11 - difficulty) * math.Pow(stability, -w[9]) * (math.Exp(w[10]*(1-r)) - 1)
+	switch rating {
+	case Hard:
+		factor *= w[15]
+	case Easy:
+		factor *= w[16]
+	}
+	return stability * (factor + 1), d
+}
+
+// initDifficulty is the difficulty of a new card after its first answer
+func (f *FSRS) initDifficulty(rating Rating) float64 {
+	return clamp(f.Weights[4]-(float64(rating)-3)*f.Weights[5], 1, 10)
+}
+
+// retrievability is the probability of recall after elapsed days
+func (f *FSRS) retrievability(elapsed, stability float64) float64 {
+	return math.Pow(1+fsrsFactor*elapsed/stability, fsrsDecay)
+}
+
+// interval returns the days after which recall drops to the requested
+// retention
+func (f *FSRS) interval(stability float64) float64 {
+	ivl := stability / fsrsFactor * (math.Pow(f.RequestRetention, 1/fsrsDecay) - 1)
+	return clamp(math.Round(ivl), 1, f.MaximumInterval)
+}
+
+func clamp(value, low, high float64) float64 {
+	return math.Max(low, math.Min(high, value))
+}
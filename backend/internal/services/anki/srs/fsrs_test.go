@@ -0,0 +1,55 @@
+package srs
+
+import (
+	"testing"
+	"time"
+)
+
+// Expected values follow the FSRS-4.5 formulas with the default weights
+func TestFSRSSchedule(t *testing.T) {
+	day := 24 * time.Hour
+	// Answered Again when new, so the Good step is still ahead
+	learning := &State{Phase: PhaseLearning, LastReview: testNow.Add(-time.Minute), Due: testNow, Reps: 1, Stability: 0.4872, Difficulty: 7.6214}
+	// Answered Good when new, so Good graduates
+	learned := &State{Phase: PhaseLearning, LastReview: testNow.Add(-10 * time.Minute), Due: testNow, Reps: 1, Stability: 3.7145, Difficulty: 5.1618}
+	review := &State{Phase: PhaseReview, LastReview: testNow.Add(-10 * day), Due: testNow, Interval: 10, Reps: 5, Stability: 10, Difficulty: 5}
+	relearning := &State{Phase: PhaseRelearning, LastReview: testNow.Add(-10 * time.Minute), Due: testNow, Interval: 10, Reps: 6, Lapses: 1, Stability: 2, Difficulty: 6}
+
+	runScheduleTests(t, NewFSRS(), []scheduleTest{
+		{name: "new", rating: Again, phase: PhaseLearning, step: time.Minute, stability: 0.4872, difficulty: 7.6214},
+		{name: "new", rating: Hard, phase: PhaseLearning, step: 6 * time.Minute, stability: 1.4003, difficulty: 6.3916},
+		{name: "new", rating: Good, phase: PhaseLearning, step: 10 * time.Minute, stability: 3.7145, difficulty: 5.1618},
+		{name: "new", rating: Easy, phase: PhaseReview, step: 14 * day, interval: 14, stability: 13.8206, difficulty: 3.932},
+
+		{name: "learning", state: learning, rating: Again, phase: PhaseLearning, step: time.Minute, stability: 0.2467, difficulty: 9.2845},
+		{name: "learning", state: learning, rating: Hard, phase: PhaseLearning, step: 6 * time.Minute, stability: 0.4876, difficulty: 8.4148},
+		{name: "learning", state: learning, rating: Good, phase: PhaseLearning, step: 10 * time.Minute, stability: 0.4888, difficulty: 7.5452},
+		{name: "learning", state: learning, rating: Easy, phase: PhaseReview, step: 2 * day, interval: 2, stability: 0.4919, difficulty: 6.6755},
+
+		{name: "learned", state: learned, rating: Again, phase: PhaseLearning, step: time.Minute, stability: 1.2108, difficulty: 6.9012},
+		{name: "learned", state: learned, rating: Hard, phase: PhaseLearning, step: 6 * time.Minute, stability: 3.7194, difficulty: 6.0315},
+		{name: "learned", state: learned, rating: Good, phase: PhaseReview, step: 4 * day, interval: 4, stability: 3.7361, difficulty: 5.1618},
+		{name: "learned", state: learned, rating: Easy, phase: PhaseReview, step: 5 * day, interval: 5, stability: 3.7766, difficulty: 4.2921},
+
+		{name: "review", state: review, rating: Again, phase: PhaseRelearning, step: 10 * time.Minute, interval: 10, lapses: 1, stability: 2.5604, difficulty: 6.7444},
+		{name: "review", state: review, rating: Hard, phase: PhaseReview, step: 16 * day, interval: 16, stability: 15.6991, difficulty: 5.8747},
+		{name: "review", state: review, rating: Good, phase: PhaseReview, step: 35 * day, interval: 35, stability: 35.0839, difficulty: 5.005},
+		{name: "review", state: review, rating: Easy, phase: PhaseReview, step: 82 * day, interval: 82, stability: 82.1287, difficulty: 4.1353},
+
+		{name: "relearning", state: relearning, rating: Again, phase: PhaseRelearning, step: time.Minute, interval: 10, lapses: 1, stability: 0.7838, difficulty: 7.7134},
+		{name: "relearning", state: relearning, rating: Hard, phase: PhaseRelearning, step: 6 * time.Minute, interval: 10, lapses: 1, stability: 2.0046, difficulty: 6.8437},
+		{name: "relearning", state: relearning, rating: Good, phase: PhaseReview, step: 2 * day, interval: 2, lapses: 1, stability: 2.0201, difficulty: 5.974},
+		{name: "relearning", state: relearning, rating: Easy, phase: PhaseReview, step: 3 * day, interval: 3, lapses: 1, stability: 2.0578, difficulty: 5.1043},
+	})
+}
+
+func TestFSRSStartsFromSM2State(t *testing.T) {
+	state := &State{Phase: PhaseReview, LastReview: testNow.Add(-20 * 24 * time.Hour), Due: testNow, Interval: 20, Reps: 4, Ease: 2.5}
+	next, err := NewFSRS().Schedule(state, Good, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Stability <= 20 || next.Difficulty == 0 || next.Interval <= 20 {
+		t.Errorf("state after SM-2 = %+v, want stability and interval above 20 days", next)
+	}
+}
@@ -0,0 +1,138 @@
+package srs
+
+import (
+	"math"
+	"time"
+)
+
+// SM-2 settings. The learning steps follow Anki's defaults.
+const (
+	sm2InitialEase   = 2.5
+	sm2MinEase       = 1.3
+	sm2AgainStep     = time.Minute
+	sm2HardStep      = 6 * time.Minute
+	sm2GoodStep      = 10 * time.Minute
+	sm2RelearnStep   = 10 * time.Minute
+	sm2GraduatingIvl = 1
+	sm2EasyIvl       = 4
+	sm2SecondIvl     = 6
+	sm2HardFactor    = 1.2
+	sm2EasyBonus     = 1.3
+	sm2LapseFactor   = 0.5
+)
+
+// sm2Quality maps the buttons to SM-2's answer quality from 0 to 5
+var sm2Quality = map[Rating]float64{Again: 1, Hard: 3, Good: 4, Easy: 5}
+
+// SM2 is the SuperMemo 2 algorithm with Anki's learning steps: new and
+// forgotten cards are shown again after minutes until they are answered with
+// Good, reviews multiply the interval by the card's ease factor.
+type SM2 struct{}
+
+// NewSM2 creates an SM-2 scheduler
+func NewSM2() *SM2 {
+	return &SM2{}
+}
+
+// Name implements Scheduler
+func (s *SM2) Name() string {
+	return SchedulerSM2
+}
+
+// Schedule implements Scheduler
+func (s *SM2) Schedule(state *State, rating Rating, now time.Time) (State, error) {
+	if err := validRating(rating); err != nil {
+		return State{}, err
+	}
+
+	next := State{Phase: PhaseNew}
+	if state != nil {
+		next = *state
+	}
+	if next.Ease == 0 {
+		next.Ease = sm2InitialEase
+	}
+	next.Reps++
+	next.LastReview = now
+	next.LastRating = rating
+
+	switch next.Phase {
+	case PhaseReview:
+		if rating == Again {
+			next.Lapses++
+			next.Ease = sm2Ease(next.Ease, rating)
+			next.Interval = math.Max(sm2GraduatingIvl, math.Round(next.Interval*sm2LapseFactor))
+			return learn(next, PhaseRelearning, sm2RelearnStep, now), nil
+		}
+		next.Ease = sm2Ease(next.Ease, rating)
+		next.Interval = sm2Interval(next.Interval, next.Ease, rating)
+		return review(next, now), nil
+
+	case PhaseRelearning:
+		// Interval holds the interval the card returns to
+		if rating == Again || rating == Hard {
+			return learn(next, PhaseRelearning, sm2RelearnStep, now), nil
+		}
+		if rating == Easy {
+			next.Interval++
+		}
+		return review(next, now), nil
+
+	default:
+		switch rating {
+		case Again:
+			return learn(next, PhaseLearning, sm2AgainStep, now), nil
+		case Hard:
+			return learn(next, PhaseLearning, sm2HardStep, now), nil
+		case Good:
+			if next.Phase == PhaseNew {
+				return learn(next, PhaseLearning, sm2GoodStep, now), nil
+			}
+			next.Interval = sm2GraduatingIvl
+		default:
+			next.Interval = sm2EasyIvl
+		}
+		return review(next, now), nil
+	}
+}
+
+// sm2Ease applies SM-2's ease factor update for an answer
+func sm2Ease(ease float64, rating Rating) float64 {
+	q := 5 - sm2Quality[rating]
+	return math.Max(sm2MinEase, ease+0.1-q*(0.08+q*0.02))
+}
+
+// sm2Interval returns the next review interval in whole days, at least a day
+// longer than the current one
+func sm2Interval(interval, ease float64, rating Rating) float64 {
+	var next float64
+	switch {
+	case rating == Hard:
+		next = interval * sm2HardFactor
+	case interval <= sm2GraduatingIvl:
+		next = sm2SecondIvl
+	default:
+		next = interval * ease
+	}
+	if rating == Easy {
+		next *= sm2EasyBonus
+	}
+	return math.Max(interval+1, math.Round(next))
+}
+
+// learn keeps a card in a learning phase for step
+func learn(state State, phase Phase, step time.Duration, now time.Time) State {
+	state.Phase = phase
+	if phase == PhaseLearning {
+		state.Interval = 0
+	}
+	state.Due = now.Add(step)
+	return state
+}
+
+// review moves a card to the review phase, due after its interval
+func review(state State, now time.Time) State {
+	state.Phase = PhaseReview
+	state.Due = now.Add(days(state.Interval))
+	return state
+}
@@ -0,0 +1,96 @@
+package srs
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// scheduleTest is the state after answering a card with rating. step is
+// the time until the card is due again.
+type scheduleTest struct {
+	name       string
+	state      *State
+	rating     Rating
+	phase      Phase
+	step       time.Duration
+	interval   float64
+	lapses     int
+	ease       float64
+	stability  float64
+	difficulty float64
+}
+
+func runScheduleTests(t *testing.T, scheduler Scheduler, tests []scheduleTest) {
+	t.Helper()
+	for _, test := range tests {
+		var before State
+		if test.state != nil {
+			before = *test.state
+		}
+		got, err := scheduler.Schedule(test.state, test.rating, testNow)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.name, test.rating, err)
+			continue
+		}
+		if test.state != nil && *test.state != before {
+			t.Errorf("%s %s: Schedule modified its input", test.name, test.rating)
+		}
+		if got.Phase != test.phase || got.Due.Sub(testNow) != test.step || got.Interval != test.interval || got.Lapses != test.lapses {
+			t.Errorf("%s %s: phase %s due in %v interval %v lapses %d, want %s, %v, %v, %d",
+				test.name, test.rating, got.Phase, got.Due.Sub(testNow), got.Interval, got.Lapses,
+				test.phase, test.step, test.interval, test.lapses)
+		}
+		if test.ease != 0 && math.Abs(got.Ease-test.ease) > 1e-9 {
+			t.Errorf("%s %s: ease %v, want %v", test.name, test.rating, got.Ease, test.ease)
+		}
+		if test.stability != 0 && math.Abs(got.Stability-test.stability) > 1e-3 {
+			t.Errorf("%s %s: stability %.4f, want %.4f", test.name, test.rating, got.Stability, test.stability)
+		}
+		if test.difficulty != 0 && math.Abs(got.Difficulty-test.difficulty) > 1e-3 {
+			t.Errorf("%s %s: difficulty %.4f, want %.4f", test.name, test.rating, got.Difficulty, test.difficulty)
+		}
+		if got.LastReview != testNow || got.LastRating != test.rating || got.Reps != before.Reps+1 {
+			t.Errorf("%s %s: last review %v rating %s reps %d", test.name, test.rating, got.LastReview, got.LastRating, got.Reps)
+		}
+	}
+}
+
+func TestSM2Schedule(t *testing.T) {
+	day := 24 * time.Hour
+	learning := &State{Phase: PhaseLearning, LastReview: testNow.Add(-time.Minute), Due: testNow, Reps: 1, Ease: 2.5}
+	review := &State{Phase: PhaseReview, LastReview: testNow.Add(-10 * day), Due: testNow, Interval: 10, Reps: 5, Ease: 2.5}
+	relearning := &State{Phase: PhaseRelearning, LastReview: testNow.Add(-10 * time.Minute), Due: testNow, Interval: 5, Reps: 6, Lapses: 1, Ease: 1.96}
+
+	runScheduleTests(t, NewSM2(), []scheduleTest{
+		{name: "new", rating: Again, phase: PhaseLearning, step: time.Minute, ease: 2.5},
+		{name: "new", rating: Hard, phase: PhaseLearning, step: 6 * time.Minute, ease: 2.5},
+		{name: "new", rating: Good, phase: PhaseLearning, step: 10 * time.Minute, ease: 2.5},
+		{name: "new", rating: Easy, phase: PhaseReview, step: 4 * day, interval: 4, ease: 2.5},
+
+		{name: "learning", state: learning, rating: Again, phase: PhaseLearning, step: time.Minute, ease: 2.5},
+		{name: "learning", state: learning, rating: Hard, phase: PhaseLearning, step: 6 * time.Minute, ease: 2.5},
+		{name: "learning", state: learning, rating: Good, phase: PhaseReview, step: day, interval: 1, ease: 2.5},
+		{name: "learning", state: learning, rating: Easy, phase: PhaseReview, step: 4 * day, interval: 4, ease: 2.5},
+
+		{name: "review", state: review, rating: Again, phase: PhaseRelearning, step: 10 * time.Minute, interval: 5, lapses: 1, ease: 1.96},
+		{name: "review", state: review, rating: Hard, phase: PhaseReview, step: 12 * day, interval: 12, ease: 2.36},
+		{name: "review", state: review, rating: Good, phase: PhaseReview, step: 25 * day, interval: 25, ease: 2.5},
+		{name: "review", state: review, rating: Easy, phase: PhaseReview, step: 34 * day, interval: 34, ease: 2.6},
+
+		{name: "relearning", state: relearning, rating: Again, phase: PhaseRelearning, step: 10 * time.Minute, interval: 5, lapses: 1, ease: 1.96},
+		{name: "relearning", state: relearning, rating: Hard, phase: PhaseRelearning, step: 10 * time.Minute, interval: 5, lapses: 1, ease: 1.96},
+		{name: "relearning", state: relearning, rating: Good, phase: PhaseReview, step: 5 * day, interval: 5, lapses: 1, ease: 1.96},
+		{name: "relearning", state: relearning, rating: Easy, phase: PhaseReview, step: 6 * day, interval: 6, lapses: 1, ease: 1.96},
+	})
+}
+
+func TestScheduleRejectsInvalidRating(t *testing.T) {
+	for _, scheduler := range []Scheduler{NewSM2(), NewFSRS()} {
+		if _, err := scheduler.Schedule(nil, 0, testNow); err == nil {
+			t.Errorf("%s accepted rating 0", scheduler.Name())
+		}
+	}
+}
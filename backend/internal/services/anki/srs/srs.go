@@ -0,0 +1,159 @@
+// Package srs schedules card reviews with spaced repetition. The SM-2 and
+// FSRS schedulers share one review state per card, so a collection can
+// switch between them.
+package srs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRating is returned for answers other than Again, Hard, Good and
+// Easy
+var ErrInvalidRating = errors.New("invalid rating")
+
+// Rating is the answer given to a card
+type Rating int
+
+const (
+	Again Rating = 1
+	Hard  Rating = 2
+	Good  Rating = 3
+	Easy  Rating = 4
+)
+
+var ratingNames = map[Rating]string{Again: "again", Hard: "hard", Good: "good", Easy: "easy"}
+
+func (r Rating) String() string {
+	if name, ok := ratingNames[r]; ok {
+		return name
+	}
+	return strconv.Itoa(int(r))
+}
+
+// ParseRating reads a rating given by name ("again", "hard", "good",
+// "easy") or number (1-4)
+func ParseRating(value string) (Rating, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for rating, name := range ratingNames {
+		if value == name || value == strconv.Itoa(int(rating)) {
+			return rating, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q, expected again, hard, good or easy", ErrInvalidRating, value)
+}
+
+// UnmarshalJSON accepts a rating as a name or a number
+func (r *Rating) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRating, data)
+		}
+		value = strconv.Itoa(n)
+	}
+	rating, err := ParseRating(value)
+	if err != nil {
+		return err
+	}
+	*r = rating
+	return nil
+}
+
+// MarshalJSON writes a rating by name
+func (r Rating) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// Phase is the stage of learning a card is in
+type Phase string
+
+const (
+	// PhaseNew cards have never been reviewed
+	PhaseNew Phase = "new"
+	// PhaseLearning cards are shown again within the same session until
+	// they are remembered
+	PhaseLearning Phase = "learning"
+	// PhaseReview cards are shown again after days
+	PhaseReview Phase = "review"
+	// PhaseRelearning cards were forgotten in review
+	PhaseRelearning Phase = "relearning"
+)
+
+// State is the review state of a card
+type State struct {
+	Phase      Phase     `json:"phase"`
+	Due        time.Time `json:"due"`
+	LastReview time.Time `json:"lastReview"`
+	// Interval is the review interval in days, 0 until a card first reaches
+	// review. Relearning cards keep their last interval.
+	Interval float64 `json:"interval"`
+	Reps     int     `json:"reps"`
+	Lapses   int     `json:"lapses"`
+	// Ease is the SM-2 ease factor
+	Ease float64 `json:"ease,omitempty"`
+	// Stability and Difficulty are the FSRS memory state
+	Stability  float64 `json:"stability,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"`
+	// LastRating is the answer of the last review
+	LastRating Rating `json:"lastRating,omitempty"`
+}
+
+// IsDue reports whether a card with the state is due at now. Cards without
+// a state are new and always due.
+func IsDue(state *State, now time.Time) bool {
+	return state == nil || !state.Due.After(now)
+}
+
+// Scheduler computes the state of a card after it was answered
+type Scheduler interface {
+	// Name is the name the scheduler is configured by
+	Name() string
+	// Schedule returns the state of a card with the given state, nil for
+	// a new card, after it was answered with rating at now
+	Schedule(state *State, rating Rating, now time.Time) (State, error)
+}
+
+// Scheduler names
+const (
+	SchedulerSM2  = "sm2"
+	SchedulerFSRS = "fsrs"
+)
+
+// New returns the scheduler with the given name, FSRS by default
+func New(name string) (Scheduler, error) {
+	switch strings.ToLower(name) {
+	case "", SchedulerFSRS:
+		return NewFSRS(), nil
+	case SchedulerSM2:
+		return NewSM2(), nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler %q, expected %s or %s", name, SchedulerSM2, SchedulerFSRS)
+	}
+}
+
+// validRating checks a rating before it is scheduled
+func validRating(rating Rating) error {
+	if _, ok := ratingNames[rating]; !ok {
+		return fmt.Errorf("%w: %d", ErrInvalidRating, int(rating))
+	}
+	return nil
+}
+
+// days converts an interval in days to a duration
+func days(interval float64) time.Duration {
+	return time.Duration(interval * float64(24*time.Hour))
+}
+
+// elapsedDays returns the days between the last review of a state and now
+func elapsedDays(state State, now time.Time) float64 {
+	if state.LastReview.IsZero() || now.Before(state.LastReview) {
+		return 0
+	}
+	return now.Sub(state.LastReview).Hours() / 24
+}
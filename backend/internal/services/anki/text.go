@@ -0,0 +1,121 @@
+package anki
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Anki's built-in note types, which every collection has, so imported notes
+// need no field mapping
+var builtinNoteTypes = map[string]struct {
+	name   string
+	fields int
+}{
+	NoteTypeBasic:            {"Basic", 2},
+	NoteTypeReversed:         {"Basic (and reversed card)", 2},
+	NoteTypeOptionalReversed: {"Basic (optional reversed card)", 3},
+	CardTypeCloze:            {"Cloze", 2},
+}
+
+// builtinNoteType returns the key of the built-in note type a card is
+// imported as
+func builtinNoteType(card Card) string {
+	if card.Type == CardTypeCloze {
+		return CardTypeCloze
+	}
+	if _, ok := builtinNoteTypes[card.NoteType]; ok {
+		return card.NoteType
+	}
+	return NoteTypeBasic
+}
+
+// WriteNotesText writes a deck in Anki's "Notes in Plain Text" format:
+// tab-separated rows of HTML fields after header lines that tell Anki's
+// importer the separator, the note type and the GUID, deck and tags
+// columns. Notes keep their card ID as GUID, so importing the file again
+// updates them; cards without an ID get the GUID of the package export.
+func WriteNotesText(w io.Writer, deck *Deck) error {
+	noteType := ""
+	for i, card := range deck.Cards {
+		if i > 0 && builtinNoteType(card) != noteType {
+			noteType = ""
+			break
+		}
+		noteType = builtinNoteType(card)
+	}
+
+	// Meta columns come first, the remaining columns are the fields
+	headers := []string{"separator:tab", "html:true", "guid column:1"}
+	column := 2
+	if noteType != "" {
+		headers = append(headers, "notetype:"+builtinNoteTypes[noteType].name)
+	} else {
+		headers = append(headers, "notetype column:"+strconv.Itoa(column))
+		column++
+	}
+	headers = append(headers,
+		"deck column:"+strconv.Itoa(column),
+		"tags column:"+strconv.Itoa(column+1))
+	for _, header := range headers {
+		if _, err := fmt.Fprintf(w, "#%s\n", header); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = '\t'
+	guids := noteGUIDs(deck)
+	for i, card := range deck.Cards {
+		cardType := builtinNoteType(card)
+		record := []string{guids[i]}
+		if noteType == "" {
+			record = append(record, builtinNoteTypes[cardType].name)
+		}
+		record = append(record, cardDeckName(deck.Name, card.Subdeck), strings.Join(noteTags(card.Tags), " "))
+
+		fields := []string{htmlField(card.Question), htmlField(card.Answer)}
+		if cardType == NoteTypeOptionalReversed {
+			addReverse := ""
+			if card.AddReverse {
+				addReverse = "y"
+			}
+			fields = append(fields, addReverse)
+		}
+		if err := writer.Write(append(record, fields...)); err != nil {
+			return fmt.Errorf("failed to write note: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// cardDeckName returns the full Anki name of a card's deck
+func cardDeckName(deckName, subdeck string) string {
+	name := deckName
+	for _, title := range strings.Split(subdeck, "::") {
+		if title = strings.TrimSpace(title); title != "" {
+			name += "::" + title
+		}
+	}
+	return name
+}
+
+// htmlMarkup matches the tags and entities of fields that already are HTML.
+// Only common tags count, so text like "<DNA>" is still escaped.
+var htmlMarkup = regexp.MustCompile(`(?i)</?(?:a|b|br|div|em|font|h[1-6]|hr|i|img|li|ol|p|pre|span|strong|sub|sup|table|td|th|tr|u|ul)(?:\s[^<>]*)?/?>|&(?:[a-zA-Z]+|#[0-9]+|#x[0-9a-fA-F]+);`)
+
+// htmlField returns a field as HTML. Fields with markup, such as imported
+// ones, are kept; plain text is escaped and its line breaks become <br>.
+func htmlField(field string) string {
+	if htmlMarkup.MatchString(field) {
+		return field
+	}
+	field = html.EscapeString(field)
+	field = strings.ReplaceAll(field, "\r\n", "\n")
+	return strings.ReplaceAll(field, "\n", "<br>")
+}
@@ -1,6 +1,7 @@
 package ocr
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -20,13 +21,14 @@ func NewService(tesseractPath string) *Service {
 	return &Service{tesseractPath: tesseractPath}
 }
 
-// ExtractText performs OCR on an image file
-func (s *Service) ExtractText(imagePath string) (string, error) {
+// ExtractText performs OCR on an image file. Cancelling ctx kills the
+// tesseract process.
+func (s *Service) ExtractText(ctx context.Context, imagePath string) (string, error) {
 	// Create a temporary output file
 	outputBase := strings.TrimSuffix(imagePath, ".pdf") + "_ocr"
 
 	// Run tesseract with detailed error output
-	cmd := exec.Command(s.tesseractPath, imagePath, outputBase)
+	cmd := exec.CommandContext(ctx, s.tesseractPath, imagePath, outputBase)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("failed to run tesseract: %w\nOutput: %s", err, string(output))
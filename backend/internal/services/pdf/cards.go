@@ -0,0 +1,292 @@
+package pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// Card types the generator may produce
+const (
+	CardTypeBasic = anki.CardTypeBasic
+	// CardTypeCloze cards hold a statement with {{c1::...}} deletions as
+	// their question and optional extra information as their answer
+	CardTypeCloze = anki.CardTypeCloze
+)
+
+// CardTypeMixed lets the model choose the type of each card
+const CardTypeMixed = "mixed"
+
+// cardModeTypes lists the card types accepted in LLM responses for each
+// card type a job may ask for
+var cardModeTypes = map[string][]string{
+	CardTypeBasic: {CardTypeBasic},
+	CardTypeCloze: {CardTypeCloze},
+	CardTypeMixed: {CardTypeBasic, CardTypeCloze},
+}
+
+// cardSchemas is the JSON schema requested from providers that support
+// structured output, for each card type a job may ask for
+var cardSchemas = map[string]*ResponseSchema{
+	CardTypeBasic: newCardSchema(cardModeTypes[CardTypeBasic]),
+	CardTypeCloze: newCardSchema(cardModeTypes[CardTypeCloze]),
+	CardTypeMixed: newCardSchema(cardModeTypes[CardTypeMixed]),
+}
+
+// newCardSchema builds the schema of a response with cards of the given
+// types. Strict mode requires every property to be listed as required, so
+// the optional source page is nullable instead. Basic cards also state
+// whether they can be asked the other way round.
+func newCardSchema(types []string) *ResponseSchema {
+	properties := map[string]any{
+		"question":    map[string]any{"type": "string"},
+		"answer":      map[string]any{"type": "string"},
+		"tags":        map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"type":        map[string]any{"type": "string", "enum": types},
+		"source_page": map[string]any{"type": []string{"integer", "null"}},
+	}
+	required := []string{"question", "answer", "tags", "type", "source_page"}
+	if containsString(types, CardTypeBasic) {
+		properties["reverse"] = map[string]any{"type": "boolean"}
+		required = append(required, "reverse")
+	}
+
+	return &ResponseSchema{
+		Name: "flashcards",
+		Schema: mustJSON(map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"cards": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type":                 "object",
+						"properties":           properties,
+						"required":             required,
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"cards"},
+			"additionalProperties": false,
+		}),
+	}
+}
+
+// cardFormats tells the model how to format its answer, for each card type
+// a job may ask for. It is sent even when the provider enforces the schema,
+// as smaller local models follow the prompt more reliably than the response
+// format.
+var cardFormats = map[string]string{
+	CardTypeBasic: `Respond with a JSON object of this form and nothing else:
+{"cards": [{"question": "...", "answer": "...", "tags": ["..."], "type": "basic", "source_page": null, "reverse": false}]}
+
+Answers may span several lines and contain lists or code blocks. ` + reverseFormatNotes + ` ` + cardFormatNotes,
+	CardTypeCloze: `Create cloze deletion cards. Respond with a JSON object of this form and nothing else:
+{"cards": [{"question": "The {{c1::mitochondrion}} produces most of the cell's {{c2::ATP}}.", "answer": "", "tags": ["..."], "type": "cloze", "source_page": null}]}
+
+` + clozeFormatNotes + ` ` + cardFormatNotes,
+	CardTypeMixed: `Create question and answer cards as well as cloze deletion cards, whichever suits each fact better. Respond with a JSON object of this form and nothing else:
+{"cards": [{"question": "...", "answer": "...", "tags": ["..."], "type": "basic", "source_page": null, "reverse": false}, {"question": "The {{c1::mitochondrion}} produces most of the cell's ATP.", "answer": "", "tags": ["..."], "type": "cloze", "source_page": null, "reverse": false}]}
+
+Answers of "basic" cards may span several lines and contain lists or code blocks. ` + reverseFormatNotes + ` For "cloze" cards: ` + clozeFormatNotes + ` ` + cardFormatNotes,
+}
+
+const (
+	clozeFormatNotes   = `"question" is a complete statement in which the parts to recall are marked as {{c1::...}}, {{c2::...}} and so on; parts marked with the same number are hidden together, and a hint may follow the hidden text as {{c1::text::hint}}. "answer" holds optional extra information shown on the back, or an empty string.`
+	reverseFormatNotes = `Set "reverse" to true when the answer is short enough to be asked for its question as well, as with a term and its definition or a word and its translation.`
+	cardFormatNotes    = `Tags are short topic keywords without spaces. Set "source_page" to the page number a card is based on when the text marks pages, otherwise null.`
+)
+
+func mustJSON(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// jsonCard is a card as returned by the model
+type jsonCard struct {
+	Question   string   `json:"question"`
+	Answer     string   `json:"answer"`
+	Tags       []string `json:"tags"`
+	Type       string   `json:"type"`
+	SourcePage *int     `json:"source_page"`
+	Reverse    bool     `json:"reverse"`
+}
+
+// parseCards reads the cards of the given types from an LLM response. JSON
+// output is preferred; responses that are not JSON fall back to the Q:/A:
+// text format.
+func parseCards(response string, types []string) ([]Card, error) {
+	raw, ok := decodeJSONCards(response)
+	if !ok {
+		var err error
+		if raw, err = parseCardsFromResponse(response); err != nil {
+			return nil, err
+		}
+	}
+	return validateCards(raw, types)
+}
+
+// decodeJSONCards decodes a {"cards": [...]} object or a bare array of
+// cards, ignoring Markdown code fences and text around the JSON
+func decodeJSONCards(response string) ([]jsonCard, bool) {
+	start := strings.IndexAny(response, "{[")
+	if start < 0 {
+		return nil, false
+	}
+	body := response[start:]
+
+	if body[0] == '[' {
+		var cards []jsonCard
+		if err := json.NewDecoder(strings.NewReader(body)).Decode(&cards); err != nil {
+			return nil, false
+		}
+		return cards, true
+	}
+
+	var wrapper struct {
+		Cards *[]jsonCard `json:"cards"`
+	}
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&wrapper); err != nil || wrapper.Cards == nil {
+		return nil, false
+	}
+	return *wrapper.Cards, true
+}
+
+// validateCards checks the cards a model returned and normalizes them.
+// Invalid cards and cards of other types are dropped; a response without any
+// valid card is an error.
+func validateCards(raw []jsonCard, types []string) ([]Card, error) {
+	var cards []Card
+	for i, rc := range raw {
+		card := Card{
+			Question: strings.TrimSpace(rc.Question),
+			Answer:   strings.TrimSpace(rc.Answer),
+			Tags:     normalizeTags(rc.Tags),
+			Type:     strings.ToLower(strings.TrimSpace(rc.Type)),
+		}
+		if card.Type == "" {
+			card.Type = types[0]
+		}
+		card.Reverse = rc.Reverse && card.Type == CardTypeBasic
+		if rc.SourcePage != nil && *rc.SourcePage > 0 {
+			card.SourcePage = *rc.SourcePage
+		}
+
+		if err := validateCard(card, types); err != nil {
+			log.Printf("Warning: Dropping card %d from LLM response: %v", i+1, err)
+			continue
+		}
+		cards = append(cards, card)
+	}
+
+	if len(cards) == 0 {
+		return nil, fmt.Errorf("no valid cards found in response")
+	}
+	return cards, nil
+}
+
+func validateCard(card Card, types []string) error {
+	if !containsString(types, card.Type) {
+		return fmt.Errorf("unexpected card type %q", card.Type)
+	}
+	if card.Question == "" {
+		return fmt.Errorf("empty question")
+	}
+	if card.Type == CardTypeCloze {
+		return anki.ValidateCloze(card.Question)
+	}
+	if card.Answer == "" {
+		return fmt.Errorf("empty answer")
+	}
+	return nil
+}
+
+// normalizeTags trims tags, replaces spaces (which separate tags in Anki)
+// and removes duplicates
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), "_")
+		if tag != "" && !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// parseCardsFromResponse parses the legacy text format:
+//
+//	Q: question
+//	A: answer
+//
+// Questions and answers continue until the next marker, so multi-line
+// answers, lists and code blocks are kept. Markers inside code fences are
+// treated as text. Questions with {{c1::...}} deletions are cloze cards.
+func parseCardsFromResponse(response string) ([]jsonCard, error) {
+	var cards []jsonCard
+	var question, answer []string
+	var field *[]string
+	inFence := false
+
+	flush := func() {
+		q := strings.TrimSpace(strings.Join(question, "\n"))
+		a := strings.TrimSpace(strings.Join(answer, "\n"))
+		cardType := CardTypeBasic
+		if strings.Contains(q, "{{c") && anki.ValidateCloze(q) == nil {
+			cardType = CardTypeCloze
+		}
+		if q != "" && (a != "" || cardType == CardTypeCloze) {
+			cards = append(cards, jsonCard{Question: q, Answer: a, Type: cardType})
+		}
+		question, answer, field = nil, nil, nil
+	}
+
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+
+		if !inFence {
+			if rest, ok := cutMarker(trimmed, "Q:", "Question:"); ok {
+				flush()
+				question = []string{rest}
+				field = &question
+				continue
+			}
+			if rest, ok := cutMarker(trimmed, "A:", "Answer:"); ok && field == &question {
+				answer = []string{rest}
+				field = &answer
+				continue
+			}
+		}
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		if field != nil {
+			*field = append(*field, line)
+		}
+	}
+	flush()
+
+	if len(cards) == 0 {
+		return nil, fmt.Errorf("no valid cards found in response")
+	}
+
+	return cards, nil
+}
+
+// cutMarker strips a leading marker such as "Q:" or "**Q:**" from line
+func cutMarker(line string, markers ...string) (string, bool) {
+	plain := strings.TrimLeft(line, "*")
+	for _, marker := range markers {
+		if rest, ok := strings.CutPrefix(plain, marker); ok {
+			return strings.TrimSpace(strings.TrimLeft(rest, "*")), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package pdf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCards(t *testing.T) {
+	basic := []string{CardTypeBasic}
+	mixed := cardModeTypes[CardTypeMixed]
+	tests := []struct {
+		name     string
+		response string
+		types    []string
+		want     []Card
+	}{
+		{
+			name:     "structured output",
+			response: `{"cards": [{"question": "What is ATP?", "answer": "The energy currency of the cell", "tags": ["energy", "cell biology", "energy"], "type": "basic", "source_page": 3, "reverse": false}]}`,
+			types:    basic,
+			want:     []Card{{Question: "What is ATP?", Answer: "The energy currency of the cell", Tags: []string{"energy", "cell_biology"}, Type: CardTypeBasic, SourcePage: 3}},
+		},
+		{
+			name:     "JSON in a code fence with prose around it",
+			response: "Sure! Here are the flashcards:\n\n```json\n{\n  \"cards\": [\n    {\"question\": \"What is osmosis?\", \"answer\": \"Diffusion of water\\nacross a membrane\", \"tags\": [], \"type\": \"BASIC\", \"source_page\": null, \"reverse\": true}\n  ]\n}\n```\n\nLet me know if you need more.",
+			types:    basic,
+			want:     []Card{{Question: "What is osmosis?", Answer: "Diffusion of water\nacross a membrane", Type: CardTypeBasic, Reverse: true}},
+		},
+		{
+			name:     "bare array without types",
+			response: `[{"question": " Term for cell eating? ", "answer": " Phagocytosis ", "source_page": 0}]`,
+			types:    basic,
+			want:     []Card{{Question: "Term for cell eating?", Answer: "Phagocytosis"}},
+		},
+		{
+			name:     "invalid cards are dropped",
+			response: `{"cards": [{"question": "", "answer": "No question"}, {"question": "No answer", "answer": " "}, {"question": "{{c1::ATP}} stores energy", "answer": "", "type": "cloze"}, {"question": "Kept?", "answer": "Yes"}]}`,
+			types:    basic,
+			want:     []Card{{Question: "Kept?", Answer: "Yes", Type: CardTypeBasic}},
+		},
+		{
+			name:     "mixed cards",
+			response: `{"cards": [{"question": "{{c1::Mitochondria}} produce {{c2::ATP}}", "answer": "", "type": "cloze", "reverse": true}, {"question": "{{c1 broken", "answer": "", "type": "cloze"}, {"question": "Q", "answer": "A", "type": "basic", "reverse": true}]}`,
+			types:    mixed,
+			want: []Card{
+				{Question: "{{c1::Mitochondria}} produce {{c2::ATP}}", Type: CardTypeCloze},
+				{Question: "Q", Answer: "A", Type: CardTypeBasic, Reverse: true},
+			},
+		},
+		{
+			name:     "Markdown markers",
+			response: "Here are the cards:\n\n**Q:** What is a ribosome?\n**A:** The site of protein synthesis.\n\n**Question:** What do lysosomes contain?\n**Answer:** Digestive enzymes.",
+			types:    basic,
+			want: []Card{
+				{Question: "What is a ribosome?", Answer: "The site of protein synthesis.", Type: CardTypeBasic},
+				{Question: "What do lysosomes contain?", Answer: "Digestive enzymes.", Type: CardTypeBasic},
+			},
+		},
+		{
+			name:     "multi-line answers",
+			response: "Q: Name the phases of interphase.\nA: Interphase has three phases:\n- G1\n- S\n- G2\n\nQ: What follows interphase?\nA: Mitosis",
+			types:    basic,
+			want: []Card{
+				{Question: "Name the phases of interphase.", Answer: "Interphase has three phases:\n- G1\n- S\n- G2", Type: CardTypeBasic},
+				{Question: "What follows interphase?", Answer: "Mitosis", Type: CardTypeBasic},
+			},
+		},
+		{
+			name:     "markers inside code fences",
+			response: "Q: How do you print in Python?\nA: Call print:\n```python\n# Q: this is a comment\nprint(\"A: hi\")\n```\nQ: Which keyword defines a function?\nA: def",
+			types:    basic,
+			want: []Card{
+				{Question: "How do you print in Python?", Answer: "Call print:\n```python\n# Q: this is a comment\nprint(\"A: hi\")\n```", Type: CardTypeBasic},
+				{Question: "Which keyword defines a function?", Answer: "def", Type: CardTypeBasic},
+			},
+		},
+		{
+			name:     "text format cloze cards",
+			response: "Q: {{c1::Chlorophyll}} absorbs light\nA:\nQ: What is {{c1 not a deletion?\nA: Text",
+			types:    mixed,
+			want: []Card{
+				{Question: "{{c1::Chlorophyll}} absorbs light", Type: CardTypeCloze},
+				{Question: "What is {{c1 not a deletion?", Answer: "Text", Type: CardTypeBasic},
+			},
+		},
+		{
+			name:     "question without answer",
+			response: "Q: What is DNA?\nQ: What is RNA?\nA: A copy of DNA",
+			types:    basic,
+			want:     []Card{{Question: "What is RNA?", Answer: "A copy of DNA", Type: CardTypeBasic}},
+		},
+		{name: "refusal", response: "I'm sorry, I can't create flashcards from this text.", types: basic},
+		{name: "truncated JSON", response: `{"cards": [{"question": "What is ATP?", "answer": "The energy`, types: basic},
+		{name: "only invalid cards", response: `{"cards": [{"question": "What is ATP?", "answer": ""}]}`, types: basic},
+		{name: "empty card list", response: `{"cards": []}`, types: basic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCards(tt.response, tt.types)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parseCards returned %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCards: %v", err)
+			}
+			for i := range tt.want {
+				if tt.want[i].Type == "" {
+					tt.want[i].Type = tt.types[0]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCards =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package pdf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk is a piece of a document sent to the LLM in one request
+type Chunk struct {
+	// Text is the chunk's content with "[Page N]" markers where pages start
+	Text      string
+	FirstPage int
+	LastPage  int
+	// Section is the heading the chunk starts under, if one was detected
+	Section string
+	// Headings lists the headings within the chunk
+	Headings []string
+	Tokens   int
+	Words    int
+}
+
+// PageRange formats the chunk's pages as "3" or "3-5"
+func (c Chunk) PageRange() string {
+	if c.FirstPage == 0 {
+		return ""
+	}
+	if c.FirstPage == c.LastPage {
+		return fmt.Sprintf("%d", c.FirstPage)
+	}
+	return fmt.Sprintf("%d-%d", c.FirstPage, c.LastPage)
+}
+
+// Hash identifies the chunk's text, so the journaled cards of a chunk are
+// only reused when a resumed job splits the file the same way
+func (c Chunk) Hash() string {
+	sum := sha256.Sum256([]byte(c.Text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// unit is the smallest piece of text the chunker moves around: a heading or
+// a sentence
+type unit struct {
+	text    string
+	page    int
+	tokens  int
+	heading bool
+	// paragraph marks the first sentence of a paragraph
+	paragraph bool
+	section   string
+}
+
+// chunker splits documents into chunks of about maxTokens tokens. It
+// prefers to end chunks before headings, then at paragraph ends, and never
+// cuts inside a sentence unless a single sentence exceeds the budget.
+type chunker struct {
+	maxTokens     int
+	overlapTokens int
+	tokens        approxTokenCounter
+}
+
+// A chunk ends before a heading once it is at least this full
+const headingBreakFill = 0.4
+
+// Longest heading that is not marked up as one, in words and runes
+const (
+	maxHeadingWords  = 10
+	maxHeadingLength = 80
+)
+
+// When a chunk overflows, it is cut at the last paragraph boundary if that
+// keeps at least this share of the budget in the chunk
+const paragraphBreakFill = 0.5
+
+var (
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+\S`)
+	numberedHeading = regexp.MustCompile(`^(?:(?:Chapter|Section|Part|Appendix|Kapitel|Abschnitt|Teil)\s+[0-9IVXLC]+[.:]?|[0-9]+(?:\.[0-9]+){0,4}\.?|[IVXLC]+\.|[A-Z]\.)\s+\p{Lu}`)
+	sentenceEnd     = regexp.MustCompile(`[.!?]["'”’)\]]*\s+`)
+	tokenPattern    = regexp.MustCompile(`\p{L}+|\p{N}+|[^\s\p{L}\p{N}]`)
+)
+
+// abbreviations that end with a period without ending the sentence
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "etc.": true, "vs.": true, "cf.": true, "al.": true,
+	"fig.": true, "figs.": true, "eq.": true, "eqs.": true, "no.": true, "vol.": true,
+	"ch.": true, "sec.": true, "p.": true, "pp.": true, "dr.": true, "mr.": true,
+	"mrs.": true, "ms.": true, "prof.": true, "approx.": true, "z.b.": true, "bzw.": true,
+	"ca.": true, "vgl.": true, "art.": true, "abs.": true, "nr.": true,
+}
+
+// split turns the pages of a document into chunks
+func (c *chunker) split(pages []Page) []Chunk {
+	units := c.units(pages)
+
+	var chunks []Chunk
+	var current []unit
+	tokens := 0
+	// carried is the number of units at the start of current repeated from
+	// the previous chunk
+	carried := 0
+
+	reset := func() {
+		current, tokens, carried = nil, 0, 0
+	}
+	flush := func(n int) {
+		chunks = append(chunks, c.build(current[:n]))
+
+		overlap := c.overlap(current[:n])
+		current = append(overlap, current[n:]...)
+		carried = len(overlap)
+		tokens = 0
+		for _, u := range current {
+			tokens += u.tokens
+		}
+	}
+
+	for _, u := range units {
+		if u.heading && float64(tokens) >= headingBreakFill*float64(c.maxTokens) {
+			flush(len(current))
+			// Overlap from the previous section does not belong under a new heading
+			reset()
+		}
+
+		if tokens+u.tokens > c.maxTokens && len(current) > carried {
+			flush(c.breakPoint(current, carried))
+		}
+		if tokens+u.tokens > c.maxTokens && len(current) > 0 {
+			// The carried over text and the new unit do not fit together
+			if len(current) > carried {
+				flush(len(current))
+			}
+			reset()
+		}
+
+		current = append(current, u)
+		tokens += u.tokens
+	}
+	if len(current) > carried {
+		chunks = append(chunks, c.build(current))
+	}
+
+	return chunks
+}
+
+// breakPoint picks how many units of an overflowing chunk to keep. The chunk
+// is cut before its last heading, else at its last page break, else at its
+// last paragraph end, as long as it stays reasonably full; otherwise all
+// units are kept.
+func (c *chunker) breakPoint(current []unit, carried int) int {
+	var heading, page, paragraph int
+	tokens := 0
+	for i, u := range current {
+		if i > carried && float64(tokens) >= paragraphBreakFill*float64(c.maxTokens) {
+			switch {
+			case u.heading:
+				heading = i
+			case u.page != current[i-1].page:
+				page = i
+			case u.paragraph:
+				paragraph = i
+			}
+		}
+		tokens += u.tokens
+	}
+
+	for _, i := range []int{heading, page, paragraph} {
+		if i > 0 {
+			return i
+		}
+	}
+	return len(current)
+}
+
+// overlap returns the trailing sentences of a finished chunk that are
+// repeated at the start of the next one
+func (c *chunker) overlap(done []unit) []unit {
+	if c.overlapTokens <= 0 {
+		return nil
+	}
+	tokens := 0
+	start := len(done)
+	for start > 0 {
+		u := done[start-1]
+		if u.heading || tokens+u.tokens > c.overlapTokens {
+			break
+		}
+		tokens += u.tokens
+		start--
+	}
+	carried := append([]unit(nil), done[start:]...)
+	if len(carried) > 0 {
+		carried[0].paragraph = true
+	}
+	return carried
+}
+
+// build joins units into a chunk, marking where pages start
+func (c *chunker) build(units []unit) Chunk {
+	chunk := Chunk{FirstPage: units[0].page, LastPage: units[0].page, Section: units[0].section}
+	if units[0].heading {
+		chunk.Section = units[0].text
+	}
+
+	var sb strings.Builder
+	page := 0
+	for i, u := range units {
+		if u.page != page {
+			if sb.Len() > 0 {
+				sb.WriteString("\n\n")
+			}
+			fmt.Fprintf(&sb, "[Page %d]\n", u.page)
+			page = u.page
+		} else if i > 0 {
+			if u.heading || u.paragraph || units[i-1].heading {
+				sb.WriteString("\n\n")
+			} else {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString(u.text)
+
+		if u.heading {
+			chunk.Headings = append(chunk.Headings, u.text)
+		}
+		chunk.FirstPage = min(chunk.FirstPage, u.page)
+		chunk.LastPage = max(chunk.LastPage, u.page)
+		chunk.Tokens += u.tokens
+		chunk.Words += len(strings.Fields(u.text))
+	}
+	chunk.Text = sb.String()
+	return chunk
+}
+
+// units breaks pages into headings and sentences
+func (c *chunker) units(pages []Page) []unit {
+	var units []unit
+	section := ""
+	for _, page := range pages {
+		for _, block := range pageBlocks(page.Text) {
+			if block.heading {
+				section = strings.TrimSpace(strings.TrimLeft(block.text, "#"))
+				units = append(units, unit{
+					text:    section,
+					page:    page.Number,
+					tokens:  c.tokens.count(section),
+					heading: true,
+					section: section,
+				})
+				continue
+			}
+
+			for i, sentence := range splitSentences(block.text) {
+				for j, piece := range c.splitLong(sentence) {
+					units = append(units, unit{
+						text:      piece,
+						page:      page.Number,
+						tokens:    c.tokens.count(piece),
+						paragraph: i == 0 && j == 0,
+						section:   section,
+					})
+				}
+			}
+		}
+	}
+	return units
+}
+
+// splitLong cuts a sentence that does not fit in a chunk on its own into
+// pieces of whole words
+func (c *chunker) splitLong(sentence string) []string {
+	if c.tokens.count(sentence) <= c.maxTokens {
+		return []string{sentence}
+	}
+
+	var pieces []string
+	var current []string
+	tokens := 0
+	for _, word := range strings.Fields(sentence) {
+		wordTokens := c.tokens.count(word)
+		if tokens+wordTokens > c.maxTokens && len(current) > 0 {
+			pieces = append(pieces, strings.Join(current, " "))
+			current, tokens = nil, 0
+		}
+		current = append(current, word)
+		tokens += wordTokens
+	}
+	if len(current) > 0 {
+		pieces = append(pieces, strings.Join(current, " "))
+	}
+	return pieces
+}
+
+// block is a heading or a paragraph of a page
+type block struct {
+	text    string
+	heading bool
+}
+
+// pageBlocks cleans a page's text and groups its lines into headings and
+// paragraphs. PDF text is hard-wrapped, so a paragraph is taken to end at an
+// empty line or at a line that ends a sentence and is noticeably shorter
+// than the page's typical line.
+func pageBlocks(text string) []block {
+	lines := strings.Split(preprocessText(text), "\n")
+	typical := typicalLineLength(lines)
+	if typical == 0 {
+		return nil
+	}
+
+	var blocks []block
+	var paragraph strings.Builder
+	endParagraph := func() {
+		if paragraph.Len() > 0 {
+			blocks = append(blocks, block{text: paragraph.String()})
+			paragraph.Reset()
+		}
+	}
+
+	for i, line := range lines {
+		if line == "" {
+			endParagraph()
+			continue
+		}
+
+		// Apart from Markdown headings, a heading has to start a block: it
+		// follows an empty line, another heading, the end of a sentence or
+		// the top of the page, and the next line does not continue it. A
+		// line after a colon is taken to start a list.
+		next := ""
+		if i+1 < len(lines) {
+			next = lines[i+1]
+		}
+		startsBlock := paragraph.Len() == 0 || endsSentence(paragraph.String()) && !strings.HasSuffix(paragraph.String(), ":")
+		if markdownHeading.MatchString(line) || startsBlock && !startsLower(next) && isHeading(line) {
+			endParagraph()
+			blocks = append(blocks, block{text: line, heading: true})
+			continue
+		}
+
+		switch {
+		case paragraph.Len() == 0:
+		case strings.HasSuffix(paragraph.String(), "-") && startsLower(line):
+			// Rejoin a word hyphenated across lines
+			trimmed := strings.TrimSuffix(paragraph.String(), "-")
+			paragraph.Reset()
+			paragraph.WriteString(trimmed)
+		default:
+			paragraph.WriteString(" ")
+		}
+		paragraph.WriteString(line)
+
+		if endsSentence(line) && utf8.RuneCountInString(line) < typical*3/4 {
+			endParagraph()
+		}
+	}
+	endParagraph()
+
+	return blocks
+}
+
+// typicalLineLength is the median length in runes of the non-empty lines
+func typicalLineLength(lines []string) int {
+	var lengths []int
+	for _, line := range lines {
+		if line != "" {
+			lengths = append(lengths, utf8.RuneCountInString(line))
+		}
+	}
+	if len(lengths) == 0 {
+		return 0
+	}
+	sort.Ints(lengths)
+	return lengths[len(lengths)/2]
+}
+
+// isHeading recognizes title-like lines: Markdown headings, and short lines
+// without closing punctuation that are numbered, such as "2.1 Convex Sets",
+// or all caps. Whether the line stands on its own is up to the caller.
+func isHeading(line string) bool {
+	if markdownHeading.MatchString(line) {
+		return true
+	}
+
+	words := strings.Fields(line)
+	if len(words) == 0 || len(words) > maxHeadingWords || utf8.RuneCountInString(line) > maxHeadingLength {
+		return false
+	}
+	if last, _ := utf8.DecodeLastRuneInString(line); strings.ContainsRune(".,;:!?", last) {
+		return false
+	}
+
+	if numberedHeading.MatchString(line) {
+		return true
+	}
+
+	letters, upper := 0, 0
+	for _, r := range line {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 4 && upper == letters && len(words) <= 8
+}
+
+func endsSentence(line string) bool {
+	line = strings.TrimRight(line, `"'”’)]`)
+	last, _ := utf8.DecodeLastRuneInString(line)
+	return strings.ContainsRune(".!?:", last)
+}
+
+func startsLower(line string) bool {
+	first, _ := utf8.DecodeRuneInString(line)
+	return unicode.IsLower(first)
+}
+
+// splitSentences splits a paragraph after sentence-ending punctuation,
+// skipping common abbreviations and initials
+func splitSentences(paragraph string) []string {
+	var sentences []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(paragraph, -1) {
+		end := loc[1]
+		candidate := strings.TrimSpace(paragraph[start:end])
+
+		fields := strings.Fields(candidate)
+		lastWord := strings.ToLower(fields[len(fields)-1])
+		if abbreviations[lastWord] || isInitial(lastWord) {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(paragraph[end:]); unicode.IsLower(next) {
+			continue
+		}
+
+		sentences = append(sentences, candidate)
+		start = end
+	}
+	if rest := strings.TrimSpace(paragraph[start:]); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
+
+// isInitial matches single-letter abbreviations such as "J." in "J. Smith"
+func isInitial(word string) bool {
+	return utf8.RuneCountInString(word) == 2 && strings.HasSuffix(word, ".")
+}
+
+// approxTokenCounter estimates a model's token counts without shipping its
+// tokenizer's vocabulary. Like BPE tokenizers it counts punctuation
+// separately, short words as one token and longer words as one token per few
+// characters; scripts without spaces such as Chinese take about one token per
+// rune. It is a heuristic, not the model's tokenizer: allow for counts that
+// are off by about 20% on prose and by more on code, formulas and tables, by
+// keeping chunk budgets well below the model's context window.
+type approxTokenCounter struct {
+	charsPerToken float64
+}
+
+// newApproxTokenCounter picks the characters per token of the model's
+// tokenizer family
+func newApproxTokenCounter(model string) approxTokenCounter {
+	model = strings.ToLower(model)
+	switch {
+	case strings.Contains(model, "gpt-4o"), strings.Contains(model, "gpt-4.1"), strings.Contains(model, "gpt-5"),
+		strings.HasPrefix(model, "o1"), strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		// o200k_base has a larger vocabulary and merges longer words
+		return approxTokenCounter{charsPerToken: 4.4}
+	case strings.Contains(model, "gpt"):
+		// cl100k_base
+		return approxTokenCounter{charsPerToken: 4}
+	default:
+		// SentencePiece vocabularies of Llama, Mistral and similar local
+		// models split words more finely
+		return approxTokenCounter{charsPerToken: 3.5}
+	}
+}
+
+// count estimates the number of tokens of text
+func (e approxTokenCounter) count(text string) int {
+	tokens := 0.0
+	for _, token := range tokenPattern.FindAllString(text, -1) {
+		first, _ := utf8.DecodeRuneInString(token)
+		switch {
+		case unicode.Is(unicode.Han, first) || unicode.Is(unicode.Hiragana, first) ||
+			unicode.Is(unicode.Katakana, first) || unicode.Is(unicode.Hangul, first):
+			tokens += float64(utf8.RuneCountInString(token))
+		case unicode.IsLetter(first):
+			tokens += math.Max(1, math.Round(float64(utf8.RuneCountInString(token))/e.charsPerToken))
+		case unicode.IsDigit(first):
+			// Numbers are split into groups of up to three digits
+			tokens += math.Ceil(float64(len(token)) / 3)
+		default:
+			tokens++
+		}
+	}
+	return int(math.Ceil(tokens))
+}
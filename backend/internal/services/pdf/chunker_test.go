@@ -0,0 +1,269 @@
+package pdf
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPageBlocksKeepsText(t *testing.T) {
+	lines := []string{
+		"# Methods",
+		"The treatment of patients was randomized.",
+		"Pages are encoded as HTML and UTF-8.",
+		"Department of Atmospheric Science",
+		"- pH",
+		"Tj",
+	}
+	blocks := pageBlocks(strings.Join(lines, "\n"))
+
+	var text []string
+	for _, block := range blocks {
+		text = append(text, block.text)
+	}
+	joined := strings.Join(text, "\n")
+	for _, line := range lines {
+		if !strings.Contains(joined, line) {
+			t.Errorf("line %q was dropped, got:\n%s", line, joined)
+		}
+	}
+	if !blocks[0].heading {
+		t.Errorf("first block is not a heading: %+v", blocks[0])
+	}
+}
+
+func TestPreprocessTextDropsArtifacts(t *testing.T) {
+	text := "\nIntro text\n  12  \n/F1 11.5 Tf\n1 0 0 1 72.0 700.5 Tm\n[(Hel) -20 (lo)] TJ\n0.5 0 0 0.5 10 -20 cm\nThe  end\tof it\n\n \n\nNext\n\n"
+	got := preprocessText(text)
+	want := "Intro text\nThe end of it\n\nNext"
+	if got != want {
+		t.Errorf("preprocessText = %q, want %q", got, want)
+	}
+}
+
+func TestChunkerSplitsAtHeadings(t *testing.T) {
+	c := &chunker{maxTokens: 60, tokens: newApproxTokenCounter("")}
+	var page strings.Builder
+	for _, section := range []string{"# Cells", "# Tissues"} {
+		page.WriteString(section + "\n")
+		for i := 0; i < 6; i++ {
+			page.WriteString("This sentence describes the topic of the section in some detail.\n")
+		}
+	}
+	chunks := c.split([]Page{{Number: 1, Text: page.String()}, {Number: 2, Text: "A closing sentence on the second page."}})
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want at least 2", len(chunks))
+	}
+	if chunks[0].Section != "Cells" {
+		t.Errorf("first chunk section = %q", chunks[0].Section)
+	}
+	found := false
+	for _, chunk := range chunks {
+		if len(chunk.Headings) > 0 && chunk.Headings[0] == "Tissues" && strings.HasPrefix(chunk.Text, "[Page 1]\nTissues") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no chunk starts at the second heading")
+	}
+	last := chunks[len(chunks)-1]
+	if last.LastPage != 2 {
+		t.Errorf("last chunk ends on page %d, want 2", last.LastPage)
+	}
+}
+
+func TestIsHeading(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"# Methods", true},
+		{"### Results and discussion.", true},
+		{"2.1 Convex Sets", true},
+		{"2 Mitochondria", true},
+		{"Chapter 3 Cell Division", true},
+		{"IV. The Krebs Cycle", true},
+		{"B. Appendix Tables", true},
+		{"CELL BIOLOGY", true},
+		{"DNA", false},
+		{"#hashtag", false},
+		{"2 Mitochondria produce most of the ATP.", false},
+		{"3 Units of work are counted per day and per person in this table", false},
+		{"2.1 convex sets", false},
+		{"1990 was a good year", false},
+		{"Department of Atmospheric Science", false},
+		{"THE CELL MEMBRANE: STRUCTURE AND FUNCTION", true},
+		{"SEE THE TABLE OF VALUES FOR ALL OF THE CELLS", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isHeading(tt.line); got != tt.want {
+			t.Errorf("isHeading(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestPageBlocksHeadings(t *testing.T) {
+	long := "Cells need energy for almost everything they do, and most of it"
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "numbered line inside a paragraph",
+			text: long + "\n2 Mitochondria produce most of the ATP that a cell uses\nduring respiration in the matrix.",
+			want: nil,
+		},
+		{
+			name: "numbered line continued by the next line",
+			text: "2 Mitochondria Produce\nmost of the ATP that a cell uses.",
+			want: nil,
+		},
+		{
+			name: "heading after an empty line",
+			text: long + "\ncomes from respiration\n\n2 Mitochondria\n" + long + ".",
+			want: []string{"2 Mitochondria"},
+		},
+		{
+			name: "heading after the end of a sentence",
+			text: long + "\ncomes from respiration in the matrix of the mitochondria.\n2.1 The Krebs Cycle\n" + long + ".",
+			want: []string{"2.1 The Krebs Cycle"},
+		},
+		{
+			name: "list after a colon",
+			text: "The organelles of a cell include the following parts:\n1 Nucleus\n2 Ribosomes",
+			want: nil,
+		},
+		{
+			name: "headings at the top of the page and after a heading",
+			text: "CHAPTER TWO\n2 Cell Energy\n" + long + ".",
+			want: []string{"CHAPTER TWO", "2 Cell Energy"},
+		},
+		{
+			name: "Markdown headings anywhere",
+			text: long + "\n## Respiration\n" + long + ".",
+			want: []string{"## Respiration"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headings []string
+			for _, block := range pageBlocks(tt.text) {
+				if block.heading {
+					headings = append(headings, block.text)
+				}
+			}
+			if !slices.Equal(headings, tt.want) {
+				t.Errorf("headings = %q, want %q", headings, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageBlocksParagraphs(t *testing.T) {
+	text := strings.Join([]string{
+		"Osmosis is the diffusion of water across a selectively perme-",
+		"able membrane from a dilute to a concentrated solution. It needs",
+		"no energy.",
+		"Active transport moves molecules against their gradient and uses",
+		"energy from ATP",
+		"",
+		"Endocytosis brings large particles into the cell.",
+	}, "\n")
+	var got []string
+	for _, block := range pageBlocks(text) {
+		got = append(got, block.text)
+	}
+	want := []string{
+		"Osmosis is the diffusion of water across a selectively permeable membrane from a dilute to a concentrated solution. It needs no energy.",
+		"Active transport moves molecules against their gradient and uses energy from ATP",
+		"Endocytosis brings large particles into the cell.",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("paragraphs =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		paragraph string
+		want      []string
+	}{
+		{"ATP stores energy. Cells use it!", []string{"ATP stores energy.", "Cells use it!"}},
+		{"Some organelles, e.g. mitochondria, have DNA. See Fig. 3 for details.", []string{"Some organelles, e.g. mitochondria, have DNA.", "See Fig. 3 for details."}},
+		{"J. Watson and F. Crick described DNA. It is a helix.", []string{"J. Watson and F. Crick described DNA.", "It is a helix."}},
+		{"The value is approx. three. ok. Next one.", []string{"The value is approx. three. ok.", "Next one."}},
+		{"He said \"stop.\" Then he left", []string{"He said \"stop.\"", "Then he left"}},
+	}
+	for _, tt := range tests {
+		if got := splitSentences(tt.paragraph); !slices.Equal(got, tt.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.paragraph, got, tt.want)
+		}
+	}
+}
+
+func TestApproxTokenCounter(t *testing.T) {
+	tests := []struct {
+		model string
+		text  string
+		want  int
+	}{
+		{"gpt-4", "Hello, world!", 4},
+		{"gpt-4", "", 0},
+		{"gpt-4", "1234567", 3},
+		{"gpt-4", "细胞膜", 3},
+		{"gpt-4", "photosynthesis", 4},
+		{"gpt-4o-mini", "photosynthesis", 3},
+		{"llama3", "photosynthesis", 4},
+		{"gpt-4", "ATP (adenosine triphosphate)", 8},
+	}
+	for _, tt := range tests {
+		if got := newApproxTokenCounter(tt.model).count(tt.text); got != tt.want {
+			t.Errorf("count(%q) with %s = %d, want %d", tt.text, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestChunkerOverlapAndLongSentences(t *testing.T) {
+	c := &chunker{maxTokens: 40, overlapTokens: 15, tokens: newApproxTokenCounter("")}
+	sentences := []string{
+		"Cells divide by mitosis.",
+		"Mitosis has four phases.",
+		"Prophase condenses the chromosomes.",
+		"Metaphase aligns them at the equator.",
+		"Anaphase pulls the sister chromatids apart.",
+		"Telophase forms two new nuclei.",
+	}
+	chunks := c.split([]Page{{Number: 1, Text: strings.Join(sentences, " ")}})
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want at least 2", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if chunk.Tokens > c.maxTokens {
+			t.Errorf("chunk %d has %d tokens, more than %d", i, chunk.Tokens, c.maxTokens)
+		}
+		if i == 0 {
+			continue
+		}
+		// The next chunk repeats the last sentence of the previous one
+		previous := chunks[i-1].Text
+		last := previous[strings.LastIndex(previous, ". ")+2:]
+		if !strings.Contains(chunk.Text, last) {
+			t.Errorf("chunk %d does not repeat %q:\n%s", i, last, chunk.Text)
+		}
+	}
+
+	long := strings.Repeat("word ", 100)
+	chunks = c.split([]Page{{Number: 1, Text: long}})
+	words := 0
+	for _, chunk := range chunks {
+		if chunk.Tokens > c.maxTokens {
+			t.Errorf("chunk of a long sentence has %d tokens", chunk.Tokens)
+		}
+		words += chunk.Words
+	}
+	if words < 100 {
+		t.Errorf("chunks of a long sentence have %d words, want all 100", words)
+	}
+}
@@ -0,0 +1,623 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// Dedup modes decide what happens to a card that duplicates another one
+const (
+	// DedupFlag keeps the card and marks it with the duplicate it matches
+	DedupFlag = "flag"
+	// DedupDrop removes the card
+	DedupDrop = "drop"
+	// DedupMerge folds the card's tags and pages into the card it
+	// duplicates. Duplicates of cards that are already saved are dropped.
+	DedupMerge = "merge"
+	// DedupOff disables duplicate detection
+	DedupOff = "off"
+)
+
+// Dedup scopes decide which saved cards generated cards are compared with
+const (
+	// DedupScopeDeck compares with the cards of the decks the job writes to
+	DedupScopeDeck = "deck"
+	// DedupScopeAll compares with the cards of every deck
+	DedupScopeAll = "all"
+)
+
+// Kinds of duplicates, from the strictest match to the loosest
+const (
+	DuplicateExact      = "exact"
+	DuplicateNormalized = "normalized"
+	DuplicateSemantic   = "semantic"
+)
+
+// Default similarity above which two cards are semantic duplicates. The
+// scales differ: embeddings of unrelated texts on the same topic are still
+// fairly similar, while TF-IDF vectors of such texts barely overlap.
+const (
+	defaultEmbeddingThreshold = 0.92
+	defaultLexicalThreshold   = 0.75
+)
+
+// maxCachedEmbeddings bounds the embeddings of existing cards kept between
+// jobs
+const maxCachedEmbeddings = 50000
+
+// DedupOptions controls duplicate detection for a job
+type DedupOptions struct {
+	// Mode is "flag" (the default), "drop", "merge" or "off"
+	Mode string `json:"mode,omitempty"`
+	// Scope is "deck" (the default) or "all"
+	Scope string `json:"scope,omitempty"`
+	// Threshold overrides the similarity from which cards count as
+	// semantic duplicates
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// Validate checks the mode and threshold
+func (o DedupOptions) Validate() error {
+	switch o.Mode {
+	case "", DedupFlag, DedupDrop, DedupMerge, DedupOff:
+	default:
+		return fmt.Errorf("%w: unknown dedup mode %q", ErrInvalidGeneration, o.Mode)
+	}
+	switch o.Scope {
+	case "", DedupScopeDeck, DedupScopeAll:
+	default:
+		return fmt.Errorf("%w: unknown dedup scope %q", ErrInvalidGeneration, o.Scope)
+	}
+	if o.Threshold < 0 || o.Threshold > 1 {
+		return fmt.Errorf("%w: dedup threshold must be between 0 and 1", ErrInvalidGeneration)
+	}
+	return nil
+}
+
+func (o DedupOptions) withDefaults() DedupOptions {
+	if o.Mode == "" {
+		o.Mode = DedupFlag
+	}
+	if o.Scope == "" {
+		o.Scope = DedupScopeDeck
+	}
+	return o
+}
+
+// Duplicate describes the card a card duplicates
+type Duplicate = anki.Duplicate
+
+// dedupRef is a card that later cards are compared with
+type dedupRef struct {
+	question string
+	deck     string
+	text     string
+	tokens   []string
+	// card points at the card while it can still be merged into, that is
+	// while its batch is being deduplicated
+	card *Card
+}
+
+// deduper finds duplicates among the cards of a job and the cards of the
+// decks that already exist. Each file's cards are passed as one batch;
+// they are compared with each other and with all earlier batches.
+type deduper struct {
+	s         *Service
+	mode      string
+	threshold float64
+	refs      []*dedupRef
+	exact     map[string]*dedupRef
+	norm      map[string]*dedupRef
+	// lexical is set once embeddings turned out to be unavailable
+	lexical bool
+}
+
+// newDeduper loads the saved cards generated cards are compared with: those
+// of the decks the job adds cards to, or of every deck
+func (s *Service) newDeduper(input JobInput) *deduper {
+	opts := input.Dedup.withDefaults()
+	d := &deduper{
+		s:         s,
+		mode:      opts.Mode,
+		threshold: opts.Threshold,
+		exact:     make(map[string]*dedupRef),
+		norm:      make(map[string]*dedupRef),
+		lexical:   s.embedder == nil,
+	}
+	if d.mode == DedupOff {
+		return d
+	}
+
+	if d.threshold == 0 {
+		d.threshold = s.config.DedupThreshold
+	}
+
+	var deckIDs []string
+	if opts.Scope == DedupScopeAll {
+		decks, err := s.decks.ListDecks()
+		if err != nil {
+			log.Printf("Warning: Failed to list existing decks: %v", err)
+			return d
+		}
+		for _, deck := range decks {
+			deckIDs = append(deckIDs, deck.ID)
+		}
+	} else if input.MergeDecks {
+		deckIDs = []string{input.mergedDeckName()}
+	} else {
+		for _, file := range input.Files {
+			if name := deckNameFor(file); !containsString(deckIDs, name) {
+				deckIDs = append(deckIDs, name)
+			}
+		}
+	}
+
+	for _, id := range deckIDs {
+		deck, err := s.decks.GetDeck(id)
+		if errors.Is(err, anki.ErrDeckNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: Skipping deck %s in duplicate detection: %v", id, err)
+			continue
+		}
+		for _, card := range deck.Cards {
+			d.add(&dedupRef{question: card.Question, deck: deck.Name, text: dedupText(card.Question, card.Answer)})
+		}
+	}
+	return d
+}
+
+// run deduplicates the cards of one batch written to deckName. It returns
+// the cards to keep and the number of duplicates found.
+func (d *deduper) run(ctx context.Context, cards []Card, deckName string) ([]Card, int) {
+	if d.mode == DedupOff || len(cards) == 0 {
+		return cards, 0
+	}
+
+	batch := make([]*dedupRef, len(cards))
+	for i := range cards {
+		batch[i] = &dedupRef{
+			question: cards[i].Question,
+			deck:     deckName,
+			text:     dedupText(cards[i].Question, cards[i].Answer),
+			card:     &cards[i],
+		}
+	}
+
+	matches := make([]*Duplicate, len(cards))
+	targets := make([]*dedupRef, len(cards))
+	for i, ref := range batch {
+		if other, ok := d.exact[exactKey(ref)]; ok {
+			matches[i], targets[i] = d.duplicate(other, deckName, DuplicateExact, 1), other
+		} else if other, ok := d.norm[normalizedKey(ref)]; ok {
+			matches[i], targets[i] = d.duplicate(other, deckName, DuplicateNormalized, 1), other
+		}
+		if matches[i] == nil {
+			d.add(ref)
+		}
+	}
+	d.semantic(ctx, batch, matches, targets, deckName)
+
+	// Merge first, as a card may be merged into one that comes before it
+	found := 0
+	for i, match := range matches {
+		if match == nil {
+			continue
+		}
+		found++
+		if d.mode == DedupMerge && targets[i].card != nil {
+			mergeCard(targets[i].card, cards[i])
+		}
+	}
+
+	var kept []Card
+	for i, card := range cards {
+		switch {
+		case matches[i] == nil:
+			kept = append(kept, card)
+		case d.mode == DedupFlag:
+			card.Duplicate = matches[i]
+			kept = append(kept, card)
+		}
+	}
+
+	// The kept cards are copies, so later batches cannot merge into them
+	for _, ref := range batch {
+		ref.card = nil
+	}
+	return kept, found
+}
+
+// semantic finds cards of the batch without an exact or normalized match
+// that mean the same as an earlier card. Embeddings are used when the
+// provider supports them, otherwise TF-IDF similarity of candidates found
+// by MinHash.
+func (d *deduper) semantic(ctx context.Context, batch []*dedupRef, matches []*Duplicate, targets []*dedupRef, deckName string) {
+	if !d.lexical {
+		similar, err := d.embeddingMatches(ctx, batch, matches)
+		if err == nil {
+			d.apply(batch, matches, targets, similar, defaultEmbeddingThreshold, deckName)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Warning: Embeddings failed (%v), falling back to lexical duplicate detection", err)
+		d.lexical = true
+	}
+	d.apply(batch, matches, targets, d.lexicalMatches(batch, matches), defaultLexicalThreshold, deckName)
+}
+
+// similarity is the closest earlier card to a card of the batch
+type similarity struct {
+	ref   *dedupRef
+	score float64
+}
+
+// apply records the semantic matches above the threshold
+func (d *deduper) apply(batch []*dedupRef, matches []*Duplicate, targets []*dedupRef, similar []similarity, threshold float64, deckName string) {
+	if d.threshold > 0 {
+		threshold = d.threshold
+	}
+	for i, sim := range similar {
+		if matches[i] != nil || sim.ref == nil || sim.score < threshold {
+			continue
+		}
+		matches[i], targets[i] = d.duplicate(sim.ref, deckName, DuplicateSemantic, sim.score), sim.ref
+		d.remove(batch[i])
+	}
+}
+
+// embeddingMatches returns, for every unmatched card of the batch, the most
+// similar earlier card by cosine similarity of their embeddings. Earlier
+// cards of the same batch count as well.
+func (d *deduper) embeddingMatches(ctx context.Context, batch []*dedupRef, matches []*Duplicate) ([]similarity, error) {
+	var texts []string
+	for _, ref := range d.refs {
+		texts = append(texts, ref.text)
+	}
+	vectors, err := d.s.embeddings.get(ctx, d.s.embedder, texts)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[*dedupRef]int, len(d.refs))
+	for i, ref := range d.refs {
+		index[ref] = i
+	}
+
+	similar := make([]similarity, len(batch))
+	for i, ref := range batch {
+		own, ok := index[ref]
+		if matches[i] != nil || !ok {
+			continue
+		}
+		// Cards are only compared with the ones before them
+		for j, other := range d.refs[:own] {
+			if score := cosine(vectors[own], vectors[j]); score > similar[i].score {
+				similar[i] = similarity{ref: other, score: score}
+			}
+		}
+	}
+	return similar, nil
+}
+
+// lexicalMatches is the fallback without embeddings. MinHash signatures of
+// the cards' words find candidate pairs, which are scored by the cosine
+// similarity of their TF-IDF vectors.
+func (d *deduper) lexicalMatches(batch []*dedupRef, matches []*Duplicate) []similarity {
+	for _, ref := range d.refs {
+		if ref.tokens == nil {
+			ref.tokens = shingles(ref.text)
+		}
+	}
+	vectors := tfidf(d.refs)
+
+	buckets := make(map[uint64][]int)
+	index := make(map[*dedupRef]int, len(d.refs))
+	for i, ref := range d.refs {
+		index[ref] = i
+	}
+
+	similar := make([]similarity, len(batch))
+	// Earlier cards are bucketed before later ones are looked up, so a card
+	// is only compared with the cards before it
+	next := 0
+	for i, ref := range batch {
+		own, ok := index[ref]
+		if !ok {
+			continue
+		}
+		for ; next < own; next++ {
+			for _, key := range lshKeys(d.refs[next].tokens) {
+				buckets[key] = append(buckets[key], next)
+			}
+		}
+		if matches[i] != nil {
+			continue
+		}
+
+		seen := make(map[int]bool)
+		for _, key := range lshKeys(ref.tokens) {
+			for _, j := range buckets[key] {
+				if seen[j] {
+					continue
+				}
+				seen[j] = true
+				if score := sparseCosine(vectors[own], vectors[j]); score > similar[i].score {
+					similar[i] = similarity{ref: d.refs[j], score: score}
+				}
+			}
+		}
+	}
+	return similar
+}
+
+// duplicate describes a match with ref for a card written to deckName
+func (d *deduper) duplicate(ref *dedupRef, deckName, kind string, score float64) *Duplicate {
+	dup := &Duplicate{Of: ref.question, Kind: kind, Similarity: math.Round(score*1000) / 1000}
+	if ref.deck != deckName {
+		dup.Deck = ref.deck
+	}
+	return dup
+}
+
+// add makes ref a card later cards are compared with
+func (d *deduper) add(ref *dedupRef) {
+	d.refs = append(d.refs, ref)
+	if _, ok := d.exact[exactKey(ref)]; !ok {
+		d.exact[exactKey(ref)] = ref
+	}
+	if _, ok := d.norm[normalizedKey(ref)]; !ok {
+		d.norm[normalizedKey(ref)] = ref
+	}
+}
+
+// remove takes back a card of the current batch that turned out to be a
+// semantic duplicate. Flagged cards stay, as they remain in the deck.
+func (d *deduper) remove(ref *dedupRef) {
+	if d.mode == DedupFlag {
+		return
+	}
+	for i, r := range d.refs {
+		if r == ref {
+			d.refs = append(d.refs[:i], d.refs[i+1:]...)
+			break
+		}
+	}
+	if d.exact[exactKey(ref)] == ref {
+		delete(d.exact, exactKey(ref))
+	}
+	if d.norm[normalizedKey(ref)] == ref {
+		delete(d.norm, normalizedKey(ref))
+	}
+}
+
+// mergeCard folds a duplicate's tags and pages into the card it duplicates
+func mergeCard(into *Card, dup Card) {
+	for _, tag := range dup.Tags {
+		if !containsString(into.Tags, tag) {
+			into.Tags = append(into.Tags, tag)
+		}
+	}
+	if dup.Source.Pages != "" && !containsString(strings.Split(into.Source.Pages, ", "), dup.Source.Pages) {
+		if into.Source.Pages == "" {
+			into.Source.Pages = dup.Source.Pages
+		} else {
+			into.Source.Pages += ", " + dup.Source.Pages
+		}
+	}
+}
+
+// dedupText is what cards are compared by
+func dedupText(question, answer string) string {
+	return question + "\n" + answer
+}
+
+func exactKey(ref *dedupRef) string {
+	return strings.TrimSpace(ref.text)
+}
+
+// normalizedKey ignores case, punctuation and spacing of the question
+func normalizedKey(ref *dedupRef) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(ref.question), func(r rune) bool {
+		return !isWordRune(r)
+	}), " ")
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// shingles are the content words of a text and their bigrams
+func shingles(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) }) {
+		if len(word) >= 3 && !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	tokens := append([]string(nil), words...)
+	for i := 1; i < len(words); i++ {
+		tokens = append(tokens, words[i-1]+" "+words[i])
+	}
+	return tokens
+}
+
+// tfidf returns L2-normalized TF-IDF vectors of the refs' shingles
+func tfidf(refs []*dedupRef) []map[string]float64 {
+	df := make(map[string]int)
+	for _, ref := range refs {
+		seen := make(map[string]bool)
+		for _, token := range ref.tokens {
+			if !seen[token] {
+				seen[token] = true
+				df[token]++
+			}
+		}
+	}
+
+	vectors := make([]map[string]float64, len(refs))
+	for i, ref := range refs {
+		vector := make(map[string]float64)
+		for _, token := range ref.tokens {
+			vector[token]++
+		}
+		norm := 0.0
+		for token, tf := range vector {
+			weight := tf * (math.Log(float64(1+len(refs))/float64(1+df[token])) + 1)
+			vector[token] = weight
+			norm += weight * weight
+		}
+		norm = math.Sqrt(norm)
+		for token := range vector {
+			vector[token] /= norm
+		}
+		vectors[i] = vector
+	}
+	return vectors
+}
+
+func sparseCosine(a, b map[string]float64) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	dot := 0.0
+	for token, weight := range a {
+		dot += weight * b[token]
+	}
+	return dot
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// MinHash parameters. With 32 bands of 2 rows, texts sharing 40% of their
+// shingles become candidates with a probability above 99%.
+const (
+	minHashBands = 32
+	minHashRows  = 2
+)
+
+// lshKeys returns the band keys of a MinHash signature of tokens. Texts
+// with a common key are candidate duplicates.
+func lshKeys(tokens []string) []uint64 {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	signature := make([]uint64, minHashBands*minHashRows)
+	for i := range signature {
+		signature[i] = math.MaxUint64
+	}
+	for _, token := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		base := h.Sum64()
+		for i := range signature {
+			if v := mix64(base ^ uint64(i+1)*0x9e3779b97f4a7c15); v < signature[i] {
+				signature[i] = v
+			}
+		}
+	}
+
+	keys := make([]uint64, minHashBands)
+	for band := range keys {
+		key := uint64(band)
+		for _, v := range signature[band*minHashRows : (band+1)*minHashRows] {
+			key = mix64(key ^ v)
+		}
+		keys[band] = key
+	}
+	return keys
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// embeddingCache keeps the embeddings of card texts, so the cards of
+// existing decks are only embedded once
+type embeddingCache struct {
+	mu      sync.Mutex
+	vectors map[string][]float32
+}
+
+// get returns the embeddings of texts, computing the missing ones. The
+// result is assembled before the cache is evicted, so texts cached earlier
+// keep their vectors when the cache fills up.
+func (c *embeddingCache) get(ctx context.Context, embedder Embedder, texts []string) ([][]float32, error) {
+	found := make(map[string][]float32, len(texts))
+	var missing []string
+	c.mu.Lock()
+	for _, text := range texts {
+		if _, ok := found[text]; ok {
+			continue
+		}
+		vector, ok := c.vectors[text]
+		if !ok {
+			missing = append(missing, text)
+		}
+		found[text] = vector
+	}
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		computed, err := embedder.Embed(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		if len(computed) != len(missing) {
+			return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(computed), len(missing))
+		}
+		for i, text := range missing {
+			found[text] = computed[i]
+		}
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = found[text]
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vectors == nil || len(c.vectors)+len(missing) > maxCachedEmbeddings {
+		c.vectors = make(map[string][]float32)
+	}
+	for _, text := range missing {
+		if len(c.vectors) >= maxCachedEmbeddings {
+			break
+		}
+		c.vectors[text] = found[text]
+	}
+	return vectors, nil
+}
@@ -0,0 +1,263 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// countingEmbedder embeds each text as a vector of its length and counts
+// the texts it was asked for
+type countingEmbedder struct {
+	embedded int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.embedded += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text)), 1}
+	}
+	return vectors, nil
+}
+
+func TestEmbeddingCacheKeepsVectorsWhenEvicting(t *testing.T) {
+	var cache embeddingCache
+	embedder := &countingEmbedder{}
+	ctx := context.Background()
+
+	if _, err := cache.get(ctx, embedder, []string{"cached card"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// More new texts than the cache holds force an eviction
+	texts := []string{"cached card"}
+	for i := 0; i < maxCachedEmbeddings+10; i++ {
+		texts = append(texts, fmt.Sprintf("card %d", i))
+	}
+	vectors, err := cache.get(ctx, embedder, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			t.Fatalf("text %d (%q) has no vector", i, texts[i])
+		}
+	}
+	if embedder.embedded != len(texts) {
+		t.Errorf("embedded %d texts, want %d", embedder.embedded, len(texts))
+	}
+	if len(cache.vectors) > maxCachedEmbeddings {
+		t.Errorf("cache holds %d vectors, limit is %d", len(cache.vectors), maxCachedEmbeddings)
+	}
+}
+
+func TestEmbeddingCacheReusesVectors(t *testing.T) {
+	var cache embeddingCache
+	embedder := &countingEmbedder{}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		vectors, err := cache.get(ctx, embedder, []string{"a", "b", "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vectors) != 3 || vectors[0] == nil || vectors[2] == nil {
+			t.Fatalf("vectors = %v", vectors)
+		}
+	}
+	if embedder.embedded != 2 {
+		t.Errorf("embedded %d texts, want 2", embedder.embedded)
+	}
+}
+
+// mapEmbedder returns fixed vectors for known texts and a vector of its own
+// for every other text
+type mapEmbedder struct {
+	vectors map[string][]float32
+	err     error
+}
+
+func (e *mapEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if vector, ok := e.vectors[text]; ok {
+			vectors[i] = vector
+			continue
+		}
+		// Orthogonal to the fixed vectors and to other unknown texts
+		vectors[i] = make([]float32, 3+len(texts))
+		vectors[i][3+i] = 1
+	}
+	return vectors, nil
+}
+
+// newTestDeduper creates a deduper for a job writing the deck "biology",
+// with decks saved beforehand
+func newTestDeduper(t *testing.T, opts DedupOptions, embedder Embedder, decks ...*anki.Deck) *deduper {
+	t.Helper()
+	repo, err := anki.NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, deck := range decks {
+		if err := repo.SaveDeck(deck); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Service{decks: repo, embedder: embedder}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return s.newDeduper(JobInput{Files: []string{"/uploads/biology.pdf"}, JobOptions: JobOptions{Dedup: opts}})
+}
+
+func dedupCard(question, answer, tag, pages string) Card {
+	return Card{Question: question, Answer: answer, Tags: []string{tag}, Source: Source{File: "biology.pdf", Pages: pages}}
+}
+
+func TestDeduperModes(t *testing.T) {
+	original := dedupCard("What do mitochondria produce?", "Mitochondria produce ATP for the cell.", "energy", "1")
+	batch := []Card{
+		original,
+		dedupCard(original.Question, original.Answer, "cells", "2"),
+		dedupCard("what do Mitochondria produce", "They make ATP.", "atp", "3"),
+		dedupCard("Mitochondria produce what?", "Mitochondria produce ATP for the cell.", "respiration", "4"),
+		dedupCard("What does the nucleus store?", "Genetic information as chromosomes.", "nucleus", "5"),
+	}
+	kinds := []string{"", DuplicateExact, DuplicateNormalized, DuplicateSemantic, ""}
+
+	tests := []struct {
+		mode string
+		// kept are the indices of the cards kept
+		kept []int
+	}{
+		{DedupFlag, []int{0, 1, 2, 3, 4}},
+		{DedupDrop, []int{0, 4}},
+		{DedupMerge, []int{0, 4}},
+		{DedupOff, []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			d := newTestDeduper(t, DedupOptions{Mode: tt.mode}, nil)
+			cards := append([]Card(nil), batch...)
+			kept, found := d.run(context.Background(), cards, "biology")
+
+			wantFound := 3
+			if tt.mode == DedupOff {
+				wantFound = 0
+			}
+			if found != wantFound {
+				t.Errorf("found %d duplicates, want %d", found, wantFound)
+			}
+			if len(kept) != len(tt.kept) {
+				t.Fatalf("kept %d cards, want %d: %+v", len(kept), len(tt.kept), kept)
+			}
+			for i, index := range tt.kept {
+				card := kept[i]
+				if card.Question != batch[index].Question {
+					t.Errorf("kept card %d is %q, want %q", i, card.Question, batch[index].Question)
+				}
+				switch {
+				case tt.mode == DedupFlag && kinds[index] != "":
+					if card.Duplicate == nil || card.Duplicate.Kind != kinds[index] || card.Duplicate.Of != original.Question || card.Duplicate.Deck != "" {
+						t.Errorf("card %d duplicate = %+v, want a %s duplicate of the first card", index, card.Duplicate, kinds[index])
+					}
+				case card.Duplicate != nil:
+					t.Errorf("card %d is flagged as duplicate %+v", index, card.Duplicate)
+				}
+			}
+
+			first := kept[0]
+			if tt.mode == DedupMerge {
+				if want := []string{"energy", "cells", "atp", "respiration"}; !slices.Equal(first.Tags, want) || first.Source.Pages != "1, 2, 3, 4" {
+					t.Errorf("merged card has tags %q and pages %q", first.Tags, first.Source.Pages)
+				}
+			} else if len(first.Tags) != 1 || first.Source.Pages != "1" {
+				t.Errorf("card was merged into in %s mode: %+v", tt.mode, first)
+			}
+		})
+	}
+}
+
+func TestDeduperEmbeddings(t *testing.T) {
+	original := dedupCard("What powers the cell?", "ATP", "energy", "1")
+	similar := dedupCard("Which molecule stores energy for cells?", "Adenosine triphosphate", "energy", "2")
+	other := dedupCard("What stores genetic information?", "DNA", "dna", "3")
+	embedder := &mapEmbedder{vectors: map[string][]float32{
+		dedupText(original.Question, original.Answer): {1, 0, 0},
+		dedupText(similar.Question, similar.Answer):   {0.95, 0.3122, 0},
+		dedupText(other.Question, other.Answer):       {0.5, 0, 0.866},
+	}}
+
+	d := newTestDeduper(t, DedupOptions{Mode: DedupFlag}, embedder)
+	kept, found := d.run(context.Background(), []Card{original, similar, other}, "biology")
+	if found != 1 || len(kept) != 3 {
+		t.Fatalf("found %d duplicates in %d kept cards, want 1 in 3", found, len(kept))
+	}
+	dup := kept[1].Duplicate
+	if dup == nil || dup.Kind != DuplicateSemantic || dup.Of != original.Question || dup.Similarity != 0.95 {
+		t.Errorf("duplicate = %+v, want a semantic duplicate with similarity 0.95", dup)
+	}
+	if kept[2].Duplicate != nil {
+		t.Errorf("dissimilar card flagged: %+v", kept[2].Duplicate)
+	}
+
+	// A threshold below the similarity of the third card flags it as well
+	d = newTestDeduper(t, DedupOptions{Mode: DedupDrop, Threshold: 0.5}, embedder)
+	if kept, found := d.run(context.Background(), []Card{original, similar, other}, "biology"); found != 2 || len(kept) != 1 {
+		t.Errorf("found %d duplicates and kept %d cards with a lower threshold, want 2 and 1", found, len(kept))
+	}
+}
+
+func TestDeduperFallsBackToLexical(t *testing.T) {
+	embedder := &mapEmbedder{err: errors.New("embeddings unavailable")}
+	d := newTestDeduper(t, DedupOptions{Mode: DedupDrop}, embedder)
+	cards := []Card{
+		dedupCard("What do mitochondria produce?", "Mitochondria produce ATP for the cell.", "energy", "1"),
+		dedupCard("Mitochondria produce what?", "Mitochondria produce ATP for the cell.", "energy", "2"),
+	}
+	kept, found := d.run(context.Background(), cards, "biology")
+	if found != 1 || len(kept) != 1 || !d.lexical {
+		t.Errorf("found %d duplicates and kept %d cards (lexical %v), want the lexical match", found, len(kept), d.lexical)
+	}
+}
+
+func TestDeduperScope(t *testing.T) {
+	biology := &anki.Deck{Name: "biology", Cards: []anki.Card{{Question: "What do mitochondria produce?", Answer: "ATP"}}}
+	chemistry := &anki.Deck{Name: "chemistry", Cards: []anki.Card{{Question: "What is a mole?", Answer: "6.022e23 particles"}}}
+	batch := []Card{
+		dedupCard("What do mitochondria produce?", "ATP", "energy", "1"),
+		dedupCard("What is a mole?", "6.022e23 particles", "units", "2"),
+	}
+
+	d := newTestDeduper(t, DedupOptions{Mode: DedupFlag}, nil, biology, chemistry)
+	kept, found := d.run(context.Background(), append([]Card(nil), batch...), "biology")
+	if found != 1 || kept[0].Duplicate == nil || kept[0].Duplicate.Deck != "" || kept[1].Duplicate != nil {
+		t.Errorf("deck scope found %d duplicates: %+v, %+v; want only the card of the target deck", found, kept[0].Duplicate, kept[1].Duplicate)
+	}
+
+	d = newTestDeduper(t, DedupOptions{Mode: DedupFlag, Scope: DedupScopeAll}, nil, biology, chemistry)
+	kept, found = d.run(context.Background(), append([]Card(nil), batch...), "biology")
+	if found != 2 || kept[1].Duplicate == nil || kept[1].Duplicate.Deck != "chemistry" {
+		t.Errorf("all scope found %d duplicates: %+v, want the chemistry card too", found, kept[1].Duplicate)
+	}
+
+	// Saved cards cannot be merged into, so their duplicates are dropped
+	d = newTestDeduper(t, DedupOptions{Mode: DedupMerge}, nil, biology, chemistry)
+	kept, found = d.run(context.Background(), append([]Card(nil), batch...), "biology")
+	if found != 1 || len(kept) != 1 || kept[0].Question != "What is a mole?" {
+		t.Errorf("merge found %d duplicates and kept %+v", found, kept)
+	}
+
+	if err := (DedupOptions{Scope: "everything"}).Validate(); err == nil {
+		t.Errorf("Validate accepted an unknown scope")
+	}
+}
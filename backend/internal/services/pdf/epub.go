@@ -0,0 +1,130 @@
+package pdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// epubChapter is a document of an EPUB's reading order
+type epubChapter struct {
+	// Title is the chapter's first heading, empty if it has none
+	Title string
+	Text  string
+}
+
+// readEPUB returns the chapters of an EPUB that contain text in reading
+// order
+func readEPUB(filePath string) ([]epubChapter, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer archive.Close()
+
+	data, err := readZipPart(archive, "META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container struct {
+		Rootfiles []struct {
+			Path string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("failed to parse EPUB container: %w", err)
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("EPUB container lists no package")
+	}
+	packagePath := container.Rootfiles[0].Path
+
+	data, err = readZipPart(archive, packagePath)
+	if err != nil {
+		return nil, err
+	}
+	var pkg struct {
+		Items []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse EPUB package: %w", err)
+	}
+	documents := make(map[string]string, len(pkg.Items))
+	for _, item := range pkg.Items {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			href, err := url.PathUnescape(item.Href)
+			if err != nil {
+				href = item.Href
+			}
+			documents[item.ID] = path.Join(path.Dir(packagePath), href)
+		}
+	}
+
+	var chapters []epubChapter
+	for _, ref := range pkg.Spine {
+		name, ok := documents[ref.IDRef]
+		if !ok {
+			continue
+		}
+		data, err := readZipPart(archive, name)
+		if err != nil {
+			return nil, err
+		}
+		text, err := htmlText(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if text == "" {
+			continue
+		}
+		chapter := epubChapter{Text: text}
+		for _, line := range strings.Split(text, "\n") {
+			if markdownHeading.MatchString(line) {
+				chapter.Title = deckTitle(strings.TrimLeft(line, "# "))
+				break
+			}
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, nil
+}
+
+// extractEPUB reads each chapter of an EPUB as a page
+func extractEPUB(filePath string) ([]Page, error) {
+	chapters, err := readEPUB(filePath)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]Page, len(chapters))
+	for i, chapter := range chapters {
+		pages[i] = Page{Number: i + 1, Text: chapter.Text}
+	}
+	return pages, nil
+}
+
+// readEPUBOutline returns the chapter titles of an EPUB as an outline whose
+// pages are the chapters
+func readEPUBOutline(filePath string) (outline, error) {
+	chapters, err := readEPUB(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var items outline
+	for i, chapter := range chapters {
+		if chapter.Title != "" {
+			items = append(items, outlineItem{Title: chapter.Title, Page: i + 1})
+		}
+	}
+	return items, nil
+}
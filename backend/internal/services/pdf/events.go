@@ -0,0 +1,113 @@
+package pdf
+
+import (
+	"time"
+)
+
+// Job event types streamed to clients
+const (
+	EventStatus        = "status"
+	EventPageExtracted = "page_extracted"
+	EventChunkSent     = "chunk_sent"
+	EventCardsParsed   = "cards_parsed"
+	EventFileFinished  = "file_finished"
+	EventError         = "error"
+)
+
+// maxEventHistory bounds the events kept per running job for late subscribers
+const maxEventHistory = 2000
+
+// subscriberBuffer is the number of events a slow subscriber may lag behind
+const subscriberBuffer = 256
+
+// JobEvent is a single progress event of a processing job
+type JobEvent struct {
+	Type        string            `json:"type"`
+	JobID       string            `json:"jobId"`
+	Time        time.Time         `json:"time"`
+	File        string            `json:"file,omitempty"`
+	Page        int               `json:"page,omitempty"`
+	OCR         bool              `json:"ocr,omitempty"`
+	Chunk       int               `json:"chunk,omitempty"`
+	TotalChunks int               `json:"totalChunks,omitempty"`
+	Cards       []Card            `json:"cards,omitempty"`
+	CardCount   int               `json:"cardCount,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Status      *ProcessingStatus `json:"status,omitempty"`
+}
+
+// SubscribeJob returns a channel with the events of a job, starting with the
+// events it has already emitted. The channel is closed when the job reaches a
+// terminal state; call the returned function to stop listening earlier.
+func (s *Service) SubscribeJob(jobID string) (<-chan JobEvent, func(), error) {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+
+	// Read the status under the events lock so a job cannot finish between
+	// the check and the registration below
+	status := s.GetJobStatus(jobID)
+	if status == nil {
+		return nil, nil, ErrJobNotFound
+	}
+
+	history := s.eventHistory[jobID]
+	ch := make(chan JobEvent, len(history)+1+subscriberBuffer)
+	for _, event := range history {
+		ch <- event
+	}
+
+	// Finished jobs only report their final status
+	if IsTerminalStatus(status.Status) {
+		ch <- JobEvent{Type: EventStatus, JobID: jobID, Time: time.Now(), Status: status}
+		close(ch)
+		return ch, func() {}, nil
+	}
+
+	if s.subscribers[jobID] == nil {
+		s.subscribers[jobID] = make(map[chan JobEvent]struct{})
+	}
+	s.subscribers[jobID][ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.eventsMutex.Lock()
+		defer s.eventsMutex.Unlock()
+		if subs, ok := s.subscribers[jobID]; ok {
+			if _, ok := subs[ch]; ok {
+				delete(subs, ch)
+				close(ch)
+			}
+		}
+	}
+	return ch, unsubscribe, nil
+}
+
+// publish delivers an event to every subscriber of the job
+func (s *Service) publish(event JobEvent) {
+	event.Time = time.Now()
+
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+
+	history := append(s.eventHistory[event.JobID], event)
+	if len(history) > maxEventHistory {
+		history = history[len(history)-maxEventHistory:]
+	}
+	s.eventHistory[event.JobID] = history
+
+	for ch := range s.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+			// Never block processing on a stalled client; the stream
+			// sends a missed final status when the channel closes
+		}
+	}
+
+	if event.Type == EventStatus && event.Status != nil && IsTerminalStatus(event.Status.Status) {
+		for ch := range s.subscribers[event.JobID] {
+			close(ch)
+		}
+		delete(s.subscribers, event.JobID)
+		delete(s.eventHistory, event.JobID)
+	}
+}
@@ -0,0 +1,72 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSubscribeJobLate(t *testing.T) {
+	provider := newBlockingProvider()
+	s := newTestService(t, t.TempDir(), provider, ProcessingConfig{Workers: 1})
+	defer s.Close(context.Background())
+
+	if _, _, err := s.SubscribeJob("job_0"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("SubscribeJob of an unknown job = %v, want ErrJobNotFound", err)
+	}
+
+	jobID := startTestJob(t, s, "cell.txt")
+	<-provider.started
+
+	// Nothing is published while the chunk is held, so the buffered events
+	// are the history
+	events, unsubscribe, err := s.SubscribeJob(jobID)
+	if err != nil {
+		t.Fatalf("SubscribeJob: %v", err)
+	}
+	defer unsubscribe()
+	var history []string
+	for range len(events) {
+		history = append(history, (<-events).Type)
+	}
+	for _, want := range []string{EventStatus, EventPageExtracted, EventChunkSent} {
+		if !slices.Contains(history, want) {
+			t.Errorf("history %q lacks a %s event", history, want)
+		}
+	}
+	if history[len(history)-1] != EventChunkSent {
+		t.Errorf("history %q does not end with the chunk in flight", history)
+	}
+
+	close(provider.release)
+	var live []JobEvent
+	for event := range events {
+		live = append(live, event)
+	}
+	if len(live) == 0 {
+		t.Fatal("no events after the history")
+	}
+	last := live[len(live)-1]
+	if last.Type != EventStatus || last.Status == nil || last.Status.Status != "completed" {
+		t.Errorf("last event = %s with status %+v, want the completed status", last.Type, last.Status)
+	}
+	for _, event := range live[:len(live)-1] {
+		if event.Type == EventStatus && event.Status != nil && IsTerminalStatus(event.Status.Status) {
+			t.Errorf("terminal status %q before the last event", event.Status.Status)
+		}
+	}
+
+	// After the job finished only its final status is sent
+	events, _, err = s.SubscribeJob(jobID)
+	if err != nil {
+		t.Fatalf("SubscribeJob of a finished job: %v", err)
+	}
+	var after []JobEvent
+	for event := range events {
+		after = append(after, event)
+	}
+	if len(after) != 1 || after[0].Type != EventStatus || after[0].Status.Status != "completed" {
+		t.Errorf("events of a finished job = %+v, want only the completed status", after)
+	}
+}
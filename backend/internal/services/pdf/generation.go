@@ -0,0 +1,250 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// Density modes control how thoroughly the text is covered
+const (
+	DensityBalanced    = "balanced"
+	DensityExhaustive  = "exhaustive"
+	DensityKeyConcepts = "key_concepts"
+)
+
+// densityRates is the default number of cards per 1000 words of each mode
+var densityRates = map[string]float64{
+	DensityBalanced:    5,
+	DensityExhaustive:  12,
+	DensityKeyConcepts: 3,
+}
+
+// densityFocus is the instruction added to the prompt for each mode
+var densityFocus = map[string]string{
+	DensityExhaustive:  "Cover every fact, definition, example and detail in the text, including minor ones.",
+	DensityKeyConcepts: "Only cover the most important concepts of the text and skip minor details and examples.",
+}
+
+const (
+	// defaultMaxPerChunk keeps responses well within the output token limit
+	defaultMaxPerChunk = 25
+	// maxCardsPerChunk is the largest count a job may request per chunk
+	maxCardsPerChunk = 60
+	// tokensPerCard estimates the output tokens of one card
+	tokensPerCard = 150
+)
+
+// ErrInvalidGeneration is returned for generation parameters that are out
+// of range
+var ErrInvalidGeneration = errors.New("invalid generation parameters")
+
+// GenerationParams controls how many cards are generated. At most one of
+// TargetTotal, CardsPerPage and CardsPer1000Words is used, in that order;
+// without any of them the density mode picks a rate per 1000 words.
+type GenerationParams struct {
+	// TargetTotal is the number of cards to create per file. It is met
+	// unless MaxPerChunk caps every chunk; when it does not allow
+	// MinPerChunk cards in every chunk, the chunks with the fewest words get
+	// none.
+	TargetTotal int `json:"targetTotal,omitempty"`
+	// CardsPerPage scales the number of cards with the page count
+	CardsPerPage float64 `json:"cardsPerPage,omitempty"`
+	// CardsPer1000Words scales the number of cards with the text length
+	CardsPer1000Words float64 `json:"cardsPer1000Words,omitempty"`
+	// MinPerChunk and MaxPerChunk bound the cards requested per chunk. The
+	// rates above are only approximate, so with them every chunk gets at
+	// least MinPerChunk cards even if that exceeds the rate.
+	MinPerChunk int `json:"minPerChunk,omitempty"`
+	MaxPerChunk int `json:"maxPerChunk,omitempty"`
+	// Density is "balanced", "exhaustive" or "key_concepts"
+	Density string `json:"density,omitempty"`
+	// CardsPerTopic is the number of document-level topic cards
+	CardsPerTopic int `json:"cardsPerTopic,omitempty"`
+	// CardType is "basic" (question and answer), "cloze" or "mixed"
+	CardType string `json:"cardType,omitempty"`
+	// NoteType is "basic", "reversed" or "optional_reversed" and decides
+	// in which directions basic cards are asked
+	NoteType string `json:"noteType,omitempty"`
+}
+
+// Validate checks that the parameters are in range
+func (p GenerationParams) Validate() error {
+	switch {
+	case p.TargetTotal < 0 || p.CardsPerPage < 0 || p.CardsPer1000Words < 0 ||
+		p.MinPerChunk < 0 || p.MaxPerChunk < 0 || p.CardsPerTopic < 0:
+		return fmt.Errorf("%w: card counts must not be negative", ErrInvalidGeneration)
+	case p.MaxPerChunk > maxCardsPerChunk:
+		return fmt.Errorf("%w: at most %d cards per chunk are supported", ErrInvalidGeneration, maxCardsPerChunk)
+	case p.MaxPerChunk > 0 && p.MinPerChunk > p.MaxPerChunk:
+		return fmt.Errorf("%w: minPerChunk is larger than maxPerChunk", ErrInvalidGeneration)
+	}
+	if _, ok := densityRates[p.Density]; p.Density != "" && !ok {
+		return fmt.Errorf("%w: unknown density %q", ErrInvalidGeneration, p.Density)
+	}
+	if _, ok := cardModeTypes[p.CardType]; p.CardType != "" && !ok {
+		return fmt.Errorf("%w: unknown card type %q", ErrInvalidGeneration, p.CardType)
+	}
+	if p.NoteType != "" && !containsString(anki.NoteTypes, p.NoteType) {
+		return fmt.Errorf("%w: unknown note type %q", ErrInvalidGeneration, p.NoteType)
+	}
+	return nil
+}
+
+// withDefaults fills in unset parameters so the job status shows the values
+// that are actually used
+func (p GenerationParams) withDefaults(cardsPerTopic int) GenerationParams {
+	if p.Density == "" {
+		p.Density = DensityBalanced
+	}
+	if p.TargetTotal == 0 && p.CardsPerPage == 0 && p.CardsPer1000Words == 0 {
+		p.CardsPer1000Words = densityRates[p.Density]
+	}
+	if p.MinPerChunk == 0 {
+		p.MinPerChunk = 1
+	}
+	if p.MaxPerChunk == 0 {
+		p.MaxPerChunk = max(defaultMaxPerChunk, p.MinPerChunk)
+	}
+	if p.CardsPerTopic == 0 {
+		p.CardsPerTopic = cardsPerTopic
+	}
+	if p.CardType == "" {
+		p.CardType = CardTypeBasic
+	}
+	if p.NoteType == "" {
+		p.NoteType = anki.NoteTypeBasic
+	}
+	return p
+}
+
+// focus returns the prompt instruction for the density mode
+func (p GenerationParams) focus() string {
+	return densityFocus[p.Density]
+}
+
+// cardMode returns the card type asked for. Jobs recorded before card types
+// could be chosen only generated basic cards.
+func (p GenerationParams) cardMode() string {
+	if p.CardType == "" {
+		return CardTypeBasic
+	}
+	return p.CardType
+}
+
+// cardTypes returns the card types accepted in responses, the first being
+// the type of cards that do not state one
+func (p GenerationParams) cardTypes() []string {
+	return cardModeTypes[p.cardMode()]
+}
+
+// planCardCounts decides how many cards to request from each chunk of a
+// file. The file's total is spread over the chunks by their word counts,
+// then every chunk is clamped to the per-chunk bounds. An explicit
+// TargetTotal is kept by skipping the smallest chunks and moving cards
+// between chunks after clamping.
+func planCardCounts(chunkWords []int, pages int, p GenerationParams) []int {
+	var target float64
+	switch {
+	case p.TargetTotal > 0:
+		target = float64(p.TargetTotal)
+	case p.CardsPerPage > 0 && pages > 0:
+		target = p.CardsPerPage * float64(pages)
+	default:
+		totalWords := 0
+		for _, words := range chunkWords {
+			totalWords += words
+		}
+		target = p.CardsPer1000Words * float64(totalWords) / 1000
+	}
+
+	// The chunks that get cards, largest first when some are skipped
+	selected := make([]int, len(chunkWords))
+	for i := range selected {
+		selected[i] = i
+	}
+	minPerChunk := p.MinPerChunk
+	if p.TargetTotal > 0 {
+		// A total below the minimum goes to the largest chunk alone
+		minPerChunk = min(minPerChunk, p.TargetTotal)
+		if minPerChunk > 0 && len(selected)*minPerChunk > p.TargetTotal {
+			sort.SliceStable(selected, func(a, b int) bool {
+				return chunkWords[selected[a]] > chunkWords[selected[b]]
+			})
+			selected = selected[:p.TargetTotal/minPerChunk]
+			sort.Ints(selected)
+		}
+	}
+
+	selectedWords := 0
+	for _, i := range selected {
+		selectedWords += chunkWords[i]
+	}
+	shares := make([]float64, len(chunkWords))
+	counts := make([]int, len(chunkWords))
+	if selectedWords > 0 {
+		// Largest remainder rounding keeps the sum at the target
+		assigned := 0
+		for _, i := range selected {
+			shares[i] = target * float64(chunkWords[i]) / float64(selectedWords)
+			counts[i] = int(math.Floor(shares[i]))
+			assigned += counts[i]
+		}
+		remainders := append([]int(nil), selected...)
+		sort.SliceStable(remainders, func(a, b int) bool {
+			ea, eb := shares[remainders[a]], shares[remainders[b]]
+			return ea-math.Floor(ea) > eb-math.Floor(eb)
+		})
+		for _, i := range remainders[:max(0, min(len(remainders), int(math.Round(target))-assigned))] {
+			counts[i]++
+		}
+	}
+
+	for _, i := range selected {
+		counts[i] = max(minPerChunk, min(counts[i], p.MaxPerChunk))
+	}
+	if p.TargetTotal > 0 {
+		rebalanceCounts(counts, shares, selected, p.TargetTotal, minPerChunk, p.MaxPerChunk)
+	}
+	return counts
+}
+
+// rebalanceCounts moves the sum of the clamped counts of the selected chunks
+// back to total, one card at a time, taking from the chunk furthest above its
+// share or giving to the one furthest below it
+func rebalanceCounts(counts []int, shares []float64, selected []int, total, lo, hi int) {
+	sum := 0
+	for _, i := range selected {
+		sum += counts[i]
+	}
+	for sum != total {
+		best := -1
+		for _, i := range selected {
+			if sum > total && counts[i] > lo && (best < 0 || float64(counts[i])-shares[i] > float64(counts[best])-shares[best]) {
+				best = i
+			}
+			if sum < total && counts[i] < hi && (best < 0 || shares[i]-float64(counts[i]) > shares[best]-float64(counts[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			// The bounds do not allow the total
+			return
+		}
+		if sum > total {
+			counts[best]--
+			sum--
+		} else {
+			counts[best]++
+			sum++
+		}
+	}
+}
+
+// maxTokensFor returns the output token limit for a request of count cards
+func maxTokensFor(count int) int {
+	return max(2000, count*tokensPerCard)
+}
@@ -0,0 +1,104 @@
+package pdf
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlanCardCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []int
+		pages int
+		p     GenerationParams
+		want  []int
+	}{
+		{
+			name:  "cards per 1000 words",
+			words: []int{1000, 1000},
+			p:     GenerationParams{CardsPer1000Words: 5, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{5, 5},
+		},
+		{
+			name:  "cards per page",
+			words: []int{100, 300},
+			pages: 4,
+			p:     GenerationParams{CardsPerPage: 2, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{2, 6},
+		},
+		{
+			name:  "largest remainders get the rounded cards",
+			words: []int{50, 30, 20},
+			p:     GenerationParams{TargetTotal: 7, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{4, 2, 1},
+		},
+		{
+			name:  "equal remainders go to the first chunks",
+			words: []int{1, 1, 1},
+			p:     GenerationParams{TargetTotal: 10, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{4, 3, 3},
+		},
+		{
+			name:  "rates apply the minimum to every chunk",
+			words: []int{100, 100, 100},
+			p:     GenerationParams{CardsPer1000Words: 5, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{1, 1, 1},
+		},
+		{
+			name:  "chunks without words get the minimum",
+			words: []int{0, 0},
+			p:     GenerationParams{CardsPer1000Words: 5, MinPerChunk: 2, MaxPerChunk: 25},
+			want:  []int{2, 2},
+		},
+		{
+			name:  "a total below one card per chunk skips the smallest chunks",
+			words: []int{500, 20, 300, 10, 200},
+			p:     GenerationParams{TargetTotal: 3, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{1, 0, 1, 0, 1},
+		},
+		{
+			name:  "a total below the minimum of every chunk skips the smallest chunks",
+			words: []int{100, 400, 200, 300},
+			p:     GenerationParams{TargetTotal: 5, MinPerChunk: 2, MaxPerChunk: 25},
+			want:  []int{0, 3, 0, 2},
+		},
+		{
+			name:  "a total below the minimum goes to the largest chunk",
+			words: []int{100, 400, 200},
+			p:     GenerationParams{TargetTotal: 1, MinPerChunk: 3, MaxPerChunk: 25},
+			want:  []int{0, 1, 0},
+		},
+		{
+			name:  "cards raised to the minimum are taken from large chunks",
+			words: []int{900, 50, 50},
+			p:     GenerationParams{TargetTotal: 10, MinPerChunk: 2, MaxPerChunk: 25},
+			want:  []int{6, 2, 2},
+		},
+		{
+			name:  "cards above the maximum go to other chunks",
+			words: []int{800, 100, 100},
+			p:     GenerationParams{TargetTotal: 30, MinPerChunk: 1, MaxPerChunk: 20},
+			want:  []int{20, 5, 5},
+		},
+		{
+			name:  "a total above the maximum of every chunk is capped",
+			words: []int{1, 1},
+			p:     GenerationParams{TargetTotal: 100, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{25, 25},
+		},
+		{
+			name:  "rates are capped at the maximum",
+			words: []int{10000},
+			p:     GenerationParams{CardsPer1000Words: 12, MinPerChunk: 1, MaxPerChunk: 25},
+			want:  []int{25},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planCardCounts(tt.words, tt.pages, tt.p)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planCardCounts(%v) = %v, want %v", tt.words, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package pdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// ErrUnsupportedFormat is returned for files no extractor can read
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// MIME types of the input formats mimetype has no constants for
+const (
+	mimeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimePPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	mimeEPUB     = "application/epub+zip"
+	mimeMarkdown = "text/markdown"
+)
+
+// inputFormat describes how the pages of a file type are extracted
+type inputFormat struct {
+	name    string
+	extract func(s *Service, ctx context.Context, filePath string, onPage func(Page)) ([]Page, error)
+	// outline reads the sections cards are filed into as subdecks, nil for
+	// formats without one
+	outline func(filePath string) (outline, error)
+}
+
+// inputFormats are the extractors by the MIME type sniffed from a file
+var inputFormats = map[string]inputFormat{
+	"application/pdf": {"PDF", (*Service).extractPDF, readOutline},
+	mimeDOCX:          {"DOCX", fileExtractor(extractDOCX), nil},
+	mimePPTX:          {"PPTX", fileExtractor(extractPPTX), nil},
+	mimeEPUB:          {"EPUB", fileExtractor(extractEPUB), readEPUBOutline},
+	"text/html":       {"HTML", fileExtractor(extractHTML), nil},
+	mimeMarkdown:      {"Markdown", fileExtractor(extractMarkdown), nil},
+	"text/plain":      {"plain text", fileExtractor(extractPlainText), nil},
+	"image/png":       {"PNG", (*Service).extractImage, nil},
+	"image/jpeg":      {"JPEG", (*Service).extractImage, nil},
+}
+
+// Content alone cannot tell Markdown or an HTML fragment from plain text, so
+// for text files the extension decides
+var textExtensions = map[string]string{
+	".md":       mimeMarkdown,
+	".markdown": mimeMarkdown,
+	".html":     "text/html",
+	".htm":      "text/html",
+	".xhtml":    "text/html",
+}
+
+// DetectInput returns the MIME type of an uploaded file from its content, or
+// ErrUnsupportedFormat if no extractor reads it
+func DetectInput(data []byte, filename string) (string, error) {
+	return detectInput(mimetype.Detect(data), bytes.NewReader(data), int64(len(data)), filename)
+}
+
+// detectFile returns the MIME type of a file on disk
+func detectFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	mtype, err := mimetype.DetectReader(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect file type: %w", err)
+	}
+	return detectInput(mtype, file, info.Size(), filePath)
+}
+
+func detectInput(mtype *mimetype.MIME, r io.ReaderAt, size int64, filename string) (string, error) {
+	name, _, _ := strings.Cut(mtype.String(), ";")
+	switch name {
+	case "application/zip":
+		// mimetype only reads the start of a file, where Office documents
+		// written by some tools do not yet show what they are
+		if zipType := zipInputType(r, size); zipType != "" {
+			name = zipType
+		}
+	case "text/plain":
+		if textType, ok := textExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+			name = textType
+		}
+	}
+	if _, ok := inputFormats[name]; !ok {
+		return "", fmt.Errorf("%w: %s is %s, expected %s", ErrUnsupportedFormat, filepath.Base(filename), name, strings.Join(inputFormatNames(), ", "))
+	}
+	return name, nil
+}
+
+// zipInputType recognizes the zip based formats by their entries
+func zipInputType(r io.ReaderAt, size int64) string {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return ""
+	}
+	for _, file := range archive.File {
+		switch file.Name {
+		case "word/document.xml":
+			return mimeDOCX
+		case "ppt/presentation.xml":
+			return mimePPTX
+		case "META-INF/container.xml":
+			return mimeEPUB
+		}
+	}
+	return ""
+}
+
+// inputFormatNames lists the supported formats for error messages
+func inputFormatNames() []string {
+	names := make([]string, 0, len(inputFormats))
+	for _, format := range inputFormats {
+		names = append(names, format.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// inputFormatOf looks up the extractor of a file
+func inputFormatOf(filePath string) (inputFormat, error) {
+	mimeType, err := detectFile(filePath)
+	if err != nil {
+		return inputFormat{}, err
+	}
+	return inputFormats[mimeType], nil
+}
+
+// extractPages is ExtractPages with a callback invoked as each page is done
+func (s *Service) extractPages(ctx context.Context, filePath string, onPage func(Page)) ([]Page, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	format, err := inputFormatOf(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return format.extract(s, ctx, absPath, onPage)
+}
+
+// readInputOutline returns the outline of a file, or nil for formats
+// without one
+func readInputOutline(filePath string) (outline, error) {
+	format, err := inputFormatOf(filePath)
+	if err != nil || format.outline == nil {
+		return nil, err
+	}
+	return format.outline(filePath)
+}
+
+// fileExtractor adapts an extractor that reads all pages of a file at once
+func fileExtractor(extract func(filePath string) ([]Page, error)) func(*Service, context.Context, string, func(Page)) ([]Page, error) {
+	return func(s *Service, ctx context.Context, filePath string, onPage func(Page)) ([]Page, error) {
+		pages, err := extract(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if onPage != nil {
+			for _, page := range pages {
+				onPage(page)
+			}
+		}
+		return pages, nil
+	}
+}
+
+// extractImage runs an image through OCR as a single page
+func (s *Service) extractImage(ctx context.Context, filePath string, onPage func(Page)) ([]Page, error) {
+	text, err := s.ocrService.ExtractText(ctx, filePath)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to OCR image: %w", err)
+	}
+	page := Page{Number: 1, Text: text, OCR: true}
+	if onPage != nil {
+		onPage(page)
+	}
+	return []Page{page}, nil
+}
@@ -0,0 +1,408 @@
+package pdf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Journal entry types
+const (
+	entryJob    = "job"    // job created with its inputs
+	entryStatus = "status" // status snapshot
+	entryChunk  = "chunk"  // cards generated from one chunk of a file
+	entryFile   = "file"   // a file finished and its deck was saved
+)
+
+// journalEntry is a single line of the job journal
+type journalEntry struct {
+	Type  string    `json:"type"`
+	JobID string    `json:"jobId"`
+	Time  time.Time `json:"time"`
+
+	Job    *JobInput         `json:"job,omitempty"`
+	Status *ProcessingStatus `json:"status,omitempty"`
+
+	File        int `json:"file,omitempty"`
+	Chunk       int `json:"chunk,omitempty"`
+	TotalChunks int `json:"totalChunks,omitempty"`
+	// Hash is the chunk's Chunk.Hash
+	Hash  string `json:"hash,omitempty"`
+	Cards []Card `json:"cards,omitempty"`
+}
+
+// chunkResult holds the journaled cards of a chunk
+type chunkResult struct {
+	Hash  string
+	Cards []Card
+}
+
+// JobOptions are the settings chosen when a job is started
+type JobOptions struct {
+	IncludeTopicCards bool `json:"includeTopicCards"`
+	// MergeDecks writes the cards of all files into a single deck instead
+	// of one deck per file
+	MergeDecks bool `json:"mergeDecks,omitempty"`
+	// DeckName names the merged deck; it defaults to the first file's name
+	DeckName string `json:"deckName,omitempty"`
+	// ChunkConcurrency overrides the service's number of chunks sent to the
+	// LLM in parallel
+	ChunkConcurrency int `json:"chunkConcurrency,omitempty"`
+	// Template names the prompt template; empty selects the default
+	Template string `json:"template,omitempty"`
+	// Generation controls how many cards are created
+	Generation GenerationParams `json:"generation"`
+	// Dedup controls how duplicate cards are handled
+	Dedup DedupOptions `json:"dedup"`
+	// Verify checks answers against their source: "off" (default), "llm"
+	// or "lexical"
+	Verify string `json:"verify,omitempty"`
+	// Tags are added to every card next to the generated ones
+	Tags []string `json:"tags,omitempty"`
+	// SubdeckDepth is the number of outline levels of a PDF or EPUB turned
+	// into subdecks; 0 keeps all cards of a file in one deck
+	SubdeckDepth int `json:"subdeckDepth,omitempty"`
+}
+
+// JobInput holds everything needed to (re)start a job
+type JobInput struct {
+	Files []string `json:"files"`
+	JobOptions
+	Created time.Time `json:"created"`
+}
+
+// mergedDeckName returns the deck name used when MergeDecks is set
+func (in JobInput) mergedDeckName() string {
+	if in.DeckName != "" {
+		return filepath.Base(in.DeckName)
+	}
+	return deckNameFor(in.Files[0])
+}
+
+// jobRecord is the state of a job rebuilt from the journal
+type jobRecord struct {
+	ID     string
+	Input  JobInput
+	Status ProcessingStatus
+	// chunks holds the cards of completed chunks per file index
+	chunks map[int]map[int]chunkResult
+	// filesDone marks files whose deck was saved
+	filesDone map[int]bool
+}
+
+func (r *jobRecord) terminal() bool {
+	return IsTerminalStatus(r.Status.Status)
+}
+
+// IsTerminalStatus reports whether a job with the status has finished
+func IsTerminalStatus(status string) bool {
+	switch status {
+	case "completed", "partial", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// minCompactSize is the journal size below which it is never compacted
+// while jobs run
+const minCompactSize = 1 << 20
+
+// JobStore persists jobs as a JSON-lines journal so they survive restarts.
+// The journal is compacted once most of it is obsolete, which keeps the cost
+// of compaction proportional to the entries written since the last one.
+type JobStore struct {
+	path string
+	// retention is how long finished jobs are kept; 0 keeps them forever
+	retention time.Duration
+	mu        sync.Mutex
+	file      *os.File
+	// size is the journal's size and obsolete the size of the entries
+	// compaction drops: superseded statuses and the chunks of finished
+	// files and jobs
+	size     int64
+	obsolete int64
+	// statusSizes holds the size of each job's last status entry and
+	// chunkSizes the size of its chunk entries per file
+	statusSizes map[string]int64
+	chunkSizes  map[string]map[int]int64
+}
+
+// OpenJobStore opens (or creates) the job journal in dir and returns the
+// jobs recorded in it. Jobs that finished more than retention ago are
+// dropped; a retention of 0 keeps them.
+func OpenJobStore(dir string, retention time.Duration) (*JobStore, map[string]*jobRecord, error) {
+	path := filepath.Join(dir, "jobs.jsonl")
+
+	records, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store := &JobStore{path: path, retention: max(retention, 0)}
+	if err := store.compact(records); err != nil {
+		return nil, nil, err
+	}
+
+	return store, records, nil
+}
+
+func readJournal(path string) (map[string]*jobRecord, error) {
+	records := make(map[string]*jobRecord)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a torn last line; everything before it is valid
+			continue
+		}
+		applyEntry(records, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read job journal: %w", err)
+	}
+
+	return records, nil
+}
+
+func applyEntry(records map[string]*jobRecord, entry *journalEntry) {
+	record := records[entry.JobID]
+	if record == nil {
+		if entry.Type != entryJob {
+			return
+		}
+		record = &jobRecord{
+			ID:        entry.JobID,
+			chunks:    make(map[int]map[int]chunkResult),
+			filesDone: make(map[int]bool),
+		}
+		records[entry.JobID] = record
+	}
+
+	switch entry.Type {
+	case entryJob:
+		if entry.Job != nil {
+			record.Input = *entry.Job
+		}
+	case entryStatus:
+		if entry.Status != nil {
+			record.Status = *entry.Status
+			// Journals written before statuses had a finish time
+			if record.terminal() && record.Status.FinishedAt == nil {
+				finished := entry.Time
+				record.Status.FinishedAt = &finished
+			}
+		}
+	case entryChunk:
+		if record.chunks[entry.File] == nil {
+			record.chunks[entry.File] = make(map[int]chunkResult)
+		}
+		record.chunks[entry.File][entry.Chunk] = chunkResult{Hash: entry.Hash, Cards: entry.Cards}
+	case entryFile:
+		record.filesDone[entry.File] = true
+		// The deck holds the cards now, the chunk entries are obsolete
+		delete(record.chunks, entry.File)
+	}
+}
+
+// compact rewrites the journal with one status per job, drops chunk entries
+// that are no longer needed for resumption and forgets jobs past the
+// retention period
+func (s *JobStore) compact(records map[string]*jobRecord) error {
+	if s.retention > 0 {
+		cutoff := time.Now().Add(-s.retention)
+		for id, record := range records {
+			if record.terminal() && record.Status.FinishedAt != nil && record.Status.FinishedAt.Before(cutoff) {
+				delete(records, id)
+			}
+		}
+	}
+
+	tmpPath := s.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to compact job journal: %w", err)
+	}
+
+	ids := make([]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	s.size, s.obsolete = 0, 0
+	s.statusSizes = make(map[string]int64)
+	s.chunkSizes = make(map[string]map[int]int64)
+	writer := bufio.NewWriter(file)
+	for _, id := range ids {
+		record := records[id]
+		input := record.Input
+		status := record.Status
+		entries := []journalEntry{
+			{Type: entryJob, JobID: id, Job: &input},
+			{Type: entryStatus, JobID: id, Status: &status},
+		}
+		if !record.terminal() {
+			for fileIdx := range record.filesDone {
+				entries = append(entries, journalEntry{Type: entryFile, JobID: id, File: fileIdx})
+			}
+			for fileIdx, chunks := range record.chunks {
+				for chunkIdx, chunk := range chunks {
+					entries = append(entries, journalEntry{Type: entryChunk, JobID: id, File: fileIdx, Chunk: chunkIdx, Hash: chunk.Hash, Cards: chunk.Cards})
+				}
+			}
+		}
+		for _, entry := range entries {
+			data, err := encodeEntry(&entry)
+			if err == nil {
+				_, err = writer.Write(data)
+			}
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("failed to compact job journal: %w", err)
+			}
+			s.track(&entry, int64(len(data)))
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact job journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact job journal: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to compact job journal: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to compact job journal: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open job journal: %w", err)
+	}
+	return nil
+}
+
+// recompact compacts the journal while the service runs, dropping the
+// chunks of finished jobs
+func (s *JobStore) recompact() error {
+	records, err := readJournal(s.path)
+	if err != nil {
+		return err
+	}
+	return s.compact(records)
+}
+
+// encodeEntry stamps an entry with the current time and encodes it as a
+// journal line
+func encodeEntry(entry *journalEntry) ([]byte, error) {
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// track accounts for an entry of n bytes written to the journal and for the
+// entries it makes obsolete
+func (s *JobStore) track(entry *journalEntry, n int64) {
+	s.size += n
+	switch entry.Type {
+	case entryStatus:
+		s.obsolete += s.statusSizes[entry.JobID]
+		s.statusSizes[entry.JobID] = n
+		if IsTerminalStatus(entry.Status.Status) {
+			for _, size := range s.chunkSizes[entry.JobID] {
+				s.obsolete += size
+			}
+			delete(s.chunkSizes, entry.JobID)
+		}
+	case entryChunk:
+		if s.chunkSizes[entry.JobID] == nil {
+			s.chunkSizes[entry.JobID] = make(map[int]int64)
+		}
+		s.chunkSizes[entry.JobID][entry.File] += n
+	case entryFile:
+		s.obsolete += s.chunkSizes[entry.JobID][entry.File]
+		delete(s.chunkSizes[entry.JobID], entry.File)
+	}
+}
+
+// append writes an entry to the journal. Entries needed to resume a job are
+// synced to disk; progress statuses are not, since losing the last of them
+// in a crash only makes a resumed job report older progress.
+func (s *JobStore) append(entry journalEntry) error {
+	data, err := encodeEntry(&entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write job journal: %w", err)
+	}
+	if entry.Type != entryStatus || IsTerminalStatus(entry.Status.Status) {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync job journal: %w", err)
+		}
+	}
+	s.track(&entry, int64(len(data)))
+
+	if s.size >= minCompactSize && 2*s.obsolete >= s.size {
+		return s.recompact()
+	}
+	return nil
+}
+
+// RecordJob journals a newly created job
+func (s *JobStore) RecordJob(jobID string, input JobInput) error {
+	return s.append(journalEntry{Type: entryJob, JobID: jobID, Job: &input})
+}
+
+// RecordStatus journals a status snapshot
+func (s *JobStore) RecordStatus(jobID string, status ProcessingStatus) error {
+	return s.append(journalEntry{Type: entryStatus, JobID: jobID, Status: &status})
+}
+
+// RecordChunk journals the cards generated from one chunk of a file; hash
+// is the chunk's Chunk.Hash
+func (s *JobStore) RecordChunk(jobID string, file, chunk, totalChunks int, hash string, cards []Card) error {
+	return s.append(journalEntry{Type: entryChunk, JobID: jobID, File: file, Chunk: chunk, TotalChunks: totalChunks, Hash: hash, Cards: cards})
+}
+
+// RecordFileDone journals that a file's deck was saved
+func (s *JobStore) RecordFileDone(jobID string, file int) error {
+	return s.append(journalEntry{Type: entryFile, JobID: jobID, File: file})
+}
+
+// Close closes the journal file
+func (s *JobStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
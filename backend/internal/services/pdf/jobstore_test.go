@@ -0,0 +1,195 @@
+package pdf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobStoreCompactsFinishedJobs(t *testing.T) {
+	dir := t.TempDir()
+	store, _, err := OpenJobStore(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenJobStore: %v", err)
+	}
+	defer store.Close()
+
+	cards := []Card{{Question: "What is ATP?", Answer: "Energy currency"}}
+	for _, id := range []string{"done", "running"} {
+		if err := store.RecordJob(id, JobInput{Files: []string{"a.pdf"}}); err != nil {
+			t.Fatalf("RecordJob: %v", err)
+		}
+		if err := store.RecordStatus(id, ProcessingStatus{Status: "processing"}); err != nil {
+			t.Fatalf("RecordStatus: %v", err)
+		}
+		if err := store.RecordChunk(id, 0, 0, 2, "hash-"+id, cards); err != nil {
+			t.Fatalf("RecordChunk: %v", err)
+		}
+	}
+	if err := store.RecordStatus("done", ProcessingStatus{Status: "completed"}); err != nil {
+		t.Fatalf("RecordStatus: %v", err)
+	}
+
+	// A small journal is not compacted when a job finishes
+	data, err := os.ReadFile(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "hash-done") {
+		t.Errorf("journal was compacted when a job finished:\n%s", data)
+	}
+
+	if err := store.recompact(); err != nil {
+		t.Fatalf("recompact: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hash-done") {
+		t.Errorf("journal still holds the chunks of the finished job:\n%s", data)
+	}
+	if !strings.Contains(string(data), "hash-running") {
+		t.Errorf("journal lost the chunks of the running job:\n%s", data)
+	}
+
+	// Entries written after compaction go to the new journal
+	if err := store.RecordChunk("running", 0, 1, 2, "hash-second", cards); err != nil {
+		t.Fatalf("RecordChunk: %v", err)
+	}
+	records, err := readJournal(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatalf("readJournal: %v", err)
+	}
+	if status := records["done"].Status; status.Status != "completed" || status.FinishedAt == nil {
+		t.Errorf("finished job has status %+v", status)
+	}
+	chunks := records["running"].chunks[0]
+	if len(chunks) != 2 || chunks[0].Hash != "hash-running" || chunks[1].Hash != "hash-second" {
+		t.Errorf("running job chunks = %+v", chunks)
+	}
+}
+
+func TestJobStoreCompactsObsoleteEntries(t *testing.T) {
+	dir := t.TempDir()
+	store, _, err := OpenJobStore(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenJobStore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.RecordJob("job", JobInput{Files: []string{"a.pdf"}}); err != nil {
+		t.Fatalf("RecordJob: %v", err)
+	}
+	if err := store.RecordChunk("job", 0, 0, 2, "hash", []Card{{Question: "Q", Answer: "A"}}); err != nil {
+		t.Fatalf("RecordChunk: %v", err)
+	}
+	// Each status supersedes the previous one, so the journal is compacted
+	// once superseded statuses make up half of it
+	message := strings.Repeat("x", 64<<10)
+	var written int64
+	for i := 0; i < 64; i++ {
+		status := ProcessingStatus{Status: "processing", Progress: float64(i), Error: message}
+		if err := store.RecordStatus("job", status); err != nil {
+			t.Fatalf("RecordStatus: %v", err)
+		}
+		written += int64(len(message))
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*minCompactSize || info.Size() >= written {
+		t.Errorf("journal is %d bytes after writing %d bytes of statuses", info.Size(), written)
+	}
+	records, err := readJournal(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatalf("readJournal: %v", err)
+	}
+	record := records["job"]
+	if record.Status.Progress != 63 || len(record.chunks[0]) != 1 {
+		t.Errorf("compaction lost the job's state: progress %v, chunks %+v", record.Status.Progress, record.chunks)
+	}
+}
+
+func TestJobStoreDropsExpiredJobs(t *testing.T) {
+	dir := t.TempDir()
+	// A journal written before statuses had a finish time
+	legacy := `{"type":"job","jobId":"legacy","time":"2020-01-01T00:00:00Z","job":{"files":["a.pdf"]}}
+{"type":"status","jobId":"legacy","time":"2020-01-01T00:00:00Z","status":{"status":"completed"}}
+`
+	if err := os.WriteFile(filepath.Join(dir, "jobs.jsonl"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, records, err := OpenJobStore(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenJobStore: %v", err)
+	}
+	finished := records["legacy"].Status.FinishedAt
+	if finished == nil || finished.Year() != 2020 {
+		t.Errorf("legacy job finished at %v, want the time of its status entry", finished)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	jobs := map[string]ProcessingStatus{
+		"old":     {Status: "completed", FinishedAt: &old},
+		"recent":  {Status: "failed", FinishedAt: &recent},
+		"running": {Status: "processing"},
+	}
+	for id, status := range jobs {
+		if err := store.RecordJob(id, JobInput{Files: []string{"a.pdf"}}); err != nil {
+			t.Fatalf("RecordJob: %v", err)
+		}
+		if err := store.RecordStatus(id, status); err != nil {
+			t.Fatalf("RecordStatus: %v", err)
+		}
+	}
+	store.Close()
+
+	store, records, err = OpenJobStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenJobStore: %v", err)
+	}
+	defer store.Close()
+	for id, want := range map[string]bool{"legacy": false, "old": false, "recent": true, "running": true} {
+		if _, kept := records[id]; kept != want {
+			t.Errorf("job %s kept = %v, want %v", id, kept, want)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "jobs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"old"`) || strings.Contains(string(data), "legacy") {
+		t.Errorf("journal still holds expired jobs:\n%s", data)
+	}
+}
+
+func TestServiceForgetsExpiredJobs(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	s := &Service{
+		config: ProcessingConfig{JobRetention: time.Hour},
+		activeJobs: map[string]*ProcessingStatus{
+			"old":     {Status: "completed", FinishedAt: &old},
+			"recent":  {Status: "cancelled", FinishedAt: &recent},
+			"running": {Status: "processing"},
+		},
+	}
+	s.pruneJobs()
+	if _, ok := s.activeJobs["old"]; ok || len(s.activeJobs) != 2 {
+		t.Errorf("jobs after pruning = %v", s.activeJobs)
+	}
+
+	s.config.JobRetention = -1
+	s.activeJobs["old"] = &ProcessingStatus{Status: "completed", FinishedAt: &old}
+	s.pruneJobs()
+	if _, ok := s.activeJobs["old"]; !ok {
+		t.Errorf("job was pruned with retention disabled")
+	}
+}
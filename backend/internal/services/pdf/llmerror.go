@@ -0,0 +1,184 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrorKind classifies why an LLM request failed
+type ErrorKind string
+
+const (
+	ErrorRateLimit     ErrorKind = "rate_limit"     // too many requests, retry later
+	ErrorContextLength ErrorKind = "context_length" // prompt does not fit the model's context window
+	ErrorAuth          ErrorKind = "auth"           // bad key, missing permission or exhausted quota
+	ErrorTransient     ErrorKind = "transient"      // server or network failure
+	ErrorParse         ErrorKind = "parse"          // response contained no usable cards
+	ErrorPermanent     ErrorKind = "permanent"      // anything else that will not go away by retrying
+)
+
+// LLMError is a classified LLM failure
+type LLMError struct {
+	Kind       ErrorKind
+	StatusCode int
+	// RetryAfter is the delay the server asked for, if any
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *LLMError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether sending the same request again may succeed
+func (e *LLMError) Retryable() bool {
+	switch e.Kind {
+	case ErrorRateLimit, ErrorTransient, ErrorParse:
+		return true
+	}
+	return false
+}
+
+// classifyError turns any provider error into an LLMError
+func classifyError(err error) *LLMError {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return llmErr
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		code, _ := apiErr.Code.(string)
+		return classifyStatus(apiErr.HTTPStatusCode, code, apiErr.Message, err)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return classifyStatus(reqErr.HTTPStatusCode, "", string(reqErr.Body), err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return &LLMError{Kind: ErrorTransient, Err: err}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// A per-request timeout, the job context is checked by the caller
+		return &LLMError{Kind: ErrorTransient, Err: err}
+	}
+	return &LLMError{Kind: ErrorPermanent, Err: err}
+}
+
+func classifyStatus(status int, code, message string, err error) *LLMError {
+	kind := ErrorPermanent
+	message = strings.ToLower(message)
+	switch {
+	case code == "context_length_exceeded" || strings.Contains(message, "context length") ||
+		strings.Contains(message, "context window") || strings.Contains(message, "too many tokens") ||
+		status == http.StatusRequestEntityTooLarge:
+		kind = ErrorContextLength
+	case code == "insufficient_quota" || status == http.StatusUnauthorized || status == http.StatusForbidden:
+		kind = ErrorAuth
+	case status == http.StatusTooManyRequests:
+		kind = ErrorRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusConflict || status >= 500:
+		kind = ErrorTransient
+	case status == 0:
+		// No response at all, e.g. the connection dropped
+		kind = ErrorTransient
+	}
+	return &LLMError{Kind: kind, StatusCode: status, Err: err}
+}
+
+// backoff returns the delay before retry number attempt (starting at 0):
+// exponential growth from base capped at limit, with jitter so parallel
+// chunks do not retry in lockstep
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// retryAfterKey carries a retryAfterHint through a request context
+type retryAfterKey struct{}
+
+// retryAfterHint receives the Retry-After delay of a failed response
+type retryAfterHint struct {
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (h *retryAfterHint) get() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delay
+}
+
+func withRetryAfterHint(ctx context.Context) (context.Context, *retryAfterHint) {
+	hint := &retryAfterHint{}
+	return context.WithValue(ctx, retryAfterKey{}, hint), hint
+}
+
+// retryAfterDoer records the Retry-After header of rate limited and
+// unavailable responses, which the OpenAI client does not expose
+type retryAfterDoer struct {
+	next openai.HTTPDoer
+}
+
+func (d *retryAfterDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.next.Do(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+	if hint, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHint); ok {
+		if delay := parseRetryAfter(resp.Header); delay > 0 {
+			hint.mu.Lock()
+			hint.delay = delay
+			hint.mu.Unlock()
+		}
+	}
+	return resp, err
+}
+
+// parseRetryAfter reads Retry-After as seconds or an HTTP date, and the
+// millisecond variant some OpenAI-compatible servers send
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms := header.Get("Retry-After-Ms"); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil && n > 0 {
+			return time.Duration(n * float64(time.Millisecond))
+		}
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil && n > 0 {
+		return time.Duration(n * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestClassifyError(t *testing.T) {
+	apiError := func(status int, code any, message string) error {
+		return fmt.Errorf("chat completion: %w", &openai.APIError{HTTPStatusCode: status, Code: code, Message: message})
+	}
+	requestError := func(status int, body string) error {
+		return &openai.RequestError{HTTPStatusCode: status, Body: []byte(body), Err: errors.New("request failed")}
+	}
+	classified := &LLMError{Kind: ErrorParse, Err: errors.New("no cards")}
+
+	tests := []struct {
+		name   string
+		err    error
+		kind   ErrorKind
+		status int
+	}{
+		{"rate limit", apiError(429, "rate_limit_exceeded", "Rate limit reached"), ErrorRateLimit, 429},
+		{"exhausted quota", apiError(429, "insufficient_quota", "You exceeded your current quota"), ErrorAuth, 429},
+		{"invalid key", apiError(401, "invalid_api_key", "Incorrect API key provided"), ErrorAuth, 401},
+		{"missing permission", apiError(403, nil, "Forbidden"), ErrorAuth, 403},
+		{"context length code", apiError(400, "context_length_exceeded", "This model's maximum context length is 8192 tokens"), ErrorContextLength, 400},
+		{"context window message", apiError(400, nil, "Prompt exceeds the Context Window"), ErrorContextLength, 400},
+		{"too many tokens message", apiError(400, 1234, "too many tokens in the request"), ErrorContextLength, 400},
+		{"request too large", apiError(413, nil, "Payload too large"), ErrorContextLength, 413},
+		{"bad request", apiError(400, "invalid_request_error", "Unknown parameter"), ErrorPermanent, 400},
+		{"not found", apiError(404, "model_not_found", "The model does not exist"), ErrorPermanent, 404},
+		{"request timeout", apiError(408, nil, "Timeout"), ErrorTransient, 408},
+		{"conflict", apiError(409, nil, "Conflict"), ErrorTransient, 409},
+		{"server error", apiError(500, nil, "Internal error"), ErrorTransient, 500},
+		{"overloaded", apiError(529, nil, "Overloaded"), ErrorTransient, 529},
+		{"unparsed error body", requestError(502, "<html>Bad gateway</html>"), ErrorTransient, 502},
+		{"unparsed rate limit", requestError(429, "slow down"), ErrorRateLimit, 429},
+		{"unparsed context length", requestError(400, "context length exceeded"), ErrorContextLength, 400},
+		{"no response", requestError(0, ""), ErrorTransient, 0},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorTransient, 0},
+		{"connection dropped", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), ErrorTransient, 0},
+		{"per-request deadline", fmt.Errorf("request: %w", context.DeadlineExceeded), ErrorTransient, 0},
+		{"unknown error", errors.New("something else"), ErrorPermanent, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if got.Kind != tt.kind || got.StatusCode != tt.status {
+				t.Errorf("classifyError(%v) = %s with status %d, want %s with status %d", tt.err, got.Kind, got.StatusCode, tt.kind, tt.status)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("classified error does not wrap %v", tt.err)
+			}
+		})
+	}
+
+	// Errors that are already classified are kept
+	if got := classifyError(fmt.Errorf("chunk 2: %w", classified)); got != classified {
+		t.Errorf("classifyError reclassified %v as %v", classified, got)
+	}
+}
+
+func TestLLMErrorRetryable(t *testing.T) {
+	want := map[ErrorKind]bool{
+		ErrorRateLimit:     true,
+		ErrorTransient:     true,
+		ErrorParse:         true,
+		ErrorContextLength: false,
+		ErrorAuth:          false,
+		ErrorPermanent:     false,
+	}
+	for kind, retryable := range want {
+		if got := (&LLMError{Kind: kind}).Retryable(); got != retryable {
+			t.Errorf("%s retryable = %v, want %v", kind, got, retryable)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, limit := 100*time.Millisecond, time.Second
+	// Without jitter the delays would be 100ms, 200ms, 400ms, 800ms and then
+	// the limit; jitter picks from the upper half of each
+	ceilings := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for attempt, ceiling := range ceilings {
+		ceiling *= time.Millisecond
+		var lowest, highest time.Duration
+		for i := 0; i < 200; i++ {
+			delay := backoff(attempt, base, limit)
+			if delay < ceiling/2 || delay >= ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v)", attempt, delay, ceiling/2, ceiling)
+			}
+			if i == 0 || delay < lowest {
+				lowest = delay
+			}
+			highest = max(highest, delay)
+		}
+		if lowest == highest {
+			t.Errorf("backoff(%d) always returned %v, want jitter", attempt, lowest)
+		}
+	}
+
+	// Many attempts neither overflow nor pass the limit
+	if delay := backoff(1000, base, limit); delay <= 0 || delay > limit {
+		t.Errorf("backoff(1000) = %v, want at most %v", delay, limit)
+	}
+	if delay := backoff(0, 0, limit); delay != 0 {
+		t.Errorf("backoff without a base delay = %v, want 0", delay)
+	}
+}
+
+// failingProvider fails every request with err and counts the attempts
+type failingProvider struct {
+	err   error
+	calls int
+}
+
+func (p *failingProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	p.calls++
+	return "", p.err
+}
+
+func TestCompleteRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"transient errors are retried", &LLMError{Kind: ErrorTransient, Err: errors.New("502")}, 4},
+		{"rate limits are retried", &LLMError{Kind: ErrorRateLimit, Err: errors.New("429")}, 4},
+		{"auth errors are not retried", &LLMError{Kind: ErrorAuth, Err: errors.New("401")}, 1},
+		{"context length errors are not retried", &LLMError{Kind: ErrorContextLength, Err: errors.New("too long")}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &failingProvider{err: tt.err}
+			s := &Service{provider: provider, config: ProcessingConfig{
+				MaxRetries:     3,
+				RetryBaseDelay: time.Millisecond,
+				RetryMaxDelay:  2 * time.Millisecond,
+			}}
+			err := s.complete(context.Background(), CompletionRequest{}, func(string) error { return nil })
+			if !errors.Is(err, tt.err) {
+				t.Errorf("complete = %v, want %v", err, tt.err)
+			}
+			if provider.calls != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", provider.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"20"}}, 20 * time.Second},
+		{"fractional seconds", http.Header{"Retry-After": {"1.5"}}, 1500 * time.Millisecond},
+		{"milliseconds win", http.Header{"Retry-After": {"20"}, "Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		{"past date", http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, 0},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+		{"missing", http.Header{}, 0},
+	}
+	for _, tt := range tests {
+		got := parseRetryAfter(tt.header)
+		if tt.want == 0 && got > 0 || tt.want > 0 && got != tt.want {
+			t.Errorf("%s: parseRetryAfter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(http.Header{"Retry-After": {future}}); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%s) = %v, want about a minute", future, got)
+	}
+}
@@ -0,0 +1,224 @@
+package pdf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose content is not part of a document's text
+var hiddenElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Math: true, atom.Iframe: true,
+}
+
+// Elements that start a line of their own
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Figcaption: true,
+	atom.Figure: true, atom.Footer: true, atom.Header: true, atom.Hr: true,
+	atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Table: true, atom.Tr: true, atom.Ul: true,
+}
+
+var headingElements = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// htmlWriter renders HTML as the text the chunker understands: headings
+// become Markdown headings, block elements start new lines and list items
+// are bulleted
+type htmlWriter struct {
+	text strings.Builder
+	// lineStart is set while nothing was written on the current line
+	lineStart bool
+	space     bool
+	pre       int
+}
+
+// htmlText returns the text of an HTML document
+func htmlText(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	w := &htmlWriter{lineStart: true}
+	w.node(doc)
+	return strings.TrimSpace(w.text.String()), nil
+}
+
+func (w *htmlWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.write(n.Data)
+		return
+	case html.ElementNode:
+		if hiddenElements[n.DataAtom] {
+			return
+		}
+		switch {
+		case headingElements[n.DataAtom] > 0:
+			w.newline()
+			w.prefix(strings.Repeat("#", headingElements[n.DataAtom]) + " ")
+			w.children(n)
+			w.newline()
+			return
+		case n.DataAtom == atom.Br:
+			w.newline()
+			return
+		case n.DataAtom == atom.Td || n.DataAtom == atom.Th:
+			w.space = true
+		case n.DataAtom == atom.Img:
+			for _, attr := range n.Attr {
+				if attr.Key == "alt" {
+					w.write(attr.Val)
+				}
+			}
+		}
+	}
+
+	block := blockElements[n.DataAtom]
+	if block {
+		w.newline()
+	}
+	if n.DataAtom == atom.Li {
+		w.prefix("- ")
+	}
+	if n.DataAtom == atom.Pre {
+		w.pre++
+		defer func() { w.pre-- }()
+	}
+	w.children(n)
+	if block {
+		w.newline()
+	}
+}
+
+func (w *htmlWriter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.node(child)
+	}
+}
+
+// write adds text, collapsing whitespace outside of <pre>
+func (w *htmlWriter) write(text string) {
+	for _, r := range text {
+		switch {
+		case w.pre > 0 && r == '\n':
+			w.newline()
+		case unicode.IsSpace(r) && w.pre == 0:
+			w.space = true
+		default:
+			if w.space && !w.lineStart {
+				w.text.WriteByte(' ')
+			}
+			w.text.WriteRune(r)
+			w.space = false
+			w.lineStart = false
+		}
+	}
+}
+
+// prefix starts a line with a heading or list marker
+func (w *htmlWriter) prefix(marker string) {
+	w.text.WriteString(marker)
+	w.space = false
+}
+
+// newline ends the current line unless it is empty
+func (w *htmlWriter) newline() {
+	if !w.lineStart {
+		w.text.WriteByte('\n')
+	}
+	w.lineStart = true
+	w.space = false
+}
+
+// extractHTML reads an HTML file as a single page
+func extractHTML(filePath string) ([]Page, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	text, err := htmlText(file)
+	if err != nil {
+		return nil, err
+	}
+	return []Page{{Number: 1, Text: text}}, nil
+}
+
+// Markdown syntax that is not part of the text
+var (
+	markdownFrontMatter = regexp.MustCompile(`\A---\r?\n(?s:.*?)\r?\n---\r?\n`)
+	markdownImage       = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink        = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	markdownEmphasis    = []*regexp.Regexp{
+		regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`),
+		regexp.MustCompile(`__(\S(?:.*?\S)?)__`),
+		regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`),
+		regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`),
+		regexp.MustCompile("`([^`]+)`"),
+	}
+	markdownFence   = regexp.MustCompile("^\\s*(```|~~~)")
+	markdownSetext  = regexp.MustCompile(`^\s*(=+|-+)\s*$`)
+	markdownComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// extractMarkdown reads a Markdown file as a single page. Headings are kept
+// for the chunker, links, images and emphasis are reduced to their text.
+func extractMarkdown(filePath string) ([]Page, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(data), ""), "\r\n", "\n")
+	text = markdownFrontMatter.ReplaceAllString(text, "")
+	text = markdownComment.ReplaceAllString(text, "")
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if markdownFence.MatchString(line) {
+			continue
+		}
+		// Underlined headings become # headings
+		if match := markdownSetext.FindStringSubmatch(line); match != nil && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			level := "# "
+			if match[1][0] == '-' {
+				level = "## "
+			}
+			lines[len(lines)-1] = level + strings.TrimSpace(lines[len(lines)-1])
+			continue
+		}
+		line = markdownImage.ReplaceAllString(line, "$1")
+		line = markdownLink.ReplaceAllString(line, "$1")
+		for _, emphasis := range markdownEmphasis {
+			line = emphasis.ReplaceAllString(line, "$1")
+		}
+		lines = append(lines, line)
+	}
+	return []Page{{Number: 1, Text: strings.Join(lines, "\n")}}, nil
+}
+
+// extractPlainText reads a text file, splitting pages at form feeds as
+// written by tools such as pdftotext
+func extractPlainText(filePath string) ([]Page, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(data), ""), "\r\n", "\n")
+
+	var pages []Page
+	for i, pageText := range strings.Split(text, "\f") {
+		pages = append(pages, Page{Number: i + 1, Text: pageText})
+	}
+	return pages, nil
+}
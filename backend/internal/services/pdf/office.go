@@ -0,0 +1,351 @@
+package pdf
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxXMLPartSize limits the uncompressed size of a part read from an Office
+// document or EPUB
+const maxXMLPartSize = 64 << 20
+
+// XML namespaces of the Office document parts
+const (
+	wordNS          = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	drawingNS       = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	presentationNS  = "http://schemas.openxmlformats.org/presentationml/2006/main"
+	relationshipsNS = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+// openZipPart opens a file of a zip archive, limited to maxXMLPartSize
+func openZipPart(archive *zip.ReadCloser, name string) (io.ReadCloser, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, maxXMLPartSize), file}, nil
+}
+
+// readZipPart reads a file of a zip archive
+func readZipPart(archive *zip.ReadCloser, name string) ([]byte, error) {
+	part, err := openZipPart(archive, name)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// attr returns the value of an attribute by its local name
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// headingStyleName matches the names of Word's built-in heading styles,
+// which stay English in localized documents
+var headingStyleName = regexp.MustCompile(`(?i)^heading ([1-6])$`)
+
+// docxHeadingLevels maps the IDs of a document's heading styles to their
+// level, with the title style as level 1
+func docxHeadingLevels(archive *zip.ReadCloser) (map[string]int, error) {
+	levels := make(map[string]int)
+	part, err := openZipPart(archive, "word/styles.xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return levels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	var styleID string
+	decoder := xml.NewDecoder(part)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return levels, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse styles: %w", err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Space != wordNS {
+			continue
+		}
+		switch element.Name.Local {
+		case "style":
+			styleID = attr(element, "styleId")
+		case "name":
+			name := attr(element, "val")
+			if match := headingStyleName.FindStringSubmatch(name); match != nil {
+				levels[styleID], _ = strconv.Atoi(match[1])
+			} else if strings.EqualFold(name, "title") {
+				levels[styleID] = 1
+			}
+		}
+	}
+}
+
+// extractDOCX reads the paragraphs of a Word document. Headings are marked
+// as Markdown headings, list items are bulleted and pages end at manual
+// page breaks.
+func extractDOCX(filePath string) ([]Page, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DOCX: %w", err)
+	}
+	defer archive.Close()
+
+	levels, err := docxHeadingLevels(archive)
+	if err != nil {
+		return nil, err
+	}
+	part, err := openZipPart(archive, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	var (
+		pages     []Page
+		page      strings.Builder
+		paragraph strings.Builder
+		prefix    string
+		inText    bool
+	)
+	endPage := func() {
+		pages = append(pages, Page{Number: len(pages) + 1, Text: strings.TrimSpace(page.String())})
+		page.Reset()
+	}
+	endParagraph := func() {
+		if text := strings.TrimSpace(paragraph.String()); text != "" {
+			page.WriteString(prefix + text + "\n")
+		}
+		paragraph.Reset()
+		prefix = ""
+	}
+
+	decoder := xml.NewDecoder(part)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DOCX: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Space != wordNS {
+				continue
+			}
+			switch token.Name.Local {
+			case "pStyle":
+				if level := levels[attr(token, "val")]; level > 0 {
+					prefix = strings.Repeat("#", level) + " "
+				}
+			case "numPr":
+				if prefix == "" {
+					prefix = "- "
+				}
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString(" ")
+			case "br", "cr":
+				if attr(token, "type") == "page" {
+					endParagraph()
+					endPage()
+				} else {
+					paragraph.WriteString("\n")
+				}
+			}
+		case xml.EndElement:
+			switch {
+			case token.Name.Space != wordNS:
+			case token.Name.Local == "t":
+				inText = false
+			case token.Name.Local == "p":
+				endParagraph()
+			case token.Name.Local == "tc":
+				// Table cells are kept apart on the row's line
+				paragraph.WriteString(" ")
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(token)
+			}
+		}
+	}
+	endPage()
+	return pages, nil
+}
+
+// slideTitleTypes are the placeholders that hold a slide's title
+var slideTitleTypes = map[string]bool{"title": true, "ctrTitle": true}
+
+// extractPPTX reads the text of each slide of a presentation as a page, with
+// the slide title marked as a heading
+func extractPPTX(filePath string) ([]Page, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PPTX: %w", err)
+	}
+	defer archive.Close()
+
+	slides, err := pptxSlides(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]Page, 0, len(slides))
+	for i, slide := range slides {
+		text, err := pptxSlideText(archive, slide)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, Page{Number: i + 1, Text: text})
+	}
+	return pages, nil
+}
+
+// pptxSlides returns the parts of a presentation's slides in show order
+func pptxSlides(archive *zip.ReadCloser) ([]string, error) {
+	data, err := readZipPart(archive, "ppt/_rels/presentation.xml.rels")
+	if err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, fmt.Errorf("failed to parse presentation relationships: %w", err)
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := path.Join("ppt", rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			target = strings.TrimPrefix(rel.Target, "/")
+		}
+		targets[rel.ID] = target
+	}
+
+	data, err = readZipPart(archive, "ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	var presentation struct {
+		Slides []struct {
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := xml.Unmarshal(data, &presentation); err != nil {
+		return nil, fmt.Errorf("failed to parse presentation: %w", err)
+	}
+
+	var slides []string
+	for _, slide := range presentation.Slides {
+		for _, a := range slide.Attrs {
+			if a.Name.Space == relationshipsNS && a.Name.Local == "id" && targets[a.Value] != "" {
+				slides = append(slides, targets[a.Value])
+			}
+		}
+	}
+	if len(slides) == 0 {
+		// Fall back to the slide numbers of the part names
+		matches, _ := fs.Glob(archive, "ppt/slides/slide*.xml")
+		sort.Slice(matches, func(i, j int) bool {
+			return slideNumber(matches[i]) < slideNumber(matches[j])
+		})
+		slides = matches
+	}
+	return slides, nil
+}
+
+func slideNumber(name string) int {
+	number, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(name), "slide"), ".xml"))
+	return number
+}
+
+// pptxSlideText returns the paragraphs of a slide's shapes in document order
+func pptxSlideText(archive *zip.ReadCloser, name string) (string, error) {
+	part, err := openZipPart(archive, name)
+	if err != nil {
+		return "", err
+	}
+	defer part.Close()
+
+	var (
+		text      strings.Builder
+		paragraph strings.Builder
+		title     bool
+		inText    bool
+	)
+	decoder := xml.NewDecoder(part)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return strings.TrimSpace(text.String()), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch {
+			case token.Name.Space == presentationNS && token.Name.Local == "sp":
+				title = false
+			case token.Name.Space == presentationNS && token.Name.Local == "ph":
+				title = slideTitleTypes[attr(token, "type")]
+			case token.Name.Space == drawingNS && token.Name.Local == "t":
+				inText = true
+			case token.Name.Space == drawingNS && token.Name.Local == "br":
+				paragraph.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch {
+			case token.Name.Space != drawingNS:
+			case token.Name.Local == "t":
+				inText = false
+			case token.Name.Local == "p":
+				if line := strings.TrimSpace(paragraph.String()); line != "" {
+					if title {
+						line = "# " + line
+					}
+					text.WriteString(line + "\n")
+				}
+				paragraph.Reset()
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(token)
+			}
+		}
+	}
+}
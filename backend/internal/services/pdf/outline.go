@@ -0,0 +1,167 @@
+package pdf
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// maxSubdeckDepth limits how many outline levels become subdecks
+const maxSubdeckDepth = 4
+
+// maxTagRunes keeps tags made from long section titles readable
+const maxTagRunes = 40
+
+// validateSubdeckDepth checks the number of outline levels a job turns into
+// subdecks
+func validateSubdeckDepth(depth int) error {
+	if depth < 0 || depth > maxSubdeckDepth {
+		return fmt.Errorf("%w: subdeckDepth must be between 0 and %d", ErrInvalidGeneration, maxSubdeckDepth)
+	}
+	return nil
+}
+
+// outlineItem is a bookmark of a PDF's outline
+type outlineItem struct {
+	Title string
+	// Page is the first page of the bookmarked part, 0 if unknown
+	Page int
+	Kids []outlineItem
+}
+
+// outline is the top level of a PDF's bookmarks in document order
+type outline []outlineItem
+
+// readOutline returns the bookmarks of a PDF, or nil for a PDF without any
+func readOutline(filePath string) (outline, error) {
+	ctx, err := api.ReadContextFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	bookmarks, err := pdfcpu.Bookmarks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outline: %w", err)
+	}
+	return outlineItems(bookmarks), nil
+}
+
+func outlineItems(bookmarks []pdfcpu.Bookmark) outline {
+	var items outline
+	for _, bookmark := range bookmarks {
+		items = append(items, outlineItem{
+			Title: deckTitle(bookmark.Title),
+			Page:  bookmark.PageFrom,
+			Kids:  outlineItems(bookmark.Kids),
+		})
+	}
+	return items
+}
+
+// path returns the titles of the bookmarks, one per level up to depth,
+// whose parts contain page. A part runs from its bookmark's page to the page
+// before the next part on the same level, and ends with its parent at the
+// latest.
+func (o outline) path(page, depth int) []string {
+	var path []string
+	level, end := o, math.MaxInt
+	for len(path) < depth {
+		var match *outlineItem
+		matchEnd := 0
+		for i := range level {
+			item := &level[i]
+			if item.Page <= 0 || item.Page > page || item.Title == "" {
+				continue
+			}
+			// Of several parts starting on the same page the last is the
+			// innermost
+			if itemEnd := level.partEnd(i, end); page <= itemEnd && (match == nil || item.Page >= match.Page) {
+				match, matchEnd = item, itemEnd
+			}
+		}
+		if match == nil {
+			break
+		}
+		path = append(path, match.Title)
+		level, end = match.Kids, matchEnd
+	}
+	return path
+}
+
+// partEnd returns the last page of the part of the i-th bookmark of a level
+// whose parent part ends at end. Bookmarks need not be in page order.
+func (o outline) partEnd(i, end int) int {
+	for _, item := range o {
+		if item.Page > o[i].Page && item.Page-1 < end {
+			end = item.Page - 1
+		}
+	}
+	return end
+}
+
+// deckTitle turns a title into a deck name component. "::" separates
+// subdecks in Anki, so it may not appear inside a title.
+func deckTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	return strings.TrimSpace(strings.ReplaceAll(title, "::", ":"))
+}
+
+// cardPage returns the page a card is based on: its source page, or the
+// first page of its source's range
+func cardPage(card Card) int {
+	if card.SourcePage > 0 {
+		return card.SourcePage
+	}
+	first, _, _ := strings.Cut(card.Source.Pages, "-")
+	page, _ := strconv.Atoi(strings.TrimSpace(first))
+	return page
+}
+
+// headingPrefix matches the numbering and Markdown markers of a heading
+var headingPrefix = regexp.MustCompile(`^(?:#{1,6}\s+|(?:Chapter|Section|Part|Appendix|Kapitel|Abschnitt|Teil)\s+[0-9IVXLC]+[.:]?\s*|[0-9]+(?:\.[0-9]+){0,4}\.?\s+|[IVXLC]+\.\s+|[A-Z]\.\s+)`)
+
+// sectionTag turns a section heading into a tag, or returns "" for headings
+// too long to make a useful tag
+func sectionTag(section string) string {
+	title := strings.Trim(headingPrefix.ReplaceAllString(strings.TrimSpace(section), ""), " .:")
+	tag := strings.ToLower(strings.Join(strings.Fields(title), "_"))
+	if tag == "" || len([]rune(tag)) > maxTagRunes {
+		return ""
+	}
+	return tag
+}
+
+// organizeCards adds the section, topic and job tags to the cards of a file
+// and files them into subdecks following the outline of a PDF or EPUB
+func organizeCards(cards []Card, filePath string, input JobInput) {
+	var bookmarks outline
+	if input.SubdeckDepth > 0 {
+		var err error
+		if bookmarks, err = readInputOutline(filePath); err != nil {
+			log.Printf("Warning: Reading the outline of %s failed, keeping cards in one deck: %v", filePath, err)
+		}
+	}
+
+	for i := range cards {
+		tags := cards[i].Tags
+		if tag := sectionTag(cards[i].Source.Section); tag != "" {
+			tags = append(tags, tag)
+		}
+		cards[i].Tags = normalizeTags(append(tags, input.Tags...))
+
+		if input.SubdeckDepth == 0 {
+			continue
+		}
+		path := bookmarks.path(cardPage(cards[i]), input.SubdeckDepth)
+		if input.MergeDecks {
+			// Merged decks get a subdeck per file above the outline
+			path = append([]string{deckTitle(deckNameFor(filePath))}, path...)
+		}
+		cards[i].Subdeck = strings.Join(path, "::")
+	}
+}
@@ -0,0 +1,187 @@
+package pdf
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOutlinePath(t *testing.T) {
+	// Part I covers pages 1-10 and Part II the rest; the appendix bookmark
+	// is listed first although its part starts last
+	bookmarks := outline{
+		{Title: "Appendix", Page: 40},
+		{Title: "Part I", Page: 1, Kids: []outlineItem{
+			{Title: "Cells", Page: 2, Kids: []outlineItem{
+				{Title: "Membranes", Page: 3},
+				{Title: "Organelles", Page: 5},
+			}},
+			{Title: "Energy", Page: 7},
+			// Points past the end of its parent
+			{Title: "Misplaced", Page: 12},
+		}},
+		{Title: "Part II", Page: 11, Kids: []outlineItem{
+			{Title: "", Page: 11},
+			{Title: "Genetics", Page: 15},
+			{Title: "Unknown page", Page: 0},
+		}},
+		{Title: "Index", Page: 40, Kids: []outlineItem{{Title: "A-Z", Page: 41}}},
+	}
+	tests := []struct {
+		page  int
+		depth int
+		want  []string
+	}{
+		{1, 3, []string{"Part I"}},
+		{2, 3, []string{"Part I", "Cells"}},
+		{4, 3, []string{"Part I", "Cells", "Membranes"}},
+		{6, 3, []string{"Part I", "Cells", "Organelles"}},
+		{6, 2, []string{"Part I", "Cells"}},
+		{6, 1, []string{"Part I"}},
+		{10, 3, []string{"Part I", "Energy"}},
+		// Past the end of Part I, Energy and Misplaced do not apply
+		{12, 3, []string{"Part II"}},
+		{14, 3, []string{"Part II"}},
+		{20, 3, []string{"Part II", "Genetics"}},
+		{39, 3, []string{"Part II", "Genetics"}},
+		// Of two parts starting on the same page the later bookmark wins
+		{40, 3, []string{"Index"}},
+		{45, 3, []string{"Index", "A-Z"}},
+		{0, 3, nil},
+	}
+	for _, tt := range tests {
+		if got := bookmarks.path(tt.page, tt.depth); !slices.Equal(got, tt.want) {
+			t.Errorf("path(%d, %d) = %q, want %q", tt.page, tt.depth, got, tt.want)
+		}
+	}
+
+	if got := outline(nil).path(3, 2); got != nil {
+		t.Errorf("path without bookmarks = %q", got)
+	}
+}
+
+func TestSectionTag(t *testing.T) {
+	tests := []struct {
+		section string
+		want    string
+	}{
+		{"Cell Biology", "cell_biology"},
+		{"## Cell  Biology", "cell_biology"},
+		{"2.1 Convex Sets", "convex_sets"},
+		{"2.1. Convex Sets:", "convex_sets"},
+		{"Chapter 3: Cell Division", "cell_division"},
+		{"Kapitel IV Zellteilung", "zellteilung"},
+		{"IV. The Krebs Cycle", "the_krebs_cycle"},
+		{"B. Appendix", "appendix"},
+		{"3", "3"},
+		{"", ""},
+		{"A heading that is much too long to be a useful tag at all", ""},
+	}
+	for _, tt := range tests {
+		if got := sectionTag(tt.section); got != tt.want {
+			t.Errorf("sectionTag(%q) = %q, want %q", tt.section, got, tt.want)
+		}
+	}
+}
+
+// writeTestEPUB writes an EPUB whose chapters have the given titles
+func writeTestEPUB(t *testing.T, path string, titles ...string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+
+	files := map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+	}
+	manifest, spine := "", ""
+	for i, title := range titles {
+		id := string(rune('a' + i))
+		manifest += `<item id="` + id + `" href="` + id + `.xhtml" media-type="application/xhtml+xml"/>`
+		spine += `<itemref idref="` + id + `"/>`
+		files["OEBPS/"+id+".xhtml"] = `<html><body><h1>` + title + `</h1><p>Text of ` + title + `.</p></body></html>`
+	}
+	files["OEBPS/content.opf"] = `<package><manifest>` + manifest + `</manifest><spine>` + spine + `</spine></package>`
+
+	for name, content := range files {
+		part, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrganizeCards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "biology.epub")
+	writeTestEPUB(t, path, "Cells", "Energy :: ATP")
+
+	newCards := func() []Card {
+		return []Card{
+			{Question: "Q1", Tags: []string{"Cell Biology"}, SourcePage: 1, Source: Source{Section: "1.2 Membrane Transport"}},
+			{Question: "Q2", Source: Source{Pages: "2-3", Section: "A heading that is much too long to be a useful tag at all"}},
+			{Question: "Q3", Tags: []string{"biology"}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		input    JobInput
+		subdecks []string
+		tags     [][]string
+	}{
+		{
+			name:     "tags without subdecks",
+			input:    JobInput{JobOptions: JobOptions{Tags: []string{"exam 1", "biology"}}},
+			subdecks: []string{"", "", ""},
+			tags:     [][]string{{"Cell_Biology", "membrane_transport", "exam_1", "biology"}, {"exam_1", "biology"}, {"biology", "exam_1"}},
+		},
+		{
+			name:     "subdecks from the outline",
+			input:    JobInput{JobOptions: JobOptions{SubdeckDepth: 2}},
+			subdecks: []string{"Cells", "Energy : ATP", ""},
+			tags:     [][]string{{"Cell_Biology", "membrane_transport"}, nil, {"biology"}},
+		},
+		{
+			name:     "merged decks add the file",
+			input:    JobInput{JobOptions: JobOptions{SubdeckDepth: 1, MergeDecks: true}},
+			subdecks: []string{"biology::Cells", "biology::Energy : ATP", "biology"},
+			tags:     [][]string{{"Cell_Biology", "membrane_transport"}, nil, {"biology"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cards := newCards()
+			organizeCards(cards, path, tt.input)
+			for i, card := range cards {
+				if card.Subdeck != tt.subdecks[i] {
+					t.Errorf("card %s subdeck = %q, want %q", card.Question, card.Subdeck, tt.subdecks[i])
+				}
+				if !slices.Equal(card.Tags, tt.tags[i]) {
+					t.Errorf("card %s tags = %q, want %q", card.Question, card.Tags, tt.tags[i])
+				}
+			}
+		})
+	}
+
+	// A file without an outline keeps its cards in one deck
+	text := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(text, []byte("Cells are small."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cards := newCards()
+	organizeCards(cards, text, JobInput{JobOptions: JobOptions{SubdeckDepth: 2}})
+	for _, card := range cards {
+		if card.Subdeck != "" {
+			t.Errorf("card %s of a file without outline has subdeck %q", card.Question, card.Subdeck)
+		}
+	}
+}
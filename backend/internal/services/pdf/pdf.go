@@ -2,47 +2,130 @@ package pdf
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/sashabaranov/go-openai"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+	"github.com/jspohler/AnkiCards/backend/internal/services/ocr"
+	"github.com/jspohler/AnkiCards/backend/internal/services/prompts"
 )
 
 // Card represents a flashcard
 type Card struct {
-	Question string `json:"question"`
-	Answer   string `json:"answer"`
+	Question string   `json:"question"`
+	Answer   string   `json:"answer"`
+	Tags     []string `json:"tags,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	// Subdeck is the path of the card's subdeck below its deck, such as
+	// "Chapter 2::Cells", empty for cards in the deck itself
+	Subdeck string `json:"subdeck,omitempty"`
+	// NoteType decides in which directions a basic card is asked, see
+	// anki.NoteTypes
+	NoteType string `json:"noteType,omitempty"`
+	// Reverse is set when the model considers the card worth asking the
+	// other way round as well
+	Reverse bool `json:"reverse,omitempty"`
+	// SourcePage is the page the card is based on, 0 if unknown
+	SourcePage int `json:"sourcePage,omitempty"`
+	// Source records the file, pages, section and supporting excerpt
+	Source Source `json:"source"`
+	// Duplicate is set on cards flagged as duplicates of another card
+	Duplicate *Duplicate `json:"duplicate,omitempty"`
+	// Verification is set when the job checks answers against the source
+	Verification *Verification `json:"verification,omitempty"`
 }
 
+// Errors returned by CancelJob
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// ErrClosed is returned for jobs started after Close
+var ErrClosed = errors.New("processing service is shutting down")
+
 type ProcessingStatus struct {
-	Status     string  `json:"status"` // "pending", "processing", "completed", "failed"
+	Status     string  `json:"status"` // "pending", "processing", "completed", "partial", "failed", "cancelled"
 	Progress   float64 `json:"progress"`
 	Error      string  `json:"error,omitempty"`
 	TotalCards int     `json:"totalCards"`
 	DeckName   string  `json:"deckName,omitempty"`
 	Filename   string  `json:"filename,omitempty"`
+	// QueuePosition is the job's place in the queue while it waits for a
+	// worker, starting at 1
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// Generation holds the card count settings the job runs with
+	Generation *GenerationParams `json:"generation,omitempty"`
+	// Decks lists every deck the job wrote
+	Decks []string `json:"decks,omitempty"`
+	// Files holds the result of each input file
+	Files []FileResult `json:"files,omitempty"`
+	// OCRPages lists, per file, the pages that had no usable text layer
+	OCRPages map[string][]int `json:"ocrPages,omitempty"`
+	// FinishedAt is set once the job reaches a terminal status
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// FileResult is the outcome of processing a single file of a job
+type FileResult struct {
+	File      string `json:"file"`
+	Status    string `json:"status"` // "pending", "processing", "completed", "partial", "failed", "cancelled"
+	Error     string `json:"error,omitempty"`
+	CardCount int    `json:"cardCount"`
+	// PlannedCards is the number of cards requested from the LLM
+	PlannedCards int `json:"plannedCards,omitempty"`
+	// Duplicates is the number of cards found to duplicate another card
+	Duplicates int        `json:"duplicates,omitempty"`
+	DeckName   string     `json:"deckName,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	DurationMs int64      `json:"durationMs,omitempty"`
 }
 
 // Service handles PDF-related operations
 type Service struct {
 	uploadDir     string
 	cardsDir      string
-	openAIClient  *openai.Client
+	decks         anki.Repository
+	provider      LLMProvider
 	activeJobs    map[string]*ProcessingStatus
 	jobsMutex     sync.RWMutex
+	jobStore      *JobStore
+	interrupted   []*jobRecord
+	cancels       map[string]context.CancelFunc
+	eventsMutex   sync.Mutex
+	subscribers   map[string]map[chan JobEvent]struct{}
+	eventHistory  map[string][]JobEvent
 	cardsPerTopic int
+	ocrService    *ocr.Service
+	prompts       *prompts.Service
+	pdftoppmPath  string
+	config        ProcessingConfig
+	chunker       *chunker
+	// embedder is nil when the provider cannot compute embeddings
+	embedder   Embedder
+	embeddings embeddingCache
+	queue      []queuedJob
+	queueMutex sync.Mutex
+	queueCond  *sync.Cond
+	// closed stops the workers once their current job is done
+	closed  bool
+	workers sync.WaitGroup
 }
 
-// NewService creates a new PDF service
-func NewService(uploadDir, cardsDir string, openAIKey string, cardsPerTopic int) (*Service, error) {
+// NewService creates a new PDF service. Generated decks are saved to decks;
+// cardsDir keeps the job journal and debug output.
+func NewService(uploadDir, cardsDir string, decks anki.Repository, provider LLMProvider, cardsPerTopic int, ocrService *ocr.Service, promptService *prompts.Service, config ProcessingConfig) (*Service, error) {
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
@@ -50,13 +133,70 @@ func NewService(uploadDir, cardsDir string, openAIKey string, cardsPerTopic int)
 		return nil, fmt.Errorf("failed to create cards directory: %w", err)
 	}
 
-	return &Service{
+	config = config.withDefaults()
+
+	jobStore, records, err := OpenJobStore(cardsDir, config.JobRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look up the model and embeddings before the provider is wrapped by
+	// the rate limiter
+	model := ""
+	if namer, ok := provider.(ModelNamer); ok {
+		model = namer.Model()
+	}
+	embedder, _ := provider.(Embedder)
+
+	if config.RequestsPerMinute > 0 {
+		limiter := NewTokenBucket(config.RequestsPerMinute, config.Burst)
+		provider = &rateLimitedProvider{LLMProvider: provider, limiter: limiter}
+		if embedder != nil {
+			embedder = &rateLimitedEmbedder{Embedder: embedder, limiter: limiter}
+		}
+	}
+
+	s := &Service{
 		uploadDir:     uploadDir,
 		cardsDir:      cardsDir,
-		openAIClient:  openai.NewClient(openAIKey),
+		decks:         decks,
+		provider:      provider,
 		activeJobs:    make(map[string]*ProcessingStatus),
+		jobStore:      jobStore,
+		cancels:       make(map[string]context.CancelFunc),
+		subscribers:   make(map[string]map[chan JobEvent]struct{}),
+		eventHistory:  make(map[string][]JobEvent),
 		cardsPerTopic: cardsPerTopic,
-	}, nil
+		ocrService:    ocrService,
+		prompts:       promptService,
+		pdftoppmPath:  "pdftoppm",
+		config:        config,
+		chunker: &chunker{
+			maxTokens:     config.ChunkTokens,
+			overlapTokens: config.ChunkOverlapTokens,
+			tokens:        newApproxTokenCounter(model),
+		},
+		embedder: embedder,
+	}
+	s.queueCond = sync.NewCond(&s.queueMutex)
+
+	// Jobs from previous runs stay queryable; unfinished ones are resumed
+	// by ResumeJobs
+	for id, record := range records {
+		status := record.Status
+		s.activeJobs[id] = &status
+		if !record.terminal() {
+			s.interrupted = append(s.interrupted, record)
+		}
+	}
+	sortRecordsByCreation(s.interrupted)
+
+	s.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go s.worker()
+	}
+
+	return s, nil
 }
 
 // GetUploadDir returns the upload directory path
@@ -64,7 +204,7 @@ func (s *Service) GetUploadDir() string {
 	return s.uploadDir
 }
 
-// SaveUploadedFile saves an uploaded PDF file to disk
+// SaveUploadedFile saves an uploaded file to disk
 func (s *Service) SaveUploadedFile(fileData []byte, filename string) (string, error) {
 	// Generate a unique filename with absolute path
 	absUploadDir, err := filepath.Abs(s.uploadDir)
@@ -87,453 +227,1067 @@ func (s *Service) SaveUploadedFile(fileData []byte, filename string) (string, er
 	return filename, nil
 }
 
-// ExtractText extracts text from a PDF file
-func (s *Service) ExtractText(filePath string) (string, error) {
-	// Ensure we have absolute path
-	absPath, err := filepath.Abs(filePath)
+// Page is the extracted text of a single PDF page
+type Page struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+	OCR    bool   `json:"ocr"`
+}
+
+// ExtractText extracts the text of an uploaded file
+func (s *Service) ExtractText(ctx context.Context, filePath string) (string, error) {
+	pages, err := s.ExtractPages(ctx, filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %w", err)
+		return "", err
 	}
+	return joinPages(pages), nil
+}
+
+// ExtractPages extracts the text of every page of an uploaded file with the
+// extractor of its sniffed format. Slides, EPUB chapters and the parts of a
+// document between page breaks count as pages.
+func (s *Service) ExtractPages(ctx context.Context, filePath string) ([]Page, error) {
+	return s.extractPages(ctx, filePath, nil)
+}
 
-	// Get the project root directory
-	projectRoot := filepath.Join(filepath.Dir(absPath), "..", "..")
-
-	// Create a Python script for text extraction
-	extractScript := `
-import sys
-from pdf2image import convert_from_path
-import pytesseract
-
-def extract_text_from_pdf(pdf_path):
-    # Convert PDF to images
-    images = convert_from_path(pdf_path)
-    
-    # Extract text from each image
-    text = ""
-    for image in images:
-        text += pytesseract.image_to_string(image) + "\n"
-    
-    return text
-
-if __name__ == '__main__':
-    if len(sys.argv) != 2:
-        print("Usage: script.py <pdf_path>")
-        sys.exit(1)
-    
-    pdf_path = sys.argv[1]
-    try:
-        text = extract_text_from_pdf(pdf_path)
-        print(text)
-    except Exception as e:
-        print(f"Error: {str(e)}", file=sys.stderr)
-        sys.exit(1)
-`
-	// Save the script
-	scriptPath := filepath.Join(projectRoot, "backend", "internal", "services", "pdf", "extract_text.py")
-	if err := os.WriteFile(scriptPath, []byte(extractScript), 0755); err != nil {
-		return "", fmt.Errorf("failed to create extraction script: %w", err)
-	}
-
-	// Get the path to the virtual environment's Python interpreter
-	venvPython := filepath.Join(projectRoot, "venv", "bin", "python3")
-	if _, err := os.Stat(venvPython); err != nil {
-		return "", fmt.Errorf("virtual environment Python not found at %s: %w", venvPython, err)
-	}
-
-	// Run the Python script
-	cmd := exec.Command(venvPython, scriptPath, absPath)
-	output, err := cmd.CombinedOutput()
+// extractPDF extracts the pages of a PDF, reading the embedded text layer
+// first and running OCR only on pages without usable text
+func (s *Service) extractPDF(ctx context.Context, absPath string, onPage func(Page)) ([]Page, error) {
+	layer, err := extractTextLayer(absPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to extract text: %w\nOutput: %s", err, string(output))
+		// Damaged or encrypted files can often still be rasterized
+		log.Printf("Warning: Failed to read text layer of %s, falling back to OCR: %v", absPath, err)
+		return s.ocrDocument(ctx, absPath, onPage)
 	}
 
-	return string(output), nil
+	pages := make([]Page, len(layer))
+	for i, text := range layer {
+		pages[i] = Page{Number: i + 1, Text: text}
+		if !hasUsableText(text) {
+			ocrText, err := s.ocrPage(ctx, absPath, i+1)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				log.Printf("Warning: OCR failed for page %d of %s: %v", i+1, absPath, err)
+			} else {
+				pages[i].Text = ocrText
+				pages[i].OCR = true
+			}
+		}
+		if onPage != nil {
+			onPage(pages[i])
+		}
+	}
+
+	return pages, nil
 }
 
-func (s *Service) StartProcessing(filePaths []string, includeTopicCards bool) (string, error) {
+// ocrPage rasterizes a single page and runs it through the OCR service
+func (s *Service) ocrPage(ctx context.Context, pdfPath string, pageNr int) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "ocr-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	prefix := filepath.Join(tmpDir, "page")
+	page := strconv.Itoa(pageNr)
+	cmd := exec.CommandContext(ctx, s.pdftoppmPath, "-f", page, "-l", page, "-r", "300", "-png", "-singlefile", pdfPath, prefix)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to render page: %w\nOutput: %s", err, string(output))
+	}
+
+	return s.ocrService.ExtractText(ctx, prefix+".png")
+}
+
+// ocrDocument rasterizes and OCRs every page of a PDF
+func (s *Service) ocrDocument(ctx context.Context, pdfPath string, onPage func(Page)) ([]Page, error) {
+	tmpDir, err := os.MkdirTemp("", "ocr-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.CommandContext(ctx, s.pdftoppmPath, "-r", "300", "-png", pdfPath, filepath.Join(tmpDir, "page"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to render PDF: %w\nOutput: %s", err, string(output))
+	}
+
+	// pdftoppm zero-pads page numbers, so lexical order is page order
+	images, err := filepath.Glob(filepath.Join(tmpDir, "page-*.png"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rendered pages: %w", err)
+	}
+	sort.Strings(images)
+
+	pages := make([]Page, 0, len(images))
+	for i, image := range images {
+		text, err := s.ocrService.ExtractText(ctx, image)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Warning: OCR failed for page %d of %s: %v", i+1, pdfPath, err)
+		}
+		page := Page{Number: i + 1, Text: text, OCR: true}
+		pages = append(pages, page)
+		if onPage != nil {
+			onPage(page)
+		}
+	}
+
+	return pages, nil
+}
+
+// joinPages concatenates page texts in reading order
+func joinPages(pages []Page) string {
+	texts := make([]string, len(pages))
+	for i, page := range pages {
+		texts[i] = page.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ocrPageNumbers lists the pages whose text came from OCR
+func ocrPageNumbers(pages []Page) []int {
+	var numbers []int
+	for _, page := range pages {
+		if page.OCR {
+			numbers = append(numbers, page.Number)
+		}
+	}
+	return numbers
+}
+
+func (s *Service) StartProcessing(filePaths []string, opts JobOptions) (string, error) {
+	if len(filePaths) == 0 {
+		return "", fmt.Errorf("no files to process")
+	}
+	if s.isClosed() {
+		return "", ErrClosed
+	}
+	if opts.Template != "" && !s.prompts.Exists(opts.Template) {
+		return "", fmt.Errorf("%w: %s", prompts.ErrNotFound, opts.Template)
+	}
+	if err := opts.Generation.Validate(); err != nil {
+		return "", err
+	}
+	if err := opts.Dedup.Validate(); err != nil {
+		return "", err
+	}
+	if err := validateVerify(opts.Verify); err != nil {
+		return "", err
+	}
+	if err := validateSubdeckDepth(opts.SubdeckDepth); err != nil {
+		return "", err
+	}
+	for _, filePath := range filePaths {
+		if _, err := detectFile(filePath); err != nil {
+			return "", err
+		}
+	}
+	opts.Generation = opts.Generation.withDefaults(s.cardsPerTopic)
+	opts.Tags = normalizeTags(opts.Tags)
+	opts.Dedup = opts.Dedup.withDefaults()
+
+	s.pruneJobs()
 	jobID := fmt.Sprintf("job_%d", time.Now().UnixNano())
 
-	status := &ProcessingStatus{
-		Status:   "pending",
-		Progress: 0,
+	input := JobInput{
+		Files:      filePaths,
+		JobOptions: opts,
+		Created:    time.Now(),
+	}
+	if err := s.jobStore.RecordJob(jobID, input); err != nil {
+		return "", err
 	}
 
 	s.jobsMutex.Lock()
-	s.activeJobs[jobID] = status
+	s.activeJobs[jobID] = &ProcessingStatus{}
 	s.jobsMutex.Unlock()
 
-	go s.processFilesInBackground(jobID, filePaths, includeTopicCards)
+	s.updateJob(jobID, func(status *ProcessingStatus) {
+		status.Status = "pending"
+		status.Progress = 0
+		status.Generation = &input.Generation
+	})
+
+	ctx := s.trackJob(jobID)
+	s.enqueue(queuedJob{ctx: ctx, id: jobID, input: input})
 
 	return jobID, nil
 }
 
+// trackJob creates the cancellable context a job runs under
+func (s *Service) trackJob(jobID string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.jobsMutex.Lock()
+	s.cancels[jobID] = cancel
+	s.jobsMutex.Unlock()
+	return ctx
+}
+
+// untrackJob releases the context of a job that stopped running
+func (s *Service) untrackJob(jobID string) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+	if cancel, ok := s.cancels[jobID]; ok {
+		cancel()
+		delete(s.cancels, jobID)
+	}
+}
+
+// CancelJob stops a running job. Cards of files and chunks that finished
+// before the cancellation are kept.
+func (s *Service) CancelJob(jobID string) error {
+	s.jobsMutex.Lock()
+	status, exists := s.activeJobs[jobID]
+	if !exists {
+		s.jobsMutex.Unlock()
+		return ErrJobNotFound
+	}
+	cancel, running := s.cancels[jobID]
+	if !running || IsTerminalStatus(status.Status) {
+		s.jobsMutex.Unlock()
+		return ErrJobFinished
+	}
+	cancel()
+	s.jobsMutex.Unlock()
+
+	// A job still waiting in the queue never reaches a worker, so it is
+	// finished here
+	if s.dequeue(jobID) {
+		s.untrackJob(jobID)
+		s.updateJob(jobID, func(status *ProcessingStatus) {
+			status.Status = "cancelled"
+			status.QueuePosition = 0
+		})
+		s.updateQueuePositions()
+	}
+	return nil
+}
+
+// ResumeJobs restarts jobs that were interrupted by a server shutdown.
+// Files whose deck was already saved are skipped and chunks that already
+// produced cards are not sent to the LLM again.
+func (s *Service) ResumeJobs() {
+	for _, record := range s.interrupted {
+		log.Printf("Resuming interrupted job %s (%d files)", record.ID, len(record.Input.Files))
+		ctx := s.trackJob(record.ID)
+		s.enqueue(queuedJob{ctx: ctx, id: record.ID, input: record.Input, resume: record})
+	}
+	s.interrupted = nil
+}
+
+// pruneJobs forgets jobs that finished longer than the retention period ago.
+// The journal drops them when it is compacted next.
+func (s *Service) pruneJobs() {
+	if s.config.JobRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.config.JobRetention)
+
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+	for id, status := range s.activeJobs {
+		if IsTerminalStatus(status.Status) && status.FinishedAt != nil && status.FinishedAt.Before(cutoff) {
+			delete(s.activeJobs, id)
+		}
+	}
+}
+
 func (s *Service) GetJobStatus(jobID string) *ProcessingStatus {
 	s.jobsMutex.RLock()
 	defer s.jobsMutex.RUnlock()
 
 	if status, exists := s.activeJobs[jobID]; exists {
-		return status
+		snapshot := status.clone()
+		return &snapshot
 	}
 	return nil
 }
 
-func (s *Service) processFilesInBackground(jobID string, filePaths []string, includeTopicCards bool) {
-	go func() {
-		s.jobsMutex.Lock()
-		s.activeJobs[jobID] = &ProcessingStatus{
-			Status:   "processing",
-			Progress: 0,
+// updateJob applies fn to a job's status and journals the result
+func (s *Service) updateJob(jobID string, fn func(status *ProcessingStatus)) {
+	s.jobsMutex.Lock()
+	status := s.activeJobs[jobID]
+	fn(status)
+	if IsTerminalStatus(status.Status) && status.FinishedAt == nil {
+		now := time.Now()
+		status.FinishedAt = &now
+	}
+	snapshot := status.clone()
+	s.jobsMutex.Unlock()
+
+	if err := s.jobStore.RecordStatus(jobID, snapshot); err != nil {
+		log.Printf("Warning: Failed to journal status of job %s: %v", jobID, err)
+	}
+	s.publish(JobEvent{Type: EventStatus, JobID: jobID, Status: &snapshot})
+}
+
+// fileRun tracks one file of a running job and reports its progress
+type fileRun struct {
+	s     *Service
+	jobID string
+	index int
+	name  string
+	// done holds the cards of chunks finished before a restart
+	done map[int]chunkResult
+}
+
+func (r *fileRun) emit(event JobEvent) {
+	if r == nil {
+		return
+	}
+	event.JobID = r.jobID
+	event.File = r.name
+	r.s.publish(event)
+}
+
+func (r *fileRun) doneChunks() map[int]chunkResult {
+	if r == nil {
+		return nil
+	}
+	return r.done
+}
+
+// planned records how many cards the chunks of the file will ask for
+func (r *fileRun) planned(counts []int) {
+	if r == nil {
+		return
+	}
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	r.s.updateFile(r.jobID, r.index, func(result *FileResult) {
+		result.PlannedCards = total
+	})
+}
+
+// chunkSent reports that a chunk is being sent to the LLM
+func (r *fileRun) chunkSent(chunk, total int) {
+	r.emit(JobEvent{Type: EventChunkSent, Chunk: chunk + 1, TotalChunks: total})
+}
+
+// chunkDone journals the cards of a finished chunk so a restart can resume
+// after it, and streams them to subscribers
+func (r *fileRun) chunkDone(chunk, total int, hash string, cards []Card) {
+	if r == nil {
+		return
+	}
+	if err := r.s.jobStore.RecordChunk(r.jobID, r.index, chunk, total, hash, cards); err != nil {
+		log.Printf("Warning: Failed to journal chunk %d of job %s: %v", chunk+1, r.jobID, err)
+	}
+	r.emit(JobEvent{Type: EventCardsParsed, Chunk: chunk + 1, TotalChunks: total, Cards: cards})
+}
+
+// chunkFailed reports a chunk that was given up on after all retries
+func (r *fileRun) chunkFailed(chunk, total int, err error) {
+	r.emit(JobEvent{Type: EventError, Chunk: chunk + 1, TotalChunks: total, Error: err.Error()})
+}
+
+// fail reports an error that stopped processing of the file
+func (r *fileRun) fail(err error) {
+	r.emit(JobEvent{Type: EventError, Error: err.Error()})
+}
+
+// clone returns a deep copy that is safe to use outside the jobs lock
+func (p *ProcessingStatus) clone() ProcessingStatus {
+	c := *p
+	c.Decks = append([]string(nil), p.Decks...)
+	c.Files = append([]FileResult(nil), p.Files...)
+	if p.Generation != nil {
+		generation := *p.Generation
+		c.Generation = &generation
+	}
+	if p.OCRPages != nil {
+		c.OCRPages = make(map[string][]int, len(p.OCRPages))
+		for file, pages := range p.OCRPages {
+			c.OCRPages[file] = append([]int(nil), pages...)
 		}
-		s.jobsMutex.Unlock()
+	}
+	return c
+}
 
-		var totalCards int
-		var lastError error
+// processJob runs a job to completion on the calling worker. resume holds
+// the journaled progress of a job interrupted by a restart.
+func (s *Service) processJob(ctx context.Context, jobID string, input JobInput, resume *jobRecord) {
+	defer s.untrackJob(jobID)
 
-		// Use the first file's name as the deck name
-		filename := filepath.Base(filePaths[0])
-		deckName := strings.TrimSuffix(filename, filepath.Ext(filename))
+	filePaths := input.Files
+
+	// A merged deck is only written once all files are processed, so a
+	// resumed merge job has to regenerate (from journaled chunks) every file
+	filesDone := make(map[int]bool)
+	if resume != nil && !input.MergeDecks {
+		filesDone = resume.filesDone
+	}
 
+	s.updateJob(jobID, func(status *ProcessingStatus) {
+		status.Status = "processing"
+		status.Error = ""
+		status.QueuePosition = 0
+		if len(status.Files) != len(filePaths) {
+			status.Files = make([]FileResult, len(filePaths))
+		}
 		for i, filePath := range filePaths {
-			// Extract text from PDF
-			text, err := s.ExtractText(filePath)
-			if err != nil {
-				lastError = err
-				continue
+			if !filesDone[i] {
+				status.Files[i] = FileResult{File: filepath.Base(filePath), Status: "pending"}
 			}
+		}
+	})
 
-			// Generate cards
-			cards, err := s.generateCards(text, includeTopicCards)
-			if err != nil {
-				lastError = err
-				continue
-			}
+	dedup := s.newDeduper(input)
 
-			// Save cards to CSV
-			if err := s.saveCardsToCSV(cards, filePath); err != nil {
-				lastError = err
+	var merged []Card
+	for i, filePath := range filePaths {
+		if ctx.Err() != nil {
+			break
+		}
+		if filesDone[i] {
+			continue
+		}
+
+		run := &fileRun{s: s, jobID: jobID, index: i, name: filepath.Base(filePath)}
+		if resume != nil {
+			run.done = resume.chunks[i]
+		}
+
+		started := time.Now()
+		s.updateFile(jobID, i, func(result *FileResult) {
+			result.Status = "processing"
+			result.StartedAt = &started
+		})
+
+		// Cards of the chunks that succeeded are kept when others failed
+		cards, err := s.processFile(ctx, run, filePath, input)
+		var chunkErr *ChunkError
+		partial := errors.As(err, &chunkErr) && len(cards) > 0
+		if err != nil && !partial && !(ctx.Err() != nil && len(cards) > 0) {
+			run.fail(err)
+			s.finishFile(ctx, jobID, i, err, 0, "")
+			continue
+		}
+
+		// After a cancellation these are the cards of the chunks that finished
+		if ctx.Err() != nil {
+			log.Printf("Job %s cancelled, keeping %d cards from %s", jobID, len(cards), run.name)
+		}
+
+		deckName := input.mergedDeckName()
+		if !input.MergeDecks {
+			deckName = deckNameFor(filePath)
+		}
+		cards, duplicates := dedup.run(ctx, cards, deckName)
+		if duplicates > 0 {
+			s.updateFile(jobID, i, func(result *FileResult) {
+				result.Duplicates = duplicates
+			})
+		}
+
+		if input.MergeDecks {
+			merged = append(merged, cards...)
+		} else {
+			if err := s.saveDeck(cards, deckName); err != nil {
+				run.fail(err)
+				s.finishFile(ctx, jobID, i, err, 0, "")
 				continue
 			}
+			// Files with failed chunks are finished too; only an
+			// interrupted file has chunks left for a resumed job
+			if ctx.Err() == nil {
+				if err := s.jobStore.RecordFileDone(jobID, i); err != nil {
+					log.Printf("Warning: Failed to journal file %d of job %s: %v", i+1, jobID, err)
+				}
+			}
+		}
 
-			totalCards += len(cards)
+		run.emit(JobEvent{Type: EventFileFinished, CardCount: len(cards)})
+		s.finishFile(ctx, jobID, i, err, len(cards), deckName)
+	}
+
+	var mergeErr error
+	if input.MergeDecks && len(merged) > 0 {
+		mergeErr = s.saveDeck(merged, input.mergedDeckName())
+	}
 
-			// Update progress
-			s.jobsMutex.Lock()
-			s.activeJobs[jobID].Progress = float64(i+1) / float64(len(filePaths)) * 100
-			s.activeJobs[jobID].TotalCards = totalCards
-			s.jobsMutex.Unlock()
+	// Update final status
+	s.updateJob(jobID, func(status *ProcessingStatus) {
+		status.Progress = 100
+		status.Decks = nil
+		status.DeckName = ""
+		status.Filename = ""
+		var succeeded, failed int
+		var errs []string
+		for _, result := range status.Files {
+			// Cancelled files may still have written the cards they had
+			if result.DeckName != "" && (result.Status == "completed" || result.CardCount > 0) {
+				if !containsString(status.Decks, result.DeckName) {
+					status.Decks = append(status.Decks, result.DeckName)
+				}
+				if status.DeckName == "" {
+					status.DeckName = result.DeckName
+					status.Filename = result.File
+				}
+			}
+			switch result.Status {
+			case "completed":
+				succeeded++
+			case "partial":
+				succeeded++
+				failed++
+				errs = append(errs, fmt.Sprintf("%s: %s", result.File, result.Error))
+			case "failed":
+				failed++
+				errs = append(errs, fmt.Sprintf("%s: %s", result.File, result.Error))
+			}
 		}
 
-		// Update final status
-		s.jobsMutex.Lock()
-		if lastError != nil {
-			s.activeJobs[jobID].Status = "failed"
-			s.activeJobs[jobID].Error = lastError.Error()
-		} else {
-			s.activeJobs[jobID].Status = "completed"
-			s.activeJobs[jobID].Progress = 100
-			s.activeJobs[jobID].DeckName = deckName
-			s.activeJobs[jobID].Filename = filename
+		if mergeErr != nil {
+			succeeded = 0
+			status.Decks = nil
+			status.DeckName = ""
+			errs = append(errs, mergeErr.Error())
 		}
-		s.jobsMutex.Unlock()
-	}()
+		status.Error = strings.Join(errs, "; ")
+
+		switch {
+		case ctx.Err() != nil:
+			status.Status = "cancelled"
+		case succeeded == 0:
+			status.Status = "failed"
+		case failed > 0:
+			status.Status = "partial"
+		default:
+			status.Status = "completed"
+		}
+	})
 }
 
-func (s *Service) generateCards(text string, includeTopicCards bool) ([]Card, error) {
-	// Save original text for debugging
-	debugOrigPath := filepath.Join(s.cardsDir, "original_text.txt")
-	if debugFile, err := os.Create(debugOrigPath); err == nil {
-		defer debugFile.Close()
-		fmt.Fprintf(debugFile, "=== Original Text ===\n%s\n", text)
+// processFile extracts the text of one file and generates its cards
+func (s *Service) processFile(ctx context.Context, run *fileRun, filePath string, input JobInput) ([]Card, error) {
+	// Extract the text with the extractor of the file's format
+	pages, err := s.extractPages(ctx, filePath, func(page Page) {
+		run.emit(JobEvent{Type: EventPageExtracted, Page: page.Number, OCR: page.OCR})
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Preprocess the text
-	text = preprocessText(text)
+	if ocrPages := ocrPageNumbers(pages); len(ocrPages) > 0 {
+		s.updateJob(run.jobID, func(status *ProcessingStatus) {
+			if status.OCRPages == nil {
+				status.OCRPages = make(map[string][]int)
+			}
+			status.OCRPages[run.name] = ocrPages
+		})
+	}
 
-	// Save preprocessed text for review
-	debugFilePath := filepath.Join(s.cardsDir, "preprocessed_chunks.txt")
-	debugFile, err := os.Create(debugFilePath)
-	if err != nil {
-		log.Printf("Warning: Failed to create debug file: %v", err)
-	} else {
-		defer debugFile.Close()
-		fmt.Fprintf(debugFile, "=== Original Text Length: %d ===\n\n", len(text))
-		fmt.Fprintf(debugFile, "=== Full Preprocessed Text ===\n%s\n\n", text)
+	// Generate cards
+	cards, err := s.generateCards(ctx, pages, input.JobOptions, run)
+	for i := range cards {
+		cards[i].Source.File = run.name
 	}
+	organizeCards(cards, filePath, input)
+	return cards, err
+}
 
-	// Split text into chunks of roughly 1000 words each
-	words := strings.Fields(text)
-	var chunks []string
-	chunkSize := 1000 // Approximately 1500 tokens
+// updateFile applies fn to the result of the file at index
+func (s *Service) updateFile(jobID string, index int, fn func(result *FileResult)) {
+	s.updateJob(jobID, func(status *ProcessingStatus) {
+		if index < len(status.Files) {
+			fn(&status.Files[index])
+		}
+	})
+}
 
-	for i := 0; i < len(words); i += chunkSize {
-		end := i + chunkSize
-		if end > len(words) {
-			end = len(words)
+// finishFile records the outcome of a file and the job's overall progress
+func (s *Service) finishFile(ctx context.Context, jobID string, index int, err error, cardCount int, deckName string) {
+	finished := time.Now()
+	s.updateJob(jobID, func(status *ProcessingStatus) {
+		if index >= len(status.Files) {
+			return
+		}
+		result := &status.Files[index]
+		result.FinishedAt = &finished
+		if result.StartedAt != nil {
+			result.DurationMs = finished.Sub(*result.StartedAt).Milliseconds()
+		}
+		result.CardCount = cardCount
+		result.DeckName = deckName
+
+		var chunkErr *ChunkError
+		switch {
+		case err != nil && ctx.Err() != nil:
+			result.Status = "cancelled"
+		case errors.As(err, &chunkErr):
+			// Some chunks failed, the cards of the others were saved
+			result.Status = "partial"
+			result.Error = err.Error()
+		case err != nil:
+			result.Status = "failed"
+			result.Error = err.Error()
+		case ctx.Err() != nil:
+			// Partial cards of an interrupted file were still saved
+			result.Status = "cancelled"
+		default:
+			result.Status = "completed"
 		}
-		chunk := strings.Join(words[i:end], " ")
-		chunks = append(chunks, chunk)
 
-		if debugFile != nil {
-			fmt.Fprintf(debugFile, "=== Chunk %d/%d (Length: %d) ===\n", (i/chunkSize)+1, (len(words)+chunkSize-1)/chunkSize, len(chunk))
-			fmt.Fprintf(debugFile, "%s\n\n", chunk)
+		status.TotalCards = 0
+		finishedFiles := 0
+		for _, r := range status.Files {
+			status.TotalCards += r.CardCount
+			if r.Status != "pending" && r.Status != "processing" {
+				finishedFiles++
+			}
 		}
-	}
+		status.Progress = float64(finishedFiles) / float64(len(status.Files)) * 100
+	})
+}
 
-	var allCards []Card
-	cardsPerChunk := s.cardsPerTopic / len(chunks)
-	if cardsPerChunk < 1 {
-		cardsPerChunk = 1
+// deckNameFor derives a deck name from an input file name
+func deckNameFor(filePath string) string {
+	filename := filepath.Base(filePath)
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
 
+// generateCards turns pages into cards chunk by chunk, reporting progress to
+// run. Up to the job's chunk concurrency chunks are sent to the LLM at once.
+// Chunks the run already finished before a restart are not sent again.
+//
+// When ctx is cancelled the cards of the finished chunks are returned
+// together with the context error.
+func (s *Service) generateCards(ctx context.Context, pages []Page, opts JobOptions, run *fileRun) ([]Card, error) {
+	// Split the pages into chunks along headings, paragraphs and sentences
+	chunks := s.chunker.split(pages)
+
+	chunkWords := make([]int, len(chunks))
 	for i, chunk := range chunks {
-		prompt := fmt.Sprintf(`Create %d high-quality Anki flashcards from this academic text about optimization. 
-
-Requirements for the flashcards:
-1. Focus ONLY on the actual content and concepts from the text
-2. Each card should teach a specific concept, definition, or relationship
-3. Questions should promote understanding and critical thinking
-5. Use clear, academic language appropriate for the subject matter
-6. Include relevant examples or applications when available
-7. Ensure each card is unique and not redundant
-8. Questions should be specific and unambiguous
-9. Answers should be comprehensive yet concise
-10. Cards should build upon each other for progressive learning
-
-This is part %d of %d from the document.
-
-Format each card exactly as:
-Q: [Question]
-A: [Answer]
-
-Text to process:
-%s`, cardsPerChunk, i+1, len(chunks), chunk)
-
-		resp, err := s.openAIClient.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model: openai.GPT3Dot5Turbo,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleSystem,
-						Content: "You are an expert in optimization and mathematics, creating precise and educational flashcards. Focus only on the academic content provided, not on meta-information or technical artifacts.",
-					},
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: prompt,
-					},
-				},
-				MaxTokens:   2000,
-				Temperature: 0.5, // Reduced for more consistent output
-			},
-		)
+		chunkWords[i] = chunk.Words
+	}
+	counts := planCardCounts(chunkWords, len(pages), opts.Generation)
+	run.planned(counts)
 
-		if err != nil {
-			return nil, fmt.Errorf("OpenAI API error (chunk %d/%d): %w", i+1, len(chunks), err)
+	concurrency := opts.ChunkConcurrency
+	if concurrency <= 0 {
+		concurrency = s.config.ChunkConcurrency
+	}
+	concurrency = min(concurrency, maxChunkConcurrency)
+
+	results := make([][]Card, len(chunks))
+	finished := make([]bool, len(chunks))
+	// Chunks whose text changed, such as after a change of the chunk size,
+	// are generated again
+	for i, done := range run.doneChunks() {
+		if i < len(chunks) && done.Hash == chunks[i].Hash() {
+			results[i] = done.Cards
+			finished[i] = true
 		}
+	}
 
-		cards, err := parseCardsFromResponse(resp.Choices[0].Message.Content)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse cards from chunk %d/%d: %w", i+1, len(chunks), err)
+	// Chunks that fail after all retries are skipped, unless the failure
+	// affects every chunk (such as a bad API key)
+	chunkCtx, cancelChunks := context.WithCancel(ctx)
+	defer cancelChunks()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		chunkErrs []error
+		abortErr  error
+	)
+	slots := make(chan struct{}, concurrency)
+
+dispatch:
+	for i, chunk := range chunks {
+		if finished[i] {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-chunkCtx.Done():
+			break dispatch
 		}
 
-		allCards = append(allCards, cards...)
+		wg.Add(1)
+		go func(i int, chunk Chunk) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
 
-		// Add a small delay between API calls to respect rate limits
-		time.Sleep(time.Second)
+			cards, err := s.generateChunkCards(chunkCtx, chunk, i, len(chunks), counts[i], opts, run)
+			if err != nil {
+				if chunkCtx.Err() != nil {
+					return
+				}
+				run.chunkFailed(i, len(chunks), err)
+				mu.Lock()
+				chunkErrs = append(chunkErrs, err)
+				if classifyError(err).Kind == ErrorAuth && abortErr == nil {
+					abortErr = err
+					cancelChunks()
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			results[i] = cards
+			finished[i] = true
+			mu.Unlock()
+			run.chunkDone(i, len(chunks), chunk.Hash(), cards)
+		}(i, chunk)
 	}
+	wg.Wait()
 
-	// If requested, generate additional topic cards from a summary
-	if includeTopicCards && len(allCards) > 0 {
-		summaryPrompt := fmt.Sprintf(`Create %d high-level conceptual flashcards that connect and synthesize the main themes and concepts from this document.
-
-Guidelines for creating summary flashcards:
-1. Focus on relationships between major concepts
-2. Emphasize fundamental principles and their applications
-3. Include cards that compare and contrast key ideas
-4. Create cards that test understanding of broader implications
-5. Avoid surface-level or trivial information
-6. Questions should promote critical thinking
-7. Answers should provide clear, comprehensive explanations
-
-Format each card exactly as:
-Q: [Question]
-A: [Answer]
-
-Topics covered in the document:
-%s`, s.cardsPerTopic, text[:min(500, len(text))])
-
-		resp, err := s.openAIClient.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model: openai.GPT3Dot5Turbo,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleSystem,
-						Content: "You are an expert educator specializing in creating high-level conceptual flashcards that promote deep understanding and connections between ideas.",
-					},
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: summaryPrompt,
-					},
-				},
-				MaxTokens:   2000,
-				Temperature: 0.7,
-			},
-		)
+	var allCards []Card
+	finishedChunks := 0
+	for i, cards := range results {
+		if finished[i] {
+			allCards = append(allCards, cards...)
+			finishedChunks++
+		}
+	}
+	if ctx.Err() != nil {
+		return allCards, ctx.Err()
+	}
+	if finishedChunks == 0 && len(chunkErrs) > 0 {
+		return nil, chunkErrs[0]
+	}
+	if abortErr != nil {
+		return allCards, &ChunkError{Failed: len(chunks) - finishedChunks, Total: len(chunks), Err: abortErr}
+	}
+	if len(chunkErrs) > 0 {
+		return allCards, &ChunkError{Failed: len(chunkErrs), Total: len(chunks), Err: chunkErrs[0]}
+	}
 
+	// If requested, generate additional topic cards from a summary
+	if opts.IncludeTopicCards && len(allCards) > 0 {
+		topicCards, err := s.generateTopicCards(ctx, topicExcerpt(chunks), opts)
 		if err != nil {
 			log.Printf("Warning: Failed to generate topic cards: %v", err)
 		} else {
-			topicCards, err := parseCardsFromResponse(resp.Choices[0].Message.Content)
-			if err == nil {
-				allCards = append(allCards, topicCards...)
-			}
+			allCards = append(allCards, topicCards...)
 		}
 	}
 
 	return allCards, nil
 }
 
-// Helper function to find minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+// maxSplitDepth limits how often a chunk that is too long for the model's
+// context window is halved
+const maxSplitDepth = 4
+
+// minSplitWords is the smallest piece a chunk is split into
+const minSplitWords = 50
+
+// ChunkError reports chunks of a file that failed after all retries. The
+// cards of the remaining chunks are still returned.
+type ChunkError struct {
+	Failed int
+	Total  int
+	// Err is the first failure
+	Err error
 }
 
-func (s *Service) checkAndRefineCards(cards []Card) ([]Card, error) {
-	// Convert cards to embeddings and check similarity
-	// Request new cards for similar ones
-	// This is a placeholder - implement the actual logic
-	return cards, nil
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("%d of %d chunks failed: %v", e.Failed, e.Total, e.Err)
 }
 
-func (s *Service) saveCardsToCSV(cards []Card, originalFile string) error {
-	baseFile := filepath.Base(originalFile)
-	csvPath := filepath.Join(s.cardsDir, strings.TrimSuffix(baseFile, ".pdf")+".csv")
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
 
-	file, err := os.Create(csvPath)
+// generateChunkCards sends a single chunk to the LLM and parses the cards
+func (s *Service) generateChunkCards(ctx context.Context, chunk Chunk, index, total, count int, opts JobOptions, run *fileRun) ([]Card, error) {
+	run.chunkSent(index, total)
+	cards, err := s.generatePieceCards(ctx, chunk, chunk.Text, index, total, count, opts, 0)
 	if err != nil {
-		return fmt.Errorf("failed to create CSV file: %w", err)
+		return nil, fmt.Errorf("LLM provider error (chunk %d/%d): %w", index+1, total, err)
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	// Write header
-	if err := writer.Write([]string{"Question", "Answer"}); err != nil {
-		return fmt.Errorf("failed to write CSV header: %w", err)
+	// Keep source pages within the chunk; a single-page chunk answers the
+	// question for every card
+	for i := range cards {
+		if cards[i].SourcePage < chunk.FirstPage || cards[i].SourcePage > chunk.LastPage {
+			cards[i].SourcePage = 0
+		}
+		if cards[i].SourcePage == 0 && chunk.FirstPage == chunk.LastPage {
+			cards[i].SourcePage = chunk.FirstPage
+		}
 	}
+	attachSources(cards, chunk)
 
-	// Write cards
-	for _, card := range cards {
-		if err := writer.Write([]string{card.Question, card.Answer}); err != nil {
-			return fmt.Errorf("failed to write card to CSV: %w", err)
+	if opts.Verify != "" && opts.Verify != VerifyOff {
+		if err := s.verifyCards(ctx, cards, chunk, opts.Verify); err != nil {
+			return nil, err
 		}
 	}
-
-	return nil
+	return cards, nil
 }
 
-func parseCardsFromResponse(response string) ([]Card, error) {
-	// Split the response into lines
-	lines := strings.Split(response, "\n")
-	var cards []Card
-	var currentCard Card
+// generatePieceCards generates cards from a chunk or a piece of it. A piece
+// that does not fit the model's context window is split in half and each
+// half is sent on its own.
+func (s *Service) generatePieceCards(ctx context.Context, chunk Chunk, text string, index, total, count int, opts JobOptions, depth int) ([]Card, error) {
+	prompt, err := s.prompts.RenderChunk(opts.Template, prompts.Data{
+		Count:   count,
+		Part:    index + 1,
+		Parts:   total,
+		Pages:   chunk.PageRange(),
+		Section: chunk.Section,
+		Text:    text,
+		Focus:   opts.Generation.focus(),
+		Format:  cardFormats[opts.Generation.cardMode()],
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	cards, err := s.completeCards(ctx, CompletionRequest{
+		System:      prompt.System,
+		Prompt:      prompt.User,
+		MaxTokens:   maxTokensFor(count),
+		Temperature: 0.5, // Reduced for more consistent output
+		Schema:      cardSchemas[opts.Generation.cardMode()],
+	}, opts.Generation)
+
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || llmErr.Kind != ErrorContextLength || depth >= maxSplitDepth {
+		return cards, err
+	}
+	words := strings.Fields(text)
+	if len(words) < 2*minSplitWords {
+		return nil, err
+	}
 
-		// Check if line starts with Q: or A:
-		if strings.HasPrefix(line, "Q:") {
-			// If we have a previous card, add it
-			if currentCard.Question != "" && currentCard.Answer != "" {
-				cards = append(cards, currentCard)
-				currentCard = Card{}
+	log.Printf("Chunk %d/%d exceeds the context window, splitting %d words in half", index+1, total, len(words))
+	half := len(words) / 2
+	first, err := s.generatePieceCards(ctx, chunk, strings.Join(words[:half], " "), index, total, (count+1)/2, opts, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	second, err := s.generatePieceCards(ctx, chunk, strings.Join(words[half:], " "), index, total, max(count/2, 1), opts, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// maxOutlineSections limits the section titles sent for topic cards
+const maxOutlineSections = 60
+
+// topicExcerpt summarizes a document for topic cards: the titles of its
+// sections when headings were detected, otherwise its beginning
+func topicExcerpt(chunks []Chunk) string {
+	var sections []string
+	for _, chunk := range chunks {
+		for _, heading := range chunk.Headings {
+			if !containsString(sections, heading) {
+				sections = append(sections, heading)
 			}
-			currentCard.Question = strings.TrimPrefix(line, "Q:")
-		} else if strings.HasPrefix(line, "A:") {
-			currentCard.Answer = strings.TrimPrefix(line, "A:")
 		}
 	}
-
-	// Add the last card if it exists
-	if currentCard.Question != "" && currentCard.Answer != "" {
-		cards = append(cards, currentCard)
+	if len(sections) > 1 {
+		sections = sections[:min(len(sections), maxOutlineSections)]
+		return strings.Join(sections, "\n")
 	}
 
-	if len(cards) == 0 {
-		return nil, fmt.Errorf("no valid cards found in response")
+	if len(chunks) == 0 {
+		return ""
 	}
-
-	return cards, nil
+	text := chunks[0].Text
+	return text[:min(500, len(text))]
 }
 
-// preprocessText cleans and filters the text before sending to OpenAI
-func preprocessText(text string) string {
-	// Split into lines for processing
-	lines := strings.Split(text, "\n")
-	var processedLines []string
-
-	// Common patterns to filter out - reduced to only clear technical artifacts
-	skipPatterns := []string{
-		`^\s*\d+\s*$`,         // Just numbers (like page numbers)
-		`^\s*$`,               // Empty lines
-		`\[\d+\s+\d+\s+\d+\]`, // Matrix values
-		`(?i)TJ|Tf|Tm`,        // PDF operators
-		`\d+\.\d+\s+\d+\.\d+\s+\d+\.\d+\s+\d+\.\d+\s+-?\d+\.\d+\s+-?\d+\.\d+\s+cm`, // Transform matrices
+// generateTopicCards creates document-level cards from an excerpt
+func (s *Service) generateTopicCards(ctx context.Context, excerpt string, opts JobOptions) ([]Card, error) {
+	count := opts.Generation.CardsPerTopic
+	prompt, err := s.prompts.RenderTopics(opts.Template, prompts.Data{
+		Count:  count,
+		Text:   excerpt,
+		Focus:  opts.Generation.focus(),
+		Format: cardFormats[opts.Generation.cardMode()],
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Compile regex patterns
-	patterns := make([]*regexp.Regexp, len(skipPatterns))
-	for i, pattern := range skipPatterns {
-		patterns[i] = regexp.MustCompile(pattern)
+	return s.completeCards(ctx, CompletionRequest{
+		System:      prompt.System,
+		Prompt:      prompt.User,
+		MaxTokens:   maxTokensFor(count),
+		Temperature: 0.7,
+		Schema:      cardSchemas[opts.Generation.cardMode()],
+	}, opts.Generation)
+}
+
+// completeCards sends a request and parses the cards in the response,
+// keeping those of the card types the job asks for
+func (s *Service) completeCards(ctx context.Context, req CompletionRequest, params GenerationParams) ([]Card, error) {
+	var cards []Card
+	err := s.complete(ctx, req, func(content string) error {
+		var err error
+		cards, err = parseCards(content, params.cardTypes())
+		return err
+	})
+	for i := range cards {
+		if cards[i].Type == CardTypeBasic && params.NoteType != "" {
+			cards[i].NoteType = params.NoteType
+		}
 	}
+	return cards, err
+}
 
-	for _, line := range lines {
-		// Skip lines matching any of the patterns
-		skip := false
-		for _, pattern := range patterns {
-			if pattern.MatchString(line) {
-				skip = true
-				break
+// complete sends a request and hands the response to parse. Rate limits,
+// transient failures and responses parse rejects are retried with jittered
+// exponential backoff, waiting as long as the server asks for via
+// Retry-After up to RetryMaxDelay.
+func (s *Service) complete(ctx context.Context, req CompletionRequest, parse func(content string) error) error {
+	for attempt := 0; ; attempt++ {
+		content, err := s.provider.Complete(ctx, req)
+		if err == nil {
+			parseErr := parse(content)
+			if parseErr == nil {
+				return nil
 			}
+			err = &LLMError{Kind: ErrorParse, Err: parseErr}
 		}
-		if skip {
-			continue
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		// Clean the line
-		line = strings.TrimSpace(line)
+		llmErr := classifyError(err)
+		if !llmErr.Retryable() || attempt >= s.config.MaxRetries {
+			return llmErr
+		}
 
-		// Skip if too short after cleaning
-		if len(line) < 5 { // Reduced minimum length to keep more content
-			continue
+		delay := llmErr.RetryAfter
+		if delay <= 0 {
+			delay = backoff(attempt, s.config.RetryBaseDelay, s.config.RetryMaxDelay)
 		}
+		// A server asking for hours would otherwise hold the worker
+		if delay > s.config.RetryMaxDelay {
+			delay = s.config.RetryMaxDelay
+		}
+		log.Printf("LLM request failed (%v), retrying in %v (%d/%d)", llmErr, delay.Round(time.Millisecond), attempt+1, s.config.MaxRetries)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
 
-		// Remove multiple spaces and normalize whitespace
-		line = regexp.MustCompile(`\s+`).ReplaceAllString(line, " ")
+// Helper function to find minimum of two integers
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
 
-		// Only remove clearly non-text characters
-		line = regexp.MustCompile(`[\x00-\x1F\x7F]`).ReplaceAllString(line, "")
+// saveDeck adds the cards of a job to the deck deckName. Cards get an ID
+// from their content, so cards generated again are recognized and the stored
+// ones are kept with their edits and review state.
+func (s *Service) saveDeck(cards []Card, deckName string) error {
+	deck, err := s.decks.GetDeck(deckName)
+	if errors.Is(err, anki.ErrDeckNotFound) {
+		deck, err = &anki.Deck{ID: deckName, Name: deckName}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load deck %s: %w", deckName, err)
+	}
 
-		if line != "" {
-			processedLines = append(processedLines, line)
+	stored := make(map[string]bool, len(deck.Cards))
+	for _, card := range deck.Cards {
+		stored[card.ID] = true
+	}
+	var added []anki.Card
+	counts := make(map[string]int)
+	for _, card := range cards {
+		source := card.Source
+		deckCard := anki.Card{
+			Question:     card.Question,
+			Answer:       card.Answer,
+			Type:         card.Type,
+			NoteType:     card.NoteType,
+			AddReverse:   card.Reverse,
+			Tags:         card.Tags,
+			Subdeck:      card.Subdeck,
+			Source:       &source,
+			Duplicate:    card.Duplicate,
+			Verification: card.Verification,
+		}
+		id := anki.ContentCardID(deckName, deckCard, 0)
+		deckCard.ID = anki.ContentCardID(deckName, deckCard, counts[id])
+		counts[id]++
+		if !stored[deckCard.ID] {
+			added = append(added, deckCard)
 		}
 	}
+	if err := anki.ValidateCards(added); err != nil {
+		return fmt.Errorf("invalid cards for deck %s: %w", deckName, err)
+	}
 
-	// Join the lines and normalize the text
-	processedText := strings.Join(processedLines, "\n")
-
-	// Remove any remaining technical artifacts or irrelevant content
-	processedText = regexp.MustCompile(`(?i)(guidelines?|flashcards?|cards?)\s+for\s+creating`).ReplaceAllString(processedText, "")
+	deck.Cards = append(deck.Cards, added...)
+	if err := s.decks.SaveDeck(deck); err != nil {
+		return fmt.Errorf("failed to save deck %s: %w", deckName, err)
+	}
+	return nil
+}
 
-	return processedText
+// Lines of a page that are not part of its text: page numbers and content
+// stream operators that leak into OCR or damaged text layers. Operators only
+// match as whole lines, so words containing "tm" or "tf" are kept.
+var skippedLines = []*regexp.Regexp{
+	regexp.MustCompile(`^\d+$`),
+	regexp.MustCompile(`^(?:/\S+\s+)?(?:-?[\d.]+\s+)+(?:Tf|Tm|Td|TD|Tj|TJ|cm)$`),
+	regexp.MustCompile(`^\[[^\]]*\]\s*(?:TJ)?$`),
 }
 
-// GenerateAPKG generates an Anki package file from a CSV file
+var (
+	controlChars = regexp.MustCompile(`[\x00-\x08\x0B-\x1F\x7F]`)
+	spaceRuns    = regexp.MustCompile(`\s+`)
+)
+
+// preprocessText normalizes the whitespace of a page's lines and drops the
+// artifacts in skippedLines. Runs of empty lines, which separate paragraphs
+// in OCR output, are kept as a single empty line.
+func preprocessText(text string) string {
+	var processedLines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(spaceRuns.ReplaceAllString(controlChars.ReplaceAllString(line, ""), " "))
+		if line == "" {
+			if n := len(processedLines); n > 0 && processedLines[n-1] != "" {
+				processedLines = append(processedLines, "")
+			}
+			continue
+		}
+		skip := false
+		for _, pattern := range skippedLines {
+			if pattern.MatchString(line) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			processedLines = append(processedLines, line)
+		}
+	}
+	return strings.TrimSuffix(strings.Join(processedLines, "\n"), "\n")
+}
@@ -0,0 +1,256 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki/srs"
+	"github.com/jspohler/AnkiCards/backend/internal/services/prompts"
+)
+
+// retryAfterProvider fails its first request with a rate limit asking for a
+// long wait
+type retryAfterProvider struct {
+	calls int
+}
+
+func (p *retryAfterProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	p.calls++
+	if p.calls == 1 {
+		return "", &LLMError{Kind: ErrorRateLimit, RetryAfter: time.Hour, Err: errors.New("slow down")}
+	}
+	return "ok", nil
+}
+
+func TestCompleteCapsRetryAfter(t *testing.T) {
+	provider := &retryAfterProvider{}
+	s := &Service{provider: provider, config: ProcessingConfig{
+		MaxRetries:     1,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  10 * time.Millisecond,
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.complete(ctx, CompletionRequest{}, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %d times, want 2", provider.calls)
+	}
+}
+
+// wordEmbedder embeds texts as hashed bags of words
+type wordEmbedder struct {
+	*FakeProvider
+	embedded int
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.embedded += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vectors[i][h.Sum32()%64]++
+		}
+	}
+	return vectors, nil
+}
+
+func TestProcessingEndToEnd(t *testing.T) {
+	t.Run("lexical", func(t *testing.T) {
+		testProcessing(t, NewFakeProvider())
+	})
+	t.Run("embeddings", func(t *testing.T) {
+		embedder := &wordEmbedder{FakeProvider: NewFakeProvider()}
+		testProcessing(t, embedder)
+		if embedder.embedded == 0 {
+			t.Errorf("no cards were embedded")
+		}
+	})
+}
+
+// testProcessing runs a job on a text file of two pages and checks the
+// saved deck
+func testProcessing(t *testing.T, provider LLMProvider) {
+	dir := t.TempDir()
+	repo, err := anki.NewFileRepository(filepath.Join(dir, "decks"))
+	if err != nil {
+		t.Fatalf("NewFileRepository: %v", err)
+	}
+	promptService, err := prompts.NewService(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatalf("prompts.NewService: %v", err)
+	}
+	s, err := NewService(filepath.Join(dir, "uploads"), filepath.Join(dir, "cards"), repo, provider, 3, nil, promptService, ProcessingConfig{
+		RequestsPerMinute: -1,
+		ChunkTokens:       minChunkTokens,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	// Both sections start with the same sentence, so both chunks produce
+	// the same first card
+	shared := "Mitochondria produce most of the ATP a cell needs."
+	section := func(heading string, sentences ...string) string {
+		return "# " + heading + "\n" + shared + "\n" + strings.Join(sentences, "\n") + "\n"
+	}
+	input := section("Cells",
+		"The nucleus stores genetic information as chromosomes.",
+		"Ribosomes translate messenger RNA into proteins.",
+		"The cell membrane is a lipid bilayer with embedded proteins.",
+		"Lysosomes digest worn out organelles using acidic enzymes.",
+		"The cytoskeleton gives animal cells their shape and lets them move.",
+		"Plant cells have a rigid wall made of cellulose fibres.",
+		"Vacuoles keep plant cells firm by holding water under pressure.",
+		"Golgi bodies sort and package proteins for secretion.",
+		"Chloroplasts capture light to build sugars from carbon dioxide.",
+		"Prokaryotes lack a nucleus and most membrane bound organelles.",
+	) + "\f" + section("Energy",
+		"Glycolysis splits glucose into two pyruvate molecules in the cytoplasm.",
+		"The citric acid cycle releases carbon dioxide inside the matrix.",
+		"An electron transport chain pumps protons across the inner membrane.",
+		"ATP synthase uses the proton gradient to phosphorylate ADP.",
+		"Fermentation regenerates NAD when oxygen is missing.",
+		"Muscles store quick energy as creatine phosphate.",
+		"Brown fat burns fuel to release heat instead of storing it.",
+		"Photosynthesis and respiration form a loop of carbon between organisms.",
+		"Enzymes lower the activation energy of reactions without being used up.",
+		"Starch and glycogen are branched polymers of glucose kept for later use.",
+	)
+	path, err := s.SaveUploadedFile([]byte(input), "biology.txt")
+	if err != nil {
+		t.Fatalf("SaveUploadedFile: %v", err)
+	}
+
+	jobID, err := s.StartProcessing([]string{path}, JobOptions{
+		Generation: GenerationParams{MinPerChunk: 2, MaxPerChunk: 2},
+		Dedup:      DedupOptions{Mode: DedupDrop},
+		Verify:     VerifyLLM,
+		Tags:       []string{"biology"},
+	})
+	if err != nil {
+		t.Fatalf("StartProcessing: %v", err)
+	}
+
+	var status *ProcessingStatus
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status = s.GetJobStatus(jobID)
+		if status != nil && IsTerminalStatus(status.Status) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != "completed" {
+		t.Fatalf("job status = %q: %s", status.Status, status.Error)
+	}
+	file := status.Files[0]
+	if file.Duplicates != 1 {
+		t.Errorf("file has %d duplicates, want 1", file.Duplicates)
+	}
+
+	deck, err := repo.GetDeck(status.DeckName)
+	if err != nil {
+		t.Fatalf("GetDeck(%q): %v", status.DeckName, err)
+	}
+	if deck.Name != "biology" || len(deck.Cards) != 3 || file.CardCount != 3 {
+		t.Fatalf("deck %q has %d cards (status reports %d), want biology with 3", deck.Name, len(deck.Cards), file.CardCount)
+	}
+
+	questions := make(map[string]bool)
+	pages := make(map[string]bool)
+	for _, card := range deck.Cards {
+		if questions[card.Question] {
+			t.Errorf("duplicate card was kept: %q", card.Question)
+		}
+		questions[card.Question] = true
+		if card.Verification == nil || !card.Verification.Grounded || card.Verification.Method != VerifyLLM {
+			t.Errorf("card %q verification = %+v", card.Question, card.Verification)
+		}
+		if card.Source == nil || card.Source.File != "biology.txt" {
+			t.Errorf("card %q source = %+v", card.Question, card.Source)
+		} else {
+			pages[card.Source.Pages] = true
+		}
+		if !containsString(card.Tags, "biology") {
+			t.Errorf("card %q tags = %q", card.Question, card.Tags)
+		}
+	}
+	if !pages["1"] || !pages["2"] {
+		t.Errorf("cards come from pages %v, want both pages", pages)
+	}
+}
+
+func TestSaveDeckKeepsStoredCards(t *testing.T) {
+	repo, err := anki.NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileRepository: %v", err)
+	}
+	s := &Service{decks: repo}
+
+	cards := []Card{
+		{Question: "What is ATP?", Answer: "Energy currency", Source: Source{File: "bio.pdf", Pages: "1"}},
+		{Question: "What is DNA?", Answer: "Genetic material", Source: Source{File: "bio.pdf", Pages: "2"}},
+	}
+	if err := s.saveDeck(cards, "biology"); err != nil {
+		t.Fatalf("saveDeck: %v", err)
+	}
+	deck, err := repo.GetDeck("biology")
+	if err != nil || len(deck.Cards) != 2 {
+		t.Fatalf("deck = %+v, %v", deck, err)
+	}
+	atp, dna := deck.Cards[0], deck.Cards[1]
+	if _, err := repo.UpdateCard("biology", anki.Card{ID: atp.ID, Question: atp.Question, Answer: "Edited", Source: atp.Source}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SaveReview("biology", dna.ID, srs.State{Phase: srs.PhaseReview, Interval: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same questions, worded with other whitespace and case, and a new one
+	again := []Card{
+		{Question: "what is  ATP?", Answer: "Energy currency"},
+		{Question: "What is DNA?", Answer: "Genetic material"},
+		{Question: "What is RNA?", Answer: "A copy of DNA"},
+	}
+	if err := s.saveDeck(again, "biology"); err != nil {
+		t.Fatalf("saveDeck: %v", err)
+	}
+	deck, err = repo.GetDeck("biology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deck.Cards) != 3 || deck.Cards[2].Question != "What is RNA?" {
+		t.Fatalf("deck cards = %+v, want the stored two and the new one", deck.Cards)
+	}
+	if deck.Cards[0].ID != atp.ID || deck.Cards[0].Answer != "Edited" {
+		t.Errorf("edited card = %+v", deck.Cards[0])
+	}
+	if deck.Cards[1].ID != dna.ID || deck.Cards[1].Review == nil || deck.Cards[1].Review.Interval != 4 {
+		t.Errorf("reviewed card = %+v", deck.Cards[1])
+	}
+
+	invalid := []Card{{Question: "What is a gene?", Answer: "A unit of heredity"}, {Question: "No deletion", Type: anki.CardTypeCloze}}
+	if err := s.saveDeck(invalid, "biology"); !errors.Is(err, anki.ErrInvalidCloze) {
+		t.Errorf("saveDeck with an invalid card = %v", err)
+	}
+	if deck, err := repo.GetDeck("biology"); err != nil || len(deck.Cards) != 3 {
+		t.Errorf("invalid cards were saved: %+v, %v", deck, err)
+	}
+}
@@ -0,0 +1,130 @@
+package pdf
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// Source records where in the input a card comes from. Its excerpt is the
+// passage of the chunk that best supports the answer.
+type Source = anki.Source
+
+// maxExcerptRunes keeps excerpts short enough to show below a card
+const maxExcerptRunes = 400
+
+var pageMarker = regexp.MustCompile(`^\[Page (\d+)\]\s*`)
+
+// stopWords are frequent words that say nothing about whether a sentence
+// supports a card
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "was": true, "were": true,
+	"with": true, "that": true, "this": true, "from": true, "which": true, "what": true,
+	"how": true, "why": true, "when": true, "does": true, "its": true, "into": true,
+	"can": true, "has": true, "have": true, "not": true, "but": true, "they": true,
+	"der": true, "die": true, "das": true, "und": true, "ist": true, "ein": true,
+	"eine": true, "mit": true, "von": true, "den": true, "wie": true, "auf": true,
+}
+
+// chunkSentence is a sentence of a chunk and the page it is on
+type chunkSentence struct {
+	text  string
+	page  int
+	words map[string]bool
+}
+
+// sentences splits the chunk's text into sentences, following its page
+// markers
+func (c Chunk) sentences() []chunkSentence {
+	var sentences []chunkSentence
+	page := c.FirstPage
+	for _, paragraph := range strings.Split(c.Text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if m := pageMarker.FindStringSubmatch(paragraph); m != nil {
+			page, _ = strconv.Atoi(m[1])
+			paragraph = paragraph[len(m[0]):]
+		}
+		for _, sentence := range splitSentences(strings.Join(strings.Fields(paragraph), " ")) {
+			sentences = append(sentences, chunkSentence{text: sentence, page: page, words: contentWords(sentence)})
+		}
+	}
+	return sentences
+}
+
+// attachSources records the source of cards generated from chunk. The
+// excerpt is the sentence sharing the most content words with the card,
+// followed by the next sentence when that supports the card as well.
+func attachSources(cards []Card, chunk Chunk) {
+	sentences := chunk.sentences()
+
+	for i := range cards {
+		card := &cards[i]
+		card.Source.Section = chunk.Section
+
+		answer := contentWords(card.Answer)
+		question := contentWords(card.Question)
+		score := func(s chunkSentence) int {
+			// Words of the answer are stronger evidence than the question's
+			return 2*overlap(answer, s.words) + overlap(question, s.words)
+		}
+
+		best, bestScore := -1, 0
+		for j, sentence := range sentences {
+			if sc := score(sentence); sc > bestScore {
+				best, bestScore = j, sc
+			}
+		}
+
+		if best >= 0 {
+			excerpt := sentences[best].text
+			if next := best + 1; next < len(sentences) && 2*score(sentences[next]) >= bestScore {
+				excerpt += " " + sentences[next].text
+			}
+			card.Source.Excerpt = truncateRunes(excerpt, maxExcerptRunes)
+
+			if card.SourcePage == 0 {
+				card.SourcePage = sentences[best].page
+			}
+		}
+
+		if card.SourcePage > 0 {
+			card.Source.Pages = strconv.Itoa(card.SourcePage)
+		} else {
+			card.Source.Pages = chunk.PageRange()
+		}
+	}
+}
+
+// contentWords returns the lowercased words of text that carry meaning
+func contentWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) }) {
+		if utf8.RuneCountInString(word) >= 3 && !stopWords[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// overlap counts the words two sets share
+func overlap(a, b map[string]bool) int {
+	n := 0
+	for word := range a {
+		if b[word] {
+			n++
+		}
+	}
+	return n
+}
+
+// truncateRunes shortens s to at most limit runes, ending with an ellipsis
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
@@ -0,0 +1,324 @@
+package pdf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// LLMProvider sends a single chat completion to a language model
+type LLMProvider interface {
+	Complete(ctx context.Context, req CompletionRequest) (string, error)
+}
+
+// ModelNamer is implemented by providers that know which model they use,
+// so text can be measured with the model's tokenizer
+type ModelNamer interface {
+	Model() string
+}
+
+// Embedder is implemented by providers that can compute text embeddings,
+// which find cards that are duplicates in meaning but not in wording
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ErrEmbeddingsUnavailable is returned by providers configured without an
+// embedding model
+var ErrEmbeddingsUnavailable = errors.New("embeddings are not available")
+
+// CompletionRequest is a provider-independent chat completion request
+type CompletionRequest struct {
+	System      string
+	Prompt      string
+	MaxTokens   int
+	Temperature float32
+	// Schema asks for JSON output matching a schema. Providers without
+	// structured output support ignore it, so callers still have to
+	// validate the response.
+	Schema *ResponseSchema
+}
+
+// ResponseSchema is a named JSON schema for structured output
+type ResponseSchema struct {
+	Name   string
+	Schema json.RawMessage
+}
+
+// ProviderConfig selects and configures the LLM provider
+type ProviderConfig struct {
+	// Provider is "openai", "openai-compatible" or "fake"
+	Provider string
+	APIKey   string
+	// BaseURL points at an OpenAI-compatible server such as Ollama,
+	// llama.cpp server or vLLM, e.g. http://localhost:11434/v1
+	BaseURL string
+	Model   string
+	// EmbeddingModel is used for duplicate detection. It defaults to
+	// text-embedding-3-small for OpenAI; other servers need it set
+	// explicitly, otherwise a lexical comparison is used instead.
+	EmbeddingModel string
+}
+
+// NewProvider creates the LLM provider described by cfg
+func NewProvider(cfg ProviderConfig) (LLMProvider, error) {
+	switch cfg.Provider {
+	case "", "openai":
+		model := cfg.Model
+		if model == "" {
+			model = openai.GPT3Dot5Turbo
+		}
+		provider := NewOpenAIProvider(openai.DefaultConfig(cfg.APIKey), model)
+		provider.embeddingModel = cfg.EmbeddingModel
+		if provider.embeddingModel == "" {
+			provider.embeddingModel = string(openai.SmallEmbedding3)
+		}
+		return provider, nil
+	case "openai-compatible":
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("provider %q requires a base URL", cfg.Provider)
+		}
+		if cfg.Model == "" {
+			return nil, fmt.Errorf("provider %q requires a model name", cfg.Provider)
+		}
+		// Local servers usually ignore the key, but the client always sends one
+		clientConfig := openai.DefaultConfig(cfg.APIKey)
+		clientConfig.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+		provider := NewOpenAIProvider(clientConfig, cfg.Model)
+		provider.embeddingModel = cfg.EmbeddingModel
+		return provider, nil
+	case "fake":
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+}
+
+// OpenAIProvider talks to the OpenAI API or any server implementing its
+// chat completions endpoint
+type OpenAIProvider struct {
+	client         *openai.Client
+	model          string
+	embeddingModel string
+	// noSchema is set once the server rejected a JSON schema response
+	// format, as older models and many local servers do
+	noSchema atomic.Bool
+}
+
+// NewOpenAIProvider creates a provider for the given client configuration
+func NewOpenAIProvider(config openai.ClientConfig, model string) *OpenAIProvider {
+	config.HTTPClient = &retryAfterDoer{next: config.HTTPClient}
+	return &OpenAIProvider{
+		client: openai.NewClientWithConfig(config),
+		model:  model,
+	}
+}
+
+// Model implements ModelNamer
+func (p *OpenAIProvider) Model() string {
+	return p.model
+}
+
+// Complete implements LLMProvider
+func (p *OpenAIProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	ctx, hint := withRetryAfterHint(ctx)
+	chatReq := openai.ChatCompletionRequest{
+		Model: p.model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: req.System,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: req.Prompt,
+			},
+		},
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	if req.Schema != nil && !p.noSchema.Load() {
+		chatReq.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   req.Schema.Name,
+				Schema: req.Schema.Schema,
+				Strict: true,
+			},
+		}
+	}
+
+	resp, err := p.client.CreateChatCompletion(ctx, chatReq)
+	if err != nil && chatReq.ResponseFormat != nil && schemaUnsupported(err) {
+		log.Printf("Model %s does not support JSON schema output, falling back to prompt instructions", p.model)
+		p.noSchema.Store(true)
+		chatReq.ResponseFormat = nil
+		resp, err = p.client.CreateChatCompletion(ctx, chatReq)
+	}
+	if err != nil {
+		llmErr := classifyError(err)
+		llmErr.RetryAfter = hint.get()
+		return "", llmErr
+	}
+	if len(resp.Choices) == 0 {
+		return "", &LLMError{Kind: ErrorTransient, Err: fmt.Errorf("model returned no choices")}
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// schemaUnsupported reports whether a request failed because the server does
+// not accept a JSON schema response format
+func schemaUnsupported(err error) bool {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != 400 {
+		return false
+	}
+	message := strings.ToLower(apiErr.Message)
+	return strings.Contains(message, "response_format") || strings.Contains(message, "json_schema")
+}
+
+// embedBatchSize is the number of texts sent in one embeddings request
+const embedBatchSize = 256
+
+// Embed implements Embedder
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if p.embeddingModel == "" {
+		return nil, ErrEmbeddingsUnavailable
+	}
+
+	vectors := make([][]float32, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch := texts[start:min(start+embedBatchSize, len(texts))]
+		resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+			Input: batch,
+			Model: openai.EmbeddingModel(p.embeddingModel),
+		})
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		for _, data := range resp.Data {
+			if data.Index >= 0 && data.Index < len(batch) {
+				vectors[start+data.Index] = data.Embedding
+			}
+		}
+	}
+
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("no embedding returned for text %d", i+1)
+		}
+	}
+	return vectors, nil
+}
+
+// FakeProvider answers prompts deterministically without any network access.
+// It turns the sentences of the prompt's source text into cards, which is
+// enough to exercise the whole processing pipeline offline.
+type FakeProvider struct{}
+
+// NewFakeProvider creates a deterministic provider for tests and offline use
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+var (
+	fakeCountPattern    = regexp.MustCompile(`Create (\d+)`)
+	fakeSentencePattern = regexp.MustCompile(`[^.!?\n]+[.!?]?`)
+	fakeVerifyPattern   = regexp.MustCompile(`(?m)^\d+\. Q: `)
+)
+
+// Complete implements LLMProvider
+func (p *FakeProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	count := 3
+	if m := fakeCountPattern.FindStringSubmatch(req.Prompt); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 0 {
+			count = n
+		}
+	}
+
+	// Verification requests are answered by accepting every card
+	if req.Schema == verdictSchema {
+		var verdicts []jsonVerdict
+		for i := range fakeVerifyPattern.FindAllString(req.Prompt, -1) {
+			verdicts = append(verdicts, jsonVerdict{Card: i + 1, Grounded: true, Confidence: 1, Justification: "Accepted by the fake provider."})
+		}
+		data, err := json.Marshal(map[string]any{"verdicts": verdicts})
+		return string(data), err
+	}
+
+	// The source text always comes last in the prompt
+	source := req.Prompt
+	if i := strings.LastIndex(source, ":\n"); i >= 0 {
+		source = source[i+2:]
+	}
+
+	// Cloze cards are only created when nothing else is accepted
+	cloze := req.Schema == cardSchemas[CardTypeCloze]
+
+	var cards []jsonCard
+	for _, sentence := range fakeSentencePattern.FindAllString(source, -1) {
+		if count == 0 {
+			break
+		}
+		words := strings.Fields(sentence)
+		if len(words) < 3 {
+			continue
+		}
+		if cloze {
+			cards = append(cards, fakeClozeCard(words))
+			count--
+			continue
+		}
+		subject := strings.Join(words[:min(len(words), 5)], " ")
+		cards = append(cards, jsonCard{
+			Question: fmt.Sprintf("What does the text state about \"%s\"?", subject),
+			Answer:   strings.Join(words, " "),
+			Tags:     []string{},
+			Type:     CardTypeBasic,
+		})
+		count--
+	}
+
+	if req.Schema != nil {
+		data, err := json.Marshal(map[string]any{"cards": cards})
+		return string(data), err
+	}
+
+	var sb strings.Builder
+	for _, card := range cards {
+		fmt.Fprintf(&sb, "Q: %s\nA: %s\n\n", card.Question, card.Answer)
+	}
+	return sb.String(), nil
+}
+
+// fakeClozeCard hides the longest word of a sentence
+func fakeClozeCard(words []string) jsonCard {
+	longest := 0
+	for i, word := range words {
+		if len(word) > len(words[longest]) {
+			longest = i
+		}
+	}
+	text := make([]string, len(words))
+	copy(text, words)
+	word := strings.TrimRight(text[longest], ".,;:!?")
+	text[longest] = "{{c1::" + word + "}}" + text[longest][len(word):]
+	return jsonCard{
+		Question: strings.Join(text, " "),
+		Answer:   "",
+		Tags:     []string{},
+		Type:     CardTypeCloze,
+	}
+}
@@ -0,0 +1,101 @@
+package pdf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// schemaServer is an OpenAI-compatible server that rejects requests with a
+// response format with the given error message
+type schemaServer struct {
+	message string
+	mu      sync.Mutex
+	// formats records whether each request asked for a response format
+	formats []bool
+}
+
+func (s *schemaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResponseFormat json.RawMessage `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	format := len(req.ResponseFormat) > 0 && string(req.ResponseFormat) != "null"
+	s.formats = append(s.formats, format)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if format {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+			"message": s.message,
+			"type":    "invalid_request_error",
+		}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"choices": []map[string]any{{"message": map[string]string{"role": "assistant", "content": "Q: What is ATP?\nA: Energy"}}},
+	})
+}
+
+func TestOpenAIProviderFallsBackWithoutSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// wantFallback is set when the request is repeated without the schema
+		wantFallback bool
+	}{
+		{"response format rejected", "Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model.", true},
+		{"json schema rejected", "This server does not support json_schema", true},
+		{"other bad request", "max_tokens is too large", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &schemaServer{message: tt.message}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			config := openai.DefaultConfig("test")
+			config.BaseURL = ts.URL + "/v1"
+			provider := NewOpenAIProvider(config, "test-model")
+			req := CompletionRequest{Prompt: "Create cards", Schema: cardSchemas[CardTypeBasic]}
+
+			response, err := provider.Complete(context.Background(), req)
+			if !tt.wantFallback {
+				var llmErr *LLMError
+				if err == nil || !errors.As(err, &llmErr) || llmErr.Kind != ErrorPermanent || llmErr.StatusCode != http.StatusBadRequest {
+					t.Fatalf("Complete = %q, %v, want a permanent error", response, err)
+				}
+				if len(server.formats) != 1 {
+					t.Errorf("server got %d requests, want 1", len(server.formats))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if response != "Q: What is ATP?\nA: Energy" {
+				t.Errorf("response = %q", response)
+			}
+
+			// The schema is not sent again once the server rejected it
+			if _, err := provider.Complete(context.Background(), req); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if want := []bool{true, false, false}; !slices.Equal(server.formats, want) {
+				t.Errorf("requests asked for a response format %v, want %v", server.formats, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package pdf
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// maxChunkConcurrency caps the chunks of one job sent to the LLM at once
+const maxChunkConcurrency = 16
+
+// minChunkTokens keeps chunks large enough to generate cards from
+const minChunkTokens = 200
+
+// ProcessingConfig controls how much work the service does in parallel
+type ProcessingConfig struct {
+	// Workers is the number of jobs processed at the same time; further
+	// jobs wait in a queue
+	Workers int
+	// ChunkConcurrency is the default number of chunks of a job sent to the
+	// LLM in parallel; jobs may override it
+	ChunkConcurrency int
+	// RequestsPerMinute limits LLM requests across all jobs; a negative
+	// value disables the limit
+	RequestsPerMinute int
+	// Burst is the number of requests allowed at once before the rate
+	// limit applies
+	Burst int
+	// MaxRetries is how often a failed LLM request is retried; a negative
+	// value disables retries
+	MaxRetries int
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff
+	// between retries; RetryMaxDelay also caps a server's Retry-After
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// ChunkTokens is the size of the chunks documents are split into, as
+	// approximated for the model's tokenizer family
+	ChunkTokens int
+	// ChunkOverlapTokens repeats up to this many tokens of trailing
+	// sentences at the start of the next chunk; 0 disables overlap
+	ChunkOverlapTokens int
+	// DedupThreshold is the default similarity from which cards count as
+	// semantic duplicates; 0 uses a default that depends on whether
+	// embeddings or the lexical fallback are used
+	DedupThreshold float64
+	// JobRetention is how long finished jobs stay queryable and in the job
+	// journal; a negative value keeps them forever
+	JobRetention time.Duration
+}
+
+// DefaultProcessingConfig returns the settings used for unset fields
+func DefaultProcessingConfig() ProcessingConfig {
+	return ProcessingConfig{
+		Workers:           2,
+		ChunkConcurrency:  2,
+		RequestsPerMinute: 60,
+		Burst:             4,
+		MaxRetries:        5,
+		RetryBaseDelay:    time.Second,
+		RetryMaxDelay:     time.Minute,
+		ChunkTokens:       1500,
+		JobRetention:      7 * 24 * time.Hour,
+	}
+}
+
+func (c ProcessingConfig) withDefaults() ProcessingConfig {
+	defaults := DefaultProcessingConfig()
+	if c.Workers <= 0 {
+		c.Workers = defaults.Workers
+	}
+	if c.ChunkConcurrency <= 0 {
+		c.ChunkConcurrency = defaults.ChunkConcurrency
+	}
+	if c.ChunkConcurrency > maxChunkConcurrency {
+		c.ChunkConcurrency = maxChunkConcurrency
+	}
+	if c.RequestsPerMinute == 0 {
+		c.RequestsPerMinute = defaults.RequestsPerMinute
+	}
+	if c.Burst <= 0 {
+		c.Burst = defaults.Burst
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaults.MaxRetries
+	} else if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.RetryBaseDelay <= 0 {
+		c.RetryBaseDelay = defaults.RetryBaseDelay
+	}
+	if c.RetryMaxDelay < c.RetryBaseDelay {
+		c.RetryMaxDelay = max(defaults.RetryMaxDelay, c.RetryBaseDelay)
+	}
+	if c.ChunkTokens <= 0 {
+		c.ChunkTokens = defaults.ChunkTokens
+	}
+	c.ChunkTokens = max(c.ChunkTokens, minChunkTokens)
+	// Overlap of half a chunk or more would barely advance through the text
+	c.ChunkOverlapTokens = max(0, min(c.ChunkOverlapTokens, c.ChunkTokens/2))
+	if c.DedupThreshold < 0 || c.DedupThreshold > 1 {
+		c.DedupThreshold = 0
+	}
+	if c.JobRetention == 0 {
+		c.JobRetention = defaults.JobRetention
+	}
+	return c
+}
+
+// queuedJob is a job waiting for a free worker
+type queuedJob struct {
+	ctx    context.Context
+	id     string
+	input  JobInput
+	resume *jobRecord
+}
+
+// enqueue adds a job to the end of the queue
+func (s *Service) enqueue(job queuedJob) {
+	s.queueMutex.Lock()
+	s.queue = append(s.queue, job)
+	s.queueMutex.Unlock()
+	s.queueCond.Signal()
+
+	s.updateQueuePositions()
+}
+
+// dequeue removes a job that is still waiting and reports whether it was
+// found in the queue
+func (s *Service) dequeue(jobID string) bool {
+	s.queueMutex.Lock()
+	defer s.queueMutex.Unlock()
+
+	for i, job := range s.queue {
+		if job.id == jobID {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// worker runs queued jobs one after another until the service is closed
+func (s *Service) worker() {
+	defer s.workers.Done()
+	for {
+		s.queueMutex.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.queueCond.Wait()
+		}
+		if s.closed {
+			s.queueMutex.Unlock()
+			return
+		}
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		s.queueMutex.Unlock()
+
+		s.updateQueuePositions()
+		s.processJob(job.ctx, job.id, job.input, job.resume)
+	}
+}
+
+// Close stops the workers and closes the job journal. Running jobs are
+// allowed to finish; jobs still in the queue stay journaled and are resumed
+// by the next service. If ctx ends before the running jobs do, Close
+// returns its error and leaves the jobs and the journal open.
+func (s *Service) Close(ctx context.Context) error {
+	s.queueMutex.Lock()
+	s.closed = true
+	s.queueMutex.Unlock()
+	s.queueCond.Broadcast()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.jobStore.Close()
+}
+
+// isClosed reports whether Close was called
+func (s *Service) isClosed() bool {
+	s.queueMutex.Lock()
+	defer s.queueMutex.Unlock()
+	return s.closed
+}
+
+// updateQueuePositions reports each waiting job's place in the queue
+func (s *Service) updateQueuePositions() {
+	s.queueMutex.Lock()
+	ids := make([]string, len(s.queue))
+	for i, job := range s.queue {
+		ids[i] = job.id
+	}
+	s.queueMutex.Unlock()
+
+	for i, id := range ids {
+		position := i + 1
+		if status := s.GetJobStatus(id); status == nil || status.QueuePosition == position {
+			continue
+		}
+		s.updateJob(id, func(status *ProcessingStatus) {
+			status.QueuePosition = position
+		})
+	}
+}
+
+// sortRecordsByCreation orders journaled jobs so they are resumed in the
+// order they were submitted
+func sortRecordsByCreation(records []*jobRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Input.Created.Equal(records[j].Input.Created) {
+			return records[i].ID < records[j].ID
+		}
+		return records[i].Input.Created.Before(records[j].Input.Created)
+	})
+}
@@ -0,0 +1,293 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+	"github.com/jspohler/AnkiCards/backend/internal/services/prompts"
+)
+
+// blockingProvider holds every request until release is closed
+type blockingProvider struct {
+	*FakeProvider
+	// started receives a value for every request that reaches the provider
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingProvider() *blockingProvider {
+	return &blockingProvider{
+		FakeProvider: NewFakeProvider(),
+		started:      make(chan struct{}, 100),
+		release:      make(chan struct{}),
+	}
+}
+
+func (p *blockingProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	p.started <- struct{}{}
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return p.FakeProvider.Complete(ctx, req)
+}
+
+// stallingProvider answers the first requests and holds every later one
+// until its context ends
+type stallingProvider struct {
+	*FakeProvider
+	answer  int32
+	calls   atomic.Int32
+	stalled chan struct{}
+}
+
+func (p *stallingProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	if p.calls.Add(1) <= p.answer {
+		return p.FakeProvider.Complete(ctx, req)
+	}
+	p.stalled <- struct{}{}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// newTestService creates a service whose directories live in dir
+func newTestService(t *testing.T, dir string, provider LLMProvider, config ProcessingConfig) *Service {
+	t.Helper()
+	repo, err := anki.NewFileRepository(filepath.Join(dir, "decks"))
+	if err != nil {
+		t.Fatalf("NewFileRepository: %v", err)
+	}
+	promptService, err := prompts.NewService(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatalf("prompts.NewService: %v", err)
+	}
+	config.RequestsPerMinute = -1
+	s, err := NewService(filepath.Join(dir, "uploads"), filepath.Join(dir, "cards"), repo, provider, 1, nil, promptService, config)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return s
+}
+
+// startTestJob uploads a short document under name and starts a job for it
+func startTestJob(t *testing.T, s *Service, name string) string {
+	t.Helper()
+	text := "Mitochondria produce most of the ATP a cell needs. Ribosomes translate messenger RNA into proteins."
+	path, err := s.SaveUploadedFile([]byte(text), name)
+	if err != nil {
+		t.Fatalf("SaveUploadedFile: %v", err)
+	}
+	jobID, err := s.StartProcessing([]string{path}, JobOptions{})
+	if err != nil {
+		t.Fatalf("StartProcessing: %v", err)
+	}
+	return jobID
+}
+
+// waitForJob polls a job's status until done accepts it
+func waitForJob(t *testing.T, s *Service, jobID string, done func(status *ProcessingStatus) bool) *ProcessingStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status := s.GetJobStatus(jobID)
+		if status != nil && done(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not reach the expected state: %+v", jobID, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func finished(status *ProcessingStatus) bool {
+	return IsTerminalStatus(status.Status)
+}
+
+func TestQueue(t *testing.T) {
+	provider := newBlockingProvider()
+	s := newTestService(t, t.TempDir(), provider, ProcessingConfig{Workers: 1})
+	defer s.Close(context.Background())
+
+	first := startTestJob(t, s, "first.txt")
+	<-provider.started
+	second := startTestJob(t, s, "second.txt")
+	third := startTestJob(t, s, "third.txt")
+
+	if status := s.GetJobStatus(first); status.Status != "processing" || status.QueuePosition != 0 {
+		t.Errorf("running job = %q at position %d", status.Status, status.QueuePosition)
+	}
+	for i, id := range []string{second, third} {
+		if status := s.GetJobStatus(id); status.Status != "pending" || status.QueuePosition != i+1 {
+			t.Errorf("queued job %d = %q at position %d", i+1, status.Status, status.QueuePosition)
+		}
+	}
+
+	// A cancelled job leaves the queue without reaching a worker
+	if err := s.CancelJob(second); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if status := s.GetJobStatus(second); status.Status != "cancelled" || status.QueuePosition != 0 || status.FinishedAt == nil {
+		t.Errorf("cancelled job = %+v", status)
+	}
+	if status := s.GetJobStatus(third); status.QueuePosition != 1 {
+		t.Errorf("last job moved to position %d, want 1", status.QueuePosition)
+	}
+	if err := s.CancelJob(second); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("CancelJob of a cancelled job = %v, want ErrJobFinished", err)
+	}
+	if err := s.CancelJob("job_0"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("CancelJob of an unknown job = %v, want ErrJobNotFound", err)
+	}
+
+	close(provider.release)
+	for _, id := range []string{first, third} {
+		if status := waitForJob(t, s, id, finished); status.Status != "completed" {
+			t.Errorf("job %s = %q: %s", id, status.Status, status.Error)
+		}
+	}
+	firstDone := s.GetJobStatus(first).FinishedAt
+	if started := s.GetJobStatus(third).Files[0].StartedAt; started.Before(*firstDone) {
+		t.Errorf("queued job started at %v before the running job finished at %v", started, firstDone)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	provider := &stallingProvider{FakeProvider: NewFakeProvider(), answer: 1, stalled: make(chan struct{}, 100)}
+	s := newTestService(t, t.TempDir(), provider, ProcessingConfig{Workers: 1, ChunkConcurrency: 1, ChunkTokens: minChunkTokens})
+	defer s.Close(context.Background())
+
+	// Enough text for several chunks
+	var text strings.Builder
+	for i := 1; i <= 80; i++ {
+		fmt.Fprintf(&text, "Organelle number %d of the cell has its own membrane and function. ", i)
+	}
+	path, err := s.SaveUploadedFile([]byte(text.String()), "cell.txt")
+	if err != nil {
+		t.Fatalf("SaveUploadedFile: %v", err)
+	}
+	jobID, err := s.StartProcessing([]string{path}, JobOptions{})
+	if err != nil {
+		t.Fatalf("StartProcessing: %v", err)
+	}
+
+	// The first chunk is answered, the second is in flight
+	<-provider.stalled
+	if err := s.CancelJob(jobID); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	status := waitForJob(t, s, jobID, finished)
+	if status.Status != "cancelled" || status.Files[0].Status != "cancelled" {
+		t.Fatalf("job = %q with file %q, want cancelled: %s", status.Status, status.Files[0].Status, status.Error)
+	}
+	if err := s.CancelJob(jobID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("CancelJob of a cancelled job = %v, want ErrJobFinished", err)
+	}
+	if calls := provider.calls.Load(); calls != 2 {
+		t.Errorf("provider called %d times, want no requests after the cancellation", calls)
+	}
+
+	// The cards of the answered chunk are saved
+	if status.TotalCards == 0 || status.DeckName != "cell" {
+		t.Fatalf("cancelled job kept %d cards in deck %q", status.TotalCards, status.DeckName)
+	}
+	deck, err := s.decks.GetDeck(status.DeckName)
+	if err != nil {
+		t.Fatalf("GetDeck: %v", err)
+	}
+	if len(deck.Cards) != status.TotalCards {
+		t.Errorf("deck has %d cards, want %d", len(deck.Cards), status.TotalCards)
+	}
+}
+
+func TestServiceClose(t *testing.T) {
+	dir := t.TempDir()
+	provider := newBlockingProvider()
+	s := newTestService(t, dir, provider, ProcessingConfig{Workers: 1})
+
+	running := startTestJob(t, s, "running.txt")
+	<-provider.started
+	queued := startTestJob(t, s, "queued.txt")
+
+	// Close waits for the running job
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close with a running job = %v, want DeadlineExceeded", err)
+	}
+	if _, err := s.StartProcessing([]string{filepath.Join(dir, "uploads", "queued.txt")}, JobOptions{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("StartProcessing after Close = %v, want ErrClosed", err)
+	}
+
+	close(provider.release)
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if status := s.GetJobStatus(running); status.Status != "completed" {
+		t.Errorf("running job = %q: %s", status.Status, status.Error)
+	}
+	if status := s.GetJobStatus(queued); status.Status != "pending" {
+		t.Errorf("queued job = %q after Close, want pending", status.Status)
+	}
+
+	// The queued job is resumed by the next service
+	next := newTestService(t, dir, NewFakeProvider(), ProcessingConfig{Workers: 1})
+	defer next.Close(context.Background())
+	next.ResumeJobs()
+	if status := waitForJob(t, next, queued, finished); status.Status != "completed" {
+		t.Errorf("resumed job = %q: %s", status.Status, status.Error)
+	}
+	if status := next.GetJobStatus(running); status.Status != "completed" {
+		t.Errorf("finished job after restart = %q", status.Status)
+	}
+}
+
+func TestProcessingConfigDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProcessingConfig
+		check  func(c ProcessingConfig) bool
+	}{
+		{"unset fields", ProcessingConfig{}, func(c ProcessingConfig) bool { return c == DefaultProcessingConfig() }},
+		{"chunk concurrency is capped", ProcessingConfig{ChunkConcurrency: 100}, func(c ProcessingConfig) bool { return c.ChunkConcurrency == maxChunkConcurrency }},
+		{"negative rate disables the limit", ProcessingConfig{RequestsPerMinute: -1}, func(c ProcessingConfig) bool { return c.RequestsPerMinute == -1 }},
+		{"negative retries disable retries", ProcessingConfig{MaxRetries: -1}, func(c ProcessingConfig) bool { return c.MaxRetries == 0 }},
+		{"max delay below the base delay", ProcessingConfig{RetryBaseDelay: 2 * time.Minute, RetryMaxDelay: time.Second}, func(c ProcessingConfig) bool { return c.RetryMaxDelay == 2*time.Minute }},
+		{"small chunks are raised", ProcessingConfig{ChunkTokens: 10}, func(c ProcessingConfig) bool { return c.ChunkTokens == minChunkTokens }},
+		{"overlap is at most half a chunk", ProcessingConfig{ChunkTokens: 1000, ChunkOverlapTokens: 800}, func(c ProcessingConfig) bool { return c.ChunkOverlapTokens == 500 }},
+		{"negative overlap", ProcessingConfig{ChunkOverlapTokens: -5}, func(c ProcessingConfig) bool { return c.ChunkOverlapTokens == 0 }},
+		{"threshold out of range", ProcessingConfig{DedupThreshold: 1.5}, func(c ProcessingConfig) bool { return c.DedupThreshold == 0 }},
+		{"negative retention keeps jobs", ProcessingConfig{JobRetention: -1}, func(c ProcessingConfig) bool { return c.JobRetention == -1 }},
+	}
+	for _, tt := range tests {
+		if got := tt.config.withDefaults(); !tt.check(got) {
+			t.Errorf("%s: got %+v", tt.name, got)
+		}
+	}
+}
+
+func TestSortRecordsByCreation(t *testing.T) {
+	now := time.Now()
+	records := []*jobRecord{
+		{ID: "c", Input: JobInput{Created: now.Add(time.Second)}},
+		{ID: "b", Input: JobInput{Created: now}},
+		{ID: "a", Input: JobInput{Created: now}},
+		{ID: "d", Input: JobInput{Created: now.Add(-time.Second)}},
+	}
+	sortRecordsByCreation(records)
+	var ids string
+	for _, record := range records {
+		ids += record.ID
+	}
+	if ids != "dabc" {
+		t.Errorf("records sorted as %s, want dabc", ids)
+	}
+}
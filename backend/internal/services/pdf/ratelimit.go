@@ -0,0 +1,92 @@
+package pdf
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token-bucket rate limiter. Tokens refill continuously at
+// a fixed rate up to the burst size and every request takes one.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket that allows perMinute requests per
+// minute with bursts of up to burst requests
+func NewTokenBucket(perMinute, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// Reserve the token right away so waiters are served in order
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Hand the reservation back to the other waiters
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedProvider makes every request to the wrapped provider take a
+// token from a bucket shared by all jobs
+type rateLimitedProvider struct {
+	LLMProvider
+	limiter *TokenBucket
+}
+
+// Complete implements LLMProvider
+func (p *rateLimitedProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return p.LLMProvider.Complete(ctx, req)
+}
+
+// rateLimitedEmbedder takes a token from the shared bucket for every
+// embeddings request
+type rateLimitedEmbedder struct {
+	Embedder
+	limiter *TokenBucket
+}
+
+// Embed implements Embedder
+func (e *rateLimitedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := e.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return e.Embedder.Embed(ctx, texts)
+}
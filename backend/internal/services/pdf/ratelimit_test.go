@@ -0,0 +1,91 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	// 6000 requests per minute refill one token every 10ms
+	b := NewTokenBucket(6000, 3)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if b.tokens < 0 || b.tokens >= 1 {
+		t.Errorf("tokens after the burst = %v, want less than one left without waiting", b.tokens)
+	}
+
+	// Further requests wait for the refill, one token at a time
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 requests after the burst took %v, want at least 40ms", elapsed)
+	}
+}
+
+func TestTokenBucketRefillsUpToBurst(t *testing.T) {
+	b := NewTokenBucket(60, 2)
+	b.tokens = 0
+	b.last = time.Now().Add(-time.Hour)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if b.tokens != 1 {
+		t.Errorf("tokens after an hour idle = %v, want the burst of 2 minus 1", b.tokens)
+	}
+
+	// A burst below one still lets single requests through
+	if b := NewTokenBucket(60, 0); b.burst != 1 {
+		t.Errorf("burst = %v, want 1", b.burst)
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b := NewTokenBucket(60, 1)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled Wait returned after %v", elapsed)
+	}
+	// The cancelled request hands its reservation back
+	if b.tokens < 0 {
+		t.Errorf("tokens after a cancelled wait = %v, want the reservation returned", b.tokens)
+	}
+}
+
+func TestRateLimitedProvider(t *testing.T) {
+	provider := &retryAfterProvider{}
+	limited := &rateLimitedProvider{LLMProvider: provider, limiter: NewTokenBucket(60, 1)}
+
+	if _, err := limited.Complete(context.Background(), CompletionRequest{}); err == nil || provider.calls != 1 {
+		t.Fatalf("first request = %v after %d calls, want the provider's error", err, provider.calls)
+	}
+
+	// Without a token the request never reaches the provider
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limited.Complete(ctx, CompletionRequest{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("request without a token = %v, want Canceled", err)
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %d times, want 1", provider.calls)
+	}
+}
@@ -0,0 +1,725 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// minPageTextLetters is the number of letters a page needs before its text
+// layer is trusted over OCR
+const minPageTextLetters = 20
+
+// maxFormDepth limits how deep nested form XObjects are followed
+const maxFormDepth = 5
+
+// extractTextLayer returns the embedded text of every page, indexed by page
+// number minus one
+func extractTextLayer(filePath string) ([]string, error) {
+	ctx, err := api.ReadContextFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	pages := make([]string, ctx.PageCount)
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, attrs, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", pageNr, err)
+		}
+
+		content, err := ctx.PageContent(pageDict)
+		if err != nil && err != model.ErrNoContent {
+			return nil, fmt.Errorf("failed to read content of page %d: %w", pageNr, err)
+		}
+
+		var resources types.Dict
+		if attrs != nil {
+			resources = attrs.Resources
+		}
+
+		ex := &textExtractor{xref: ctx.XRefTable, fonts: make(map[string]*fontDecoder)}
+		ex.run(content, resources, 0)
+		pages[pageNr-1] = ex.text()
+	}
+
+	return pages, nil
+}
+
+// hasUsableText reports whether an extracted text layer contains enough
+// readable text to skip OCR. Scanned pages have no text at all, and fonts
+// without a usable encoding produce mostly replacement characters.
+func hasUsableText(text string) bool {
+	var letters, total, garbage int
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			letters++
+		case r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r):
+			garbage++
+		}
+	}
+
+	if letters < minPageTextLetters {
+		return false
+	}
+	return float64(garbage)/float64(total) < 0.1 && float64(letters)/float64(total) > 0.5
+}
+
+// textExtractor interprets the text operators of a content stream
+type textExtractor struct {
+	xref  *model.XRefTable
+	fonts map[string]*fontDecoder
+	font  *fontDecoder
+	out   strings.Builder
+	lastY float64
+}
+
+func (ex *textExtractor) text() string {
+	lines := strings.Split(ex.out.String(), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+func (ex *textExtractor) newline() {
+	ex.out.WriteByte('\n')
+}
+
+func (ex *textExtractor) space() {
+	ex.out.WriteByte(' ')
+}
+
+func (ex *textExtractor) show(s string) {
+	if ex.font == nil {
+		ex.font = &fontDecoder{}
+	}
+	ex.out.WriteString(ex.font.decode(s))
+}
+
+func (ex *textExtractor) run(content []byte, resources types.Dict, depth int) {
+	lex := &contentLexer{data: content}
+	var operands []interface{}
+
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			return
+		}
+		op, isOp := tok.(contentOperator)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch op {
+		case "ET":
+			ex.space()
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(contentName); ok {
+					ex.font = ex.fontFor(resources, string(name))
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				if s, ok := operands[0].(contentString); ok {
+					ex.show(string(s))
+				}
+			}
+		case "'", "\"":
+			ex.newline()
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].(contentString); ok {
+					ex.show(string(s))
+				}
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[0].(contentArray); ok {
+					for _, item := range arr {
+						switch v := item.(type) {
+						case contentString:
+							ex.show(string(v))
+						case float64:
+							// Large negative kerning is how many producers encode word gaps
+							if v < -200 {
+								ex.space()
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				ty, _ := operands[1].(float64)
+				if ty != 0 {
+					ex.newline()
+				} else {
+					ex.space()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[5].(float64)
+				if y != ex.lastY {
+					ex.newline()
+				} else {
+					ex.space()
+				}
+				ex.lastY = y
+			}
+		case "T*":
+			ex.newline()
+		case "Do":
+			if len(operands) >= 1 && depth < maxFormDepth {
+				if name, ok := operands[0].(contentName); ok {
+					ex.runForm(resources, string(name), depth)
+				}
+			}
+		case "BI":
+			lex.skipInlineImage()
+		}
+
+		operands = operands[:0]
+	}
+}
+
+// runForm extracts the text of a form XObject drawn with "Do"
+func (ex *textExtractor) runForm(resources types.Dict, name string, depth int) {
+	xobjects := ex.dict(resources, "XObject")
+	if xobjects == nil {
+		return
+	}
+	obj, found := xobjects.Find(name)
+	if !found {
+		return
+	}
+	sd, _, err := ex.xref.DereferenceStreamDict(obj)
+	if err != nil || sd == nil {
+		return
+	}
+	if subtype := sd.Subtype(); subtype == nil || *subtype != "Form" {
+		return
+	}
+	if err := sd.Decode(); err != nil {
+		return
+	}
+
+	formResources := ex.dict(sd.Dict, "Resources")
+	if formResources == nil {
+		formResources = resources
+	}
+
+	// Forms have their own font namespace
+	outer := ex.fonts
+	ex.fonts = make(map[string]*fontDecoder)
+	ex.run(sd.Content, formResources, depth+1)
+	ex.fonts = outer
+}
+
+func (ex *textExtractor) dict(d types.Dict, key string) types.Dict {
+	if d == nil {
+		return nil
+	}
+	obj, found := d.Find(key)
+	if !found {
+		return nil
+	}
+	sub, err := ex.xref.DereferenceDict(obj)
+	if err != nil {
+		return nil
+	}
+	return sub
+}
+
+func (ex *textExtractor) fontFor(resources types.Dict, name string) *fontDecoder {
+	if font, ok := ex.fonts[name]; ok {
+		return font
+	}
+
+	font := &fontDecoder{}
+	ex.fonts[name] = font
+
+	fonts := ex.dict(resources, "Font")
+	if fonts == nil {
+		return font
+	}
+	obj, found := fonts.Find(name)
+	if !found {
+		return font
+	}
+	fontDict, err := ex.xref.DereferenceDict(obj)
+	if err != nil || fontDict == nil {
+		return font
+	}
+
+	if subtype := fontDict.Subtype(); subtype != nil && *subtype == "Type0" {
+		font.codeLength = 2
+	}
+
+	if obj, found := fontDict.Find("ToUnicode"); found {
+		if sd, _, err := ex.xref.DereferenceStreamDict(obj); err == nil && sd != nil && sd.Decode() == nil {
+			font.parseCMap(sd.Content)
+		}
+	}
+
+	if obj, found := fontDict.Find("Encoding"); found {
+		if o, err := ex.xref.Dereference(obj); err == nil {
+			if enc, ok := o.(types.Dict); ok {
+				if diffs := enc.ArrayEntry("Differences"); diffs != nil {
+					font.parseDifferences(ex.xref, diffs)
+				}
+			}
+		}
+	}
+
+	return font
+}
+
+// fontDecoder maps character codes of a single font to Unicode text
+type fontDecoder struct {
+	codeLength  int
+	toUnicode   map[uint32]string
+	differences map[byte]string
+}
+
+func (f *fontDecoder) decode(s string) string {
+	var sb strings.Builder
+	if f.codeLength >= 2 {
+		for i := 0; i+1 < len(s); i += 2 {
+			code := uint32(s[i])<<8 | uint32(s[i+1])
+			if u, ok := f.toUnicode[code]; ok {
+				sb.WriteString(u)
+			} else {
+				sb.WriteRune(utf8.RuneError)
+			}
+		}
+		return sb.String()
+	}
+
+	for i := 0; i < len(s); i++ {
+		code := s[i]
+		if u, ok := f.toUnicode[uint32(code)]; ok {
+			sb.WriteString(u)
+		} else if u, ok := f.differences[code]; ok {
+			sb.WriteString(u)
+		} else {
+			sb.WriteRune(winAnsiRune(code))
+		}
+	}
+	return sb.String()
+}
+
+// parseCMap reads the bfchar and bfrange mappings of a ToUnicode CMap
+func (f *fontDecoder) parseCMap(data []byte) {
+	if f.toUnicode == nil {
+		f.toUnicode = make(map[uint32]string)
+	}
+
+	lex := &contentLexer{data: data}
+	var operands []interface{}
+	mode := ""
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			return
+		}
+		op, isOp := tok.(contentOperator)
+		if !isOp {
+			operands = append(operands, tok)
+			// The width of the code space decides how show strings are split
+			if mode == "codespace" && len(operands) == 2 {
+				if lo, ok := operands[0].(contentString); ok && len(lo) > 0 {
+					f.codeLength = len(lo)
+				}
+				operands = operands[:0]
+			}
+			if mode == "bfchar" && len(operands) == 2 {
+				src, _ := operands[0].(contentString)
+				dst, _ := operands[1].(contentString)
+				f.toUnicode[codeValue(string(src))] = utf16BE(string(dst))
+				operands = operands[:0]
+			}
+			if mode == "bfrange" && len(operands) == 3 {
+				f.addRange(operands)
+				operands = operands[:0]
+			}
+			continue
+		}
+
+		switch op {
+		case "begincodespacerange":
+			mode = "codespace"
+		case "beginbfchar":
+			mode = "bfchar"
+		case "beginbfrange":
+			mode = "bfrange"
+		case "endcodespacerange", "endbfchar", "endbfrange":
+			mode = ""
+		}
+		operands = operands[:0]
+	}
+}
+
+func (f *fontDecoder) addRange(operands []interface{}) {
+	lo, ok1 := operands[0].(contentString)
+	hi, ok2 := operands[1].(contentString)
+	if !ok1 || !ok2 {
+		return
+	}
+	start, end := codeValue(string(lo)), codeValue(string(hi))
+	if end < start || end-start > 0xFFFF {
+		return
+	}
+
+	switch dst := operands[2].(type) {
+	case contentString:
+		units := utf16.Decode(utf16Units(string(dst)))
+		if len(units) == 0 {
+			return
+		}
+		last := len(units) - 1
+		for code := start; code <= end; code++ {
+			runes := append([]rune(nil), units...)
+			runes[last] += rune(code - start)
+			f.toUnicode[code] = string(runes)
+		}
+	case contentArray:
+		for i, item := range dst {
+			if s, ok := item.(contentString); ok && start+uint32(i) <= end {
+				f.toUnicode[start+uint32(i)] = utf16BE(string(s))
+			}
+		}
+	}
+}
+
+// parseDifferences applies an encoding's /Differences array
+func (f *fontDecoder) parseDifferences(xref *model.XRefTable, diffs types.Array) {
+	if f.differences == nil {
+		f.differences = make(map[byte]string)
+	}
+	code := 0
+	for _, item := range diffs {
+		o, err := xref.Dereference(item)
+		if err != nil {
+			continue
+		}
+		switch v := o.(type) {
+		case types.Integer:
+			code = int(v)
+		case types.Name:
+			if code >= 0 && code < 256 {
+				if u, ok := glyphUnicode(string(v)); ok {
+					f.differences[byte(code)] = u
+				}
+			}
+			code++
+		}
+	}
+}
+
+func codeValue(s string) uint32 {
+	var v uint32
+	for i := 0; i < len(s); i++ {
+		v = v<<8 | uint32(s[i])
+	}
+	return v
+}
+
+func utf16Units(s string) []uint16 {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return units
+}
+
+func utf16BE(s string) string {
+	return string(utf16.Decode(utf16Units(s)))
+}
+
+// glyphNames covers the non-trivial glyph names common in /Differences arrays
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$",
+	"percent": "%", "ampersand": "&", "quotesingle": "'", "quoteright": "’",
+	"quoteleft": "‘", "quotedblleft": "“", "quotedblright": "”",
+	"parenleft": "(", "parenright": ")", "asterisk": "*", "plus": "+", "comma": ",",
+	"hyphen": "-", "period": ".", "slash": "/", "colon": ":", "semicolon": ";",
+	"less": "<", "equal": "=", "greater": ">", "question": "?", "at": "@",
+	"bracketleft": "[", "backslash": "\\", "bracketright": "]", "underscore": "_",
+	"braceleft": "{", "bar": "|", "braceright": "}", "endash": "–",
+	"emdash": "—", "bullet": "•", "fi": "fi", "fl": "fl", "ff": "ff",
+	"ffi": "ffi", "ffl": "ffl", "zero": "0", "one": "1", "two": "2", "three": "3",
+	"four": "4", "five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+	"adieresis": "ä", "odieresis": "ö", "udieresis": "ü", "Adieresis": "Ä",
+	"Odieresis": "Ö", "Udieresis": "Ü", "germandbls": "ß", "eacute": "é",
+	"egrave": "è", "agrave": "à", "ccedilla": "ç", "minus": "−",
+}
+
+func glyphUnicode(name string) (string, bool) {
+	if len(name) == 1 {
+		return name, true
+	}
+	if u, ok := glyphNames[name]; ok {
+		return u, true
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if v, err := strconv.ParseUint(name[3:], 16, 32); err == nil {
+			return string(rune(v)), true
+		}
+	}
+	return "", false
+}
+
+// winAnsiHigh maps the 0x80-0x9F range of WinAnsiEncoding
+var winAnsiHigh = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
+
+func winAnsiRune(code byte) rune {
+	switch {
+	case code >= 0x80 && code <= 0x9F:
+		return winAnsiHigh[code-0x80]
+	case code < 0x20 && code != '\t' && code != '\n' && code != '\r':
+		return utf8.RuneError
+	default:
+		return rune(code)
+	}
+}
+
+// Content stream tokens
+type (
+	contentOperator string
+	contentName     string
+	contentString   string
+	contentArray    []interface{}
+	contentDict     struct{}
+)
+
+// contentLexer tokenizes PDF content streams and CMaps
+type contentLexer struct {
+	data []byte
+	pos  int
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+func (l *contentLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isWhitespace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+func (l *contentLexer) next() (interface{}, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, false
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), true
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.skipDict()
+		return contentDict{}, true
+	case c == '<':
+		return l.hexString(), true
+	case c == '[':
+		l.pos++
+		var arr contentArray
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return arr, true
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return arr, true
+			}
+			tok, ok := l.next()
+			if !ok {
+				return arr, true
+			}
+			arr = append(arr, tok)
+		}
+	case c == '/':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+			l.pos++
+		}
+		return contentName(l.data[start:l.pos]), true
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		l.pos++
+		return contentOperator(string(c)), true
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if n, err := strconv.ParseFloat(word, 64); err == nil {
+		return n, true
+	}
+	return contentOperator(word), true
+}
+
+func (l *contentLexer) literalString() contentString {
+	l.pos++ // opening parenthesis
+	var buf bytes.Buffer
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+			buf.WriteByte(c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return contentString(buf.String())
+			}
+			buf.WriteByte(c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				break
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case 'f':
+				buf.WriteByte('\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					buf.WriteByte(byte(v))
+				} else {
+					buf.WriteByte(e)
+				}
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return contentString(buf.String())
+}
+
+func (l *contentLexer) hexString() contentString {
+	l.pos++ // opening angle bracket
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		if !isWhitespace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // closing angle bracket
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return ""
+	}
+	return contentString(decoded)
+}
+
+func (l *contentLexer) skipDict() {
+	depth := 0
+	for l.pos+1 < len(l.data) {
+		switch {
+		case l.data[l.pos] == '<' && l.data[l.pos+1] == '<':
+			depth++
+			l.pos += 2
+		case l.data[l.pos] == '>' && l.data[l.pos+1] == '>':
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return
+			}
+		case l.data[l.pos] == '(':
+			l.literalString()
+		default:
+			l.pos++
+		}
+	}
+	l.pos = len(l.data)
+}
+
+// skipInlineImage jumps over the binary data of an inline image
+func (l *contentLexer) skipInlineImage() {
+	idx := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if idx < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += idx + 2
+	for {
+		end := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if end < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		l.pos += end + 2
+		// EI only ends the image when it stands alone as a token
+		if isWhitespace(l.data[l.pos-3]) && (l.pos >= len(l.data) || isWhitespace(l.data[l.pos])) {
+			return
+		}
+	}
+}
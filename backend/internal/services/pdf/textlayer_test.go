@@ -0,0 +1,149 @@
+package pdf
+
+import (
+	"strings"
+	"testing"
+)
+
+// extractContent runs a content stream through the text extractor. fonts are
+// the decoders of the fonts selected with Tf.
+func extractContent(content string, fonts map[string]*fontDecoder) string {
+	if fonts == nil {
+		fonts = make(map[string]*fontDecoder)
+	}
+	ex := &textExtractor{fonts: fonts}
+	ex.run([]byte(content), nil, 0)
+	return ex.text()
+}
+
+func TestTextExtractorSpacing(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Td down starts a line", "BT (Hello) Tj 0 -14 Td (World) Tj ET", "Hello\nWorld"},
+		{"Td along the line adds a space", "BT (Hello) Tj 30 0 Td (World) Tj ET", "Hello World"},
+		{"TD down starts a line", "BT (Hello) Tj 0 -14 TD (World) Tj ET", "Hello\nWorld"},
+		{"consecutive Tj are joined", "BT (Mito) Tj (chondria) Tj ET", "Mitochondria"},
+		{"large TJ kerning is a space", "BT [(Hel) -20 (lo) -250 (World)] TJ ET", "Hello World"},
+		{"TJ kerning of -200 is not a space", "BT [(A) -200 (B) 120 (C)] TJ ET", "ABC"},
+		{"Tm on the same baseline adds a space", "BT 1 0 0 1 72 700 Tm (A) Tj 1 0 0 1 90 700 Tm (B) Tj ET", "A B"},
+		{"Tm on another baseline starts a line", "BT 1 0 0 1 72 700 Tm (A) Tj 1 0 0 1 72 686 Tm (B) Tj ET", "A\nB"},
+		{"T* and quote start lines", "BT (A) Tj T* (B) Tj (C) ' 0 0 (D) \" ET", "A\nB\nC\nD"},
+		{"text objects are separated", "BT (A) Tj ET BT (B) Tj ET", "A B"},
+		{"blank lines and runs of spaces are dropped", "BT (A  B) Tj T* T* ( C ) Tj ET", "A B\nC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractContent(tt.content, nil); got != tt.want {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentLexer(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"literal string escapes", `BT (a\(b\) \101\0620 \\) Tj ET`, `a(b) A20 \`},
+		{"nested parentheses", "BT (f(x) = (y)) Tj ET", "f(x) = (y)"},
+		{"line continuation", "BT (con\\\ntinued) Tj ET", "continued"},
+		{"hex string", "BT <48 65 6C6C 6F> Tj <2> Tj ET", "Hello"},
+		{"comments", "BT % (Hidden) Tj\n(Shown) Tj ET", "Shown"},
+		{"marked content dictionaries", "/Span <</ActualText (X) /Nested <</A 1>>>> BDC BT (Y) Tj ET EMC", "Y"},
+		{"inline images", "BT (A) Tj ET BI /W 4 /H 1 /BPC 8 ID xEIx\x01(Z) Tj EI BT (B) Tj ET", "A B"},
+		{"unterminated string", "BT (Cut off", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractContent(tt.content, nil); got != tt.want {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testCMap maps two-byte codes with a bfchar, a bfrange with a start value
+// and a bfrange with an array of values
+const testCMap = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+/CMapName /Test def
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0003> <0041>
+<0004> <00660069>
+endbfchar
+2 beginbfrange
+<0010> <0012> <0061>
+<0020> <0021> [<00DF> <D83DDE00>]
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+func TestParseCMap(t *testing.T) {
+	font := &fontDecoder{}
+	font.parseCMap([]byte(testCMap))
+	if font.codeLength != 2 {
+		t.Errorf("code length = %d, want 2", font.codeLength)
+	}
+
+	got := font.decode("\x00\x03\x00\x04\x00\x10\x00\x12\x00\x20\x00\x21\x00\x99")
+	if want := "Afiacß😀�"; got != want {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+
+	text := extractContent("BT /F1 12 Tf <00030011> Tj /F2 12 Tf (A) Tj ET", map[string]*fontDecoder{"F1": font})
+	if text != "AbA" {
+		t.Errorf("extracted %q, want the CMap font's text followed by the other font's", text)
+	}
+}
+
+func TestFontDecoderSingleByte(t *testing.T) {
+	font := &fontDecoder{differences: map[byte]string{'A': "fi"}}
+	if got, want := font.decode("A\x93x\x94\x01"), "fi“x”�"; got != want {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+
+	for name, want := range map[string]string{"a": "a", "adieresis": "ä", "uni00E9": "é", "quotedblleft": "“"} {
+		if got, ok := glyphUnicode(name); !ok || got != want {
+			t.Errorf("glyphUnicode(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := glyphUnicode("g123"); ok {
+		t.Errorf("glyphUnicode accepted an unknown glyph name")
+	}
+}
+
+func TestHasUsableText(t *testing.T) {
+	sentence := "Mitochondria produce most of the ATP a cell needs."
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"empty page", "", false},
+		{"page number only", "  12 \n", false},
+		{"short text", "Figure 3", false},
+		{"readable text", sentence, true},
+		{"text with a few broken glyphs", sentence + "�", true},
+		{"mostly broken glyphs", sentence + strings.Repeat("�", 10), false},
+		{"private use glyphs", sentence + strings.Repeat("\uE000", 10), false},
+		{"mostly punctuation", "Table of contents" + strings.Repeat(" . . . . . . . .", 8) + " 12", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasUsableText(tt.text); got != tt.want {
+				t.Errorf("hasUsableText(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
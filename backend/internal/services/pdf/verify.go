@@ -0,0 +1,260 @@
+package pdf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jspohler/AnkiCards/backend/internal/services/anki"
+)
+
+// Verification modes check generated answers against their source chunk
+const (
+	VerifyOff = "off"
+	// VerifyLLM asks the model to judge every card of a chunk
+	VerifyLLM = "llm"
+	// VerifyLexical checks how much of an answer appears in the source
+	VerifyLexical = "lexical"
+)
+
+// Verification is the outcome of checking a card's answer against its
+// source chunk
+type Verification = anki.Verification
+
+// lexicalGroundedThreshold is the share of an answer's key terms that has to
+// appear in the source for the answer to count as grounded
+const lexicalGroundedThreshold = 0.6
+
+// stemRunes is the prefix length words are compared by, so that inflected
+// forms such as "convexity" and "convex" match
+const stemRunes = 6
+
+// verifyCardsPerRequest limits the cards judged in one request
+const verifyCardsPerRequest = 30
+
+var numberPattern = regexp.MustCompile(`\d+(?:[.,]\d+)?`)
+
+// verdictSchema is the structured output requested from the judge
+var verdictSchema = &ResponseSchema{
+	Name: "verdicts",
+	Schema: mustJSON(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"verdicts": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"card":          map[string]any{"type": "integer"},
+						"grounded":      map[string]any{"type": "boolean"},
+						"confidence":    map[string]any{"type": "number"},
+						"justification": map[string]any{"type": "string"},
+					},
+					"required":             []string{"card", "grounded", "confidence", "justification"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"verdicts"},
+		"additionalProperties": false,
+	}),
+}
+
+const verifySystemPrompt = `You check flashcards against the source text they were generated from. Judge only by the source text, not by outside knowledge.`
+
+const verifyInstructions = `For each flashcard below, decide whether its answer is supported by the source text. A card is grounded when the source states or directly implies its answer. It is not grounded when the answer contradicts the source or makes claims the source does not make. For cloze cards, the question is a statement whose {{c1::...}} deletions are the answer.
+
+Respond with a JSON object of this form and nothing else:
+{"verdicts": [{"card": 1, "grounded": true, "confidence": 0.9, "justification": "..."}]}
+
+"confidence" is between 0 and 1. Keep each justification to one sentence and quote the source where possible.`
+
+// jsonVerdict is a judgement as returned by the model
+type jsonVerdict struct {
+	Card          int     `json:"card"`
+	Grounded      bool    `json:"grounded"`
+	Confidence    float64 `json:"confidence"`
+	Justification string  `json:"justification"`
+}
+
+// validateVerify checks a job's verification mode
+func validateVerify(mode string) error {
+	switch mode {
+	case "", VerifyOff, VerifyLLM, VerifyLexical:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown verification mode %q", ErrInvalidGeneration, mode)
+}
+
+// minAnswerTerms is the number of key terms below which an answer is
+// checked together with its question, as in yes/no cards
+const minAnswerTerms = 2
+
+// verifyCards attaches a verification to every card generated from chunk.
+// Cards the judge fails on are checked lexically instead.
+func (s *Service) verifyCards(ctx context.Context, cards []Card, chunk Chunk, mode string) error {
+	if mode == VerifyLLM {
+		for start := 0; start < len(cards); start += verifyCardsPerRequest {
+			batch := cards[start:min(start+verifyCardsPerRequest, len(cards))]
+			if err := s.judgeCards(ctx, batch, chunk); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Warning: Verifying cards with the LLM failed, checking them lexically: %v", err)
+			}
+		}
+	}
+
+	source := stems(chunk.Text)
+	for i := range cards {
+		if cards[i].Verification == nil {
+			text := cards[i].Answer
+			if len(contentWords(text)) < minAnswerTerms {
+				text = cards[i].Question + " " + text
+			}
+			verification := lexicalVerification(text, chunk.Text, source)
+			cards[i].Verification = &verification
+		}
+	}
+	return nil
+}
+
+// judgeCards asks the model whether the source supports each card's answer
+func (s *Service) judgeCards(ctx context.Context, cards []Card, chunk Chunk) error {
+	var prompt strings.Builder
+	prompt.WriteString(verifyInstructions)
+	prompt.WriteString("\n\nFlashcards:\n")
+	for i, card := range cards {
+		fmt.Fprintf(&prompt, "%d. Q: %s\n   A: %s\n", i+1, card.Question, card.Answer)
+	}
+	prompt.WriteString("\nSource text:\n")
+	prompt.WriteString(chunk.Text)
+
+	var verdicts []jsonVerdict
+	err := s.complete(ctx, CompletionRequest{
+		System:      verifySystemPrompt,
+		Prompt:      prompt.String(),
+		MaxTokens:   max(1000, len(cards)*80),
+		Temperature: 0,
+		Schema:      verdictSchema,
+	}, func(content string) error {
+		var err error
+		verdicts, err = parseVerdicts(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, verdict := range verdicts {
+		if verdict.Card < 1 || verdict.Card > len(cards) {
+			continue
+		}
+		cards[verdict.Card-1].Verification = &Verification{
+			Grounded:      verdict.Grounded,
+			Confidence:    math.Max(0, math.Min(1, verdict.Confidence)),
+			Justification: strings.TrimSpace(verdict.Justification),
+			Method:        VerifyLLM,
+		}
+	}
+	return nil
+}
+
+// parseVerdicts reads the judge's response, ignoring text around the JSON
+func parseVerdicts(response string) ([]jsonVerdict, error) {
+	start := strings.Index(response, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON object in response")
+	}
+	var wrapper struct {
+		Verdicts []jsonVerdict `json:"verdicts"`
+	}
+	if err := json.NewDecoder(strings.NewReader(response[start:])).Decode(&wrapper); err != nil {
+		return nil, fmt.Errorf("failed to decode verdicts: %w", err)
+	}
+	if len(wrapper.Verdicts) == 0 {
+		return nil, fmt.Errorf("no verdicts found in response")
+	}
+	return wrapper.Verdicts, nil
+}
+
+// lexicalVerification estimates whether a card's text is grounded by the
+// share of its key terms found in the source. Numbers missing from the source
+// weigh heavily, as they are a common kind of invented detail.
+func lexicalVerification(text, sourceText string, source map[string]bool) Verification {
+	var found int
+	var missing []string
+	terms := make(map[string]bool)
+	for word := range contentWords(text) {
+		term := stem(word)
+		if terms[term] {
+			continue
+		}
+		terms[term] = true
+		if source[term] {
+			found++
+		} else {
+			missing = append(missing, word)
+		}
+	}
+
+	var missingNumbers []string
+	for _, number := range numberPattern.FindAllString(text, -1) {
+		if !strings.Contains(sourceText, number) && !containsString(missingNumbers, number) {
+			missingNumbers = append(missingNumbers, number)
+		}
+	}
+
+	if len(terms) == 0 && len(missingNumbers) == 0 {
+		return Verification{
+			Grounded:      true,
+			Confidence:    0.5,
+			Justification: "The answer is too short to check against the source.",
+			Method:        VerifyLexical,
+		}
+	}
+
+	confidence := 1.0
+	if len(terms) > 0 {
+		confidence = float64(found) / float64(len(terms))
+	}
+	justification := fmt.Sprintf("%d of %d key terms of the answer appear in the source", found, len(terms))
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		justification += "; not found: " + strings.Join(missing[:min(len(missing), 5)], ", ")
+	}
+	if len(missingNumbers) > 0 {
+		confidence /= 2
+		justification += "; numbers not in the source: " + strings.Join(missingNumbers, ", ")
+	}
+
+	return Verification{
+		Grounded:      confidence >= lexicalGroundedThreshold,
+		Confidence:    math.Round(confidence*100) / 100,
+		Justification: justification + ".",
+		Method:        VerifyLexical,
+	}
+}
+
+// stems returns the stems of the content words of text
+func stems(text string) map[string]bool {
+	result := make(map[string]bool)
+	for word := range contentWords(text) {
+		result[stem(word)] = true
+	}
+	return result
+}
+
+// stem cuts a word to its first few letters
+func stem(word string) string {
+	if utf8.RuneCountInString(word) > stemRunes {
+		return string([]rune(word)[:stemRunes])
+	}
+	return word
+}
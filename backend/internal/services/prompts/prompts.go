@@ -0,0 +1,347 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DefaultTemplate is used for jobs that do not select a template
+const DefaultTemplate = "default"
+
+// Template sections. "system", "chunk" and "topics" are required;
+// "topics_system" falls back to "system" and "description" is optional.
+const (
+	sectionDescription  = "description"
+	sectionSystem       = "system"
+	sectionChunk        = "chunk"
+	sectionTopics       = "topics"
+	sectionTopicsSystem = "topics_system"
+)
+
+//go:embed presets/*.tmpl
+var presets embed.FS
+
+var (
+	ErrNotFound    = errors.New("template not found")
+	ErrExists      = errors.New("template already exists")
+	ErrInvalidName = errors.New("template names may only contain lowercase letters, digits, '-' and '_'")
+	// ErrInvalidTemplate wraps errors of template content that does not
+	// parse or render
+	ErrInvalidTemplate = errors.New("invalid template")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Template is a prompt template as shown to API clients
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Builtin marks the presets shipped with the server
+	Builtin bool `json:"builtin"`
+	// Modified marks presets that were overridden by an edited copy
+	Modified bool   `json:"modified,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// Data is what templates are rendered with
+type Data struct {
+	// Count is the number of cards to create
+	Count int
+	// Part and Parts locate the chunk within the document
+	Part  int
+	Parts int
+	// Pages is the chunk's page range such as "4" or "4-6", and Section the
+	// heading it starts under; both may be empty
+	Pages   string
+	Section string
+	// Text is the source text of the chunk, or an excerpt of the
+	// document for topic cards
+	Text string
+	// Focus tells the model how thoroughly to cover the text; it is empty
+	// for the balanced density mode
+	Focus string
+	// Format describes the expected response format
+	Format string
+}
+
+// Prompt is a rendered system and user message pair
+type Prompt struct {
+	System string
+	User   string
+}
+
+// Service loads prompt templates. Presets are built in; templates created
+// or edited through the API are stored as <name>.tmpl files in dir, where an
+// edited preset shadows the built-in version.
+type Service struct {
+	dir    string
+	mu     sync.RWMutex
+	parsed map[string]*template.Template
+}
+
+// NewService creates a prompt template service storing templates in dir
+func NewService(dir string) (*Service, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create templates directory: %w", err)
+	}
+	return &Service{
+		dir:    dir,
+		parsed: make(map[string]*template.Template),
+	}, nil
+}
+
+// List returns every available template without its content
+func (s *Service) List() ([]Template, error) {
+	names := make(map[string]bool)
+
+	entries, err := presets.ReadDir("presets")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list presets: %w", err)
+	}
+	for _, entry := range entries {
+		names[strings.TrimSuffix(entry.Name(), ".tmpl")] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		if namePattern.MatchString(name) {
+			names[name] = true
+		}
+	}
+
+	templates := make([]Template, 0, len(names))
+	for name := range names {
+		tmpl, err := s.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Content = ""
+		templates = append(templates, *tmpl)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// Get returns a template including its content
+func (s *Service) Get(name string) (*Template, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(name)
+}
+
+// get is Get for callers holding the lock
+func (s *Service) get(name string) (*Template, error) {
+	content, builtin, modified, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &Template{
+		Name:     name,
+		Builtin:  builtin,
+		Modified: modified,
+		Content:  content,
+	}
+	if parsed, err := parse(name, content); err == nil && parsed.Lookup(sectionDescription) != nil {
+		var buf bytes.Buffer
+		if err := parsed.ExecuteTemplate(&buf, sectionDescription, Data{}); err == nil {
+			tmpl.Description = strings.TrimSpace(buf.String())
+		}
+	}
+	return tmpl, nil
+}
+
+// Exists reports whether a template can be used for a job
+func (s *Service) Exists(name string) bool {
+	_, err := s.Get(name)
+	return err == nil
+}
+
+// Create stores a new template
+func (s *Service) Create(name, content string) (*Template, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	// The lock is held from the existence check to the write, so two
+	// requests cannot both create the same template
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, _, _, err := s.read(name); err == nil {
+		return nil, ErrExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return s.save(name, content)
+}
+
+// Update replaces the content of a template. Editing a preset stores an
+// edited copy that takes precedence over the built-in version.
+func (s *Service) Update(name, content string) (*Template, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, _, _, err := s.read(name); err != nil {
+		return nil, err
+	}
+	return s.save(name, content)
+}
+
+// save validates and writes a template; the caller holds the lock
+func (s *Service) save(name, content string) (*Template, error) {
+	if err := Validate(name, content); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(s.dir, name+".tmpl")
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, []byte(content), 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	delete(s.parsed, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+
+	return s.get(name)
+}
+
+// read returns the content of a template, preferring a stored copy over
+// the built-in preset
+func (s *Service) read(name string) (content string, builtin, modified bool, err error) {
+	preset, presetErr := presets.ReadFile("presets/" + name + ".tmpl")
+	builtin = presetErr == nil
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name+".tmpl"))
+	switch {
+	case err == nil:
+		return string(data), builtin, builtin, nil
+	case !os.IsNotExist(err):
+		return "", false, false, fmt.Errorf("failed to read template: %w", err)
+	case builtin:
+		return string(preset), true, false, nil
+	default:
+		return "", false, false, ErrNotFound
+	}
+}
+
+// Validate checks that content parses, defines the required sections and
+// renders with sample data
+func Validate(name, content string) error {
+	parsed, err := parse(name, content)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	for _, section := range []string{sectionSystem, sectionChunk, sectionTopics} {
+		if parsed.Lookup(section) == nil {
+			return fmt.Errorf("%w: missing {{define %q}} section", ErrInvalidTemplate, section)
+		}
+	}
+
+	sample := Data{Count: 3, Part: 1, Parts: 2, Pages: "4-6", Section: "Sample section", Text: "Sample text.", Focus: "Sample focus.", Format: "Sample format."}
+	if _, err := render(parsed, sectionSystem, sectionChunk, sample); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if _, err := render(parsed, sectionTopicsSystem, sectionTopics, sample); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return nil
+}
+
+// RenderChunk renders the prompt for generating cards from one chunk
+func (s *Service) RenderChunk(name string, data Data) (Prompt, error) {
+	parsed, err := s.load(name)
+	if err != nil {
+		return Prompt{}, err
+	}
+	return render(parsed, sectionSystem, sectionChunk, data)
+}
+
+// RenderTopics renders the prompt for generating document-level topic cards
+func (s *Service) RenderTopics(name string, data Data) (Prompt, error) {
+	parsed, err := s.load(name)
+	if err != nil {
+		return Prompt{}, err
+	}
+	return render(parsed, sectionTopicsSystem, sectionTopics, data)
+}
+
+// load returns the parsed template, caching it until the template is saved
+func (s *Service) load(name string) (*template.Template, error) {
+	if name == "" {
+		name = DefaultTemplate
+	}
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	parsed, ok := s.parsed[name]
+	s.mu.RUnlock()
+	if ok {
+		return parsed, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, _, _, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err = parse(name, content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	s.parsed[name] = parsed
+	return parsed, nil
+}
+
+func parse(name, content string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(content)
+}
+
+// render executes a system and user section. A missing system section
+// falls back to the general "system" section.
+func render(parsed *template.Template, system, user string, data Data) (Prompt, error) {
+	if parsed.Lookup(system) == nil {
+		system = sectionSystem
+	}
+
+	var prompt Prompt
+	var buf bytes.Buffer
+	if err := parsed.ExecuteTemplate(&buf, system, data); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render %s prompt: %w", system, err)
+	}
+	prompt.System = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := parsed.ExecuteTemplate(&buf, user, data); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render %s prompt: %w", user, err)
+	}
+	prompt.User = strings.TrimSpace(buf.String())
+	return prompt, nil
+}
@@ -0,0 +1,271 @@
+package prompts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// minimal is a valid template with every required section
+const minimal = `{{define "description"}}Minimal{{end}}
+{{define "system"}}System{{end}}
+{{define "chunk"}}Create {{.Count}} cards from part {{.Part}}/{{.Parts}}{{with .Section}} ({{.}}){{end}}: {{.Text}}{{end}}
+{{define "topics"}}Create {{.Count}} topic cards: {{.Text}}{{end}}`
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	s, err := NewService(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return s
+}
+
+func TestPresets(t *testing.T) {
+	s := newTestService(t)
+
+	templates, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	names := make(map[string]Template)
+	for _, tmpl := range templates {
+		names[tmpl.Name] = tmpl
+	}
+	for _, name := range []string{DefaultTemplate, "language", "law", "medicine", "programming"} {
+		tmpl, ok := names[name]
+		if !ok {
+			t.Errorf("preset %s is not listed", name)
+			continue
+		}
+		if !tmpl.Builtin || tmpl.Modified || tmpl.Description == "" || tmpl.Content != "" {
+			t.Errorf("preset %s listed as %+v", name, tmpl)
+		}
+	}
+
+	// Every preset has to pass the checks applied to edited templates
+	entries, err := presets.ReadDir("presets")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, entry := range entries {
+		content, err := presets.ReadFile("presets/" + entry.Name())
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if err := Validate(entry.Name(), string(content)); err != nil {
+			t.Errorf("preset %s: %v", entry.Name(), err)
+		}
+	}
+
+	// Jobs without a template use the default preset
+	prompt, err := s.RenderChunk("", Data{Count: 4, Part: 2, Parts: 3, Pages: "5-7", Text: "Osmosis"})
+	if err != nil {
+		t.Fatalf("RenderChunk: %v", err)
+	}
+	if !strings.Contains(prompt.User, "Create 4 high-quality") || !strings.Contains(prompt.User, "part 2 of 3 from the document, pages 5-7.") ||
+		!strings.HasSuffix(prompt.User, "Osmosis") || !strings.HasPrefix(prompt.System, "You are an expert educator") {
+		t.Errorf("default chunk prompt = %+v", prompt)
+	}
+}
+
+func TestOverridePreset(t *testing.T) {
+	s := newTestService(t)
+	if _, err := s.RenderChunk(DefaultTemplate, Data{}); err != nil {
+		t.Fatalf("RenderChunk: %v", err)
+	}
+
+	tmpl, err := s.Update(DefaultTemplate, minimal)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !tmpl.Builtin || !tmpl.Modified || tmpl.Description != "Minimal" || tmpl.Content != minimal {
+		t.Errorf("edited preset = %+v", tmpl)
+	}
+
+	// The cached preset is replaced by the edited copy
+	prompt, err := s.RenderChunk("", Data{Count: 2, Part: 1, Parts: 1, Text: "ATP"})
+	if err != nil {
+		t.Fatalf("RenderChunk: %v", err)
+	}
+	if want := (Prompt{System: "System", User: "Create 2 cards from part 1/1: ATP"}); prompt != want {
+		t.Errorf("RenderChunk = %+v, want %+v", prompt, want)
+	}
+
+	// Removing the copy restores the built-in version
+	if err := os.Remove(filepath.Join(s.dir, DefaultTemplate+".tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err = s.Get(DefaultTemplate)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if tmpl.Modified || tmpl.Content == minimal {
+		t.Errorf("preset without its copy = %+v", tmpl)
+	}
+}
+
+func TestCreateAndUpdate(t *testing.T) {
+	s := newTestService(t)
+
+	tmpl, err := s.Create("biology", minimal)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if tmpl.Builtin || tmpl.Modified || tmpl.Description != "Minimal" {
+		t.Errorf("created template = %+v", tmpl)
+	}
+	if !s.Exists("biology") {
+		t.Errorf("created template does not exist")
+	}
+
+	if _, err := s.Create("biology", minimal); !errors.Is(err, ErrExists) {
+		t.Errorf("Create of an existing template = %v, want ErrExists", err)
+	}
+	if _, err := s.Create(DefaultTemplate, minimal); !errors.Is(err, ErrExists) {
+		t.Errorf("Create of a preset = %v, want ErrExists", err)
+	}
+	if _, err := s.Update("chemistry", minimal); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a missing template = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Update("biology", "{{define \"system\"}}"); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Update with invalid content = %v, want ErrInvalidTemplate", err)
+	}
+
+	updated := strings.Replace(minimal, "Minimal", "Updated", 1)
+	if tmpl, err = s.Update("biology", updated); err != nil || tmpl.Description != "Updated" {
+		t.Errorf("Update = %+v, %v", tmpl, err)
+	}
+
+	// Only one of several concurrent creations of a name succeeds
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Create("chemistry", minimal); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrExists) {
+				t.Errorf("Create: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("%d concurrent creations succeeded, want 1", created)
+	}
+}
+
+func TestNames(t *testing.T) {
+	s := newTestService(t)
+	for _, name := range []string{"", "Biology", "../default", "a b", "-lead", strings.Repeat("a", 65)} {
+		if _, err := s.Create(name, minimal); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Create(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if _, err := s.Get(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", name, err)
+		}
+		if _, err := s.Update(name, minimal); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update(%q) = %v, want ErrNotFound", name, err)
+		}
+	}
+	for _, name := range []string{"bio", "bio-chem_2", "0", strings.Repeat("a", 64)} {
+		if _, err := s.Create(name, minimal); err != nil {
+			t.Errorf("Create(%q): %v", name, err)
+		}
+	}
+	if _, err := s.RenderChunk("../default", Data{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderChunk of an invalid name = %v, want ErrNotFound", err)
+	}
+	if _, err := s.RenderTopics("missing", Data{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderTopics of a missing template = %v, want ErrNotFound", err)
+	}
+
+	// Files in the directory whose names are not template names are ignored
+	if err := os.WriteFile(filepath.Join(s.dir, "Notes.tmpl"), []byte(minimal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, tmpl := range templates {
+		if tmpl.Name == "Notes" {
+			t.Errorf("List includes a file with an invalid name")
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"minimal", minimal, true},
+		{"topics system section", minimal + `{{define "topics_system"}}Topics{{end}}`, true},
+		{"syntax error", `{{define "system"}}{{.Count{{end}}`, false},
+		{"unclosed define", `{{define "system"}}System`, false},
+		{"missing system", strings.Replace(minimal, `"system"`, `"other"`, 1), false},
+		{"missing chunk", strings.Replace(minimal, `"chunk"`, `"other"`, 1), false},
+		{"missing topics", strings.Replace(minimal, `"topics"`, `"other"`, 1), false},
+		{"unknown field", minimal + `{{define "topics_system"}}{{.Language}}{{end}}`, false},
+		{"unknown function", strings.Replace(minimal, "{{.Text}}", "{{upper .Text}}", 1), false},
+		{"failing render", strings.Replace(minimal, "{{.Text}}", "{{index .Text 99}}", 1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.name, tt.content)
+			if tt.valid && err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidTemplate) {
+				t.Errorf("Validate = %v, want ErrInvalidTemplate", err)
+			}
+		})
+	}
+}
+
+func TestRenderTopics(t *testing.T) {
+	s := newTestService(t)
+	if _, err := s.Create("plain", minimal); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Create("custom", minimal+`{{define "topics_system"}}Topics for {{.Count}}{{end}}`); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	data := Data{Count: 3, Text: "Cells"}
+	tests := []struct {
+		name string
+		want Prompt
+	}{
+		// Without a topics system section the general one is used
+		{"plain", Prompt{System: "System", User: "Create 3 topic cards: Cells"}},
+		{"custom", Prompt{System: "Topics for 3", User: "Create 3 topic cards: Cells"}},
+	}
+	for _, tt := range tests {
+		got, err := s.RenderTopics(tt.name, data)
+		if err != nil {
+			t.Fatalf("RenderTopics(%s): %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("RenderTopics(%s) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	prompt, err := s.RenderChunk("custom", Data{Count: 1, Part: 1, Parts: 2, Section: "Mitosis", Text: "Prophase"})
+	if err != nil {
+		t.Fatalf("RenderChunk: %v", err)
+	}
+	if want := (Prompt{System: "System", User: "Create 1 cards from part 1/2 (Mitosis): Prophase"}); prompt != want {
+		t.Errorf("RenderChunk = %+v, want %+v", prompt, want)
+	}
+}
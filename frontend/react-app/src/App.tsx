@@ -5,6 +5,7 @@ import FileUpload from './components/FileUpload';
 import ProcessingStatus from './components/ProcessingStatus';
 import CardReview from './components/CardReview';
 import DeckList from './components/DeckList';
+import Study from './components/Study';
 
 function App() {
     return (
@@ -14,6 +15,7 @@ function App() {
                 <Route path="/status/:jobId" element={<ProcessingStatus />} />
                 <Route path="/review/:deckName" element={<CardReview />} />
                 <Route path="/decks" element={<DeckList />} />
+                <Route path="/study/:deckId" element={<Study />} />
             </Routes>
         </Layout>
     );
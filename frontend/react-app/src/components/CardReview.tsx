@@ -1,9 +1,12 @@
 import React, { useState, useEffect } from 'react';
 import {
+  Alert,
   Box,
     Button,
   Card,
   CardContent,
+  Checkbox,
+  FormControlLabel,
     TextField,
   Typography,
   IconButton,
@@ -14,11 +17,54 @@ import NavigateNextIcon from '@mui/icons-material/NavigateNext';
 import NavigateBeforeIcon from '@mui/icons-material/NavigateBefore';
 import { useParams } from 'react-router-dom';
 
+interface CardSource {
+  file?: string;
+  pages?: string;
+  section?: string;
+  excerpt?: string;
+}
+
+interface CardDuplicate {
+  of: string;
+  deck?: string;
+  kind: string;
+  similarity: number;
+}
+
+interface CardVerification {
+  grounded: boolean;
+  confidence: number;
+  justification?: string;
+  method: string;
+}
+
 interface FlashCard {
+  id?: string;
   question: string;
   answer: string;
+  type?: string;
+  noteType?: string;
+  addReverse?: boolean;
+  tags?: string[];
+  subdeck?: string;
+  source?: CardSource;
+  duplicate?: CardDuplicate;
+  verification?: CardVerification;
 }
 
+const formatSource = (source: CardSource) =>
+    [source.file, source.pages && `p. ${source.pages}`, source.section]
+        .filter(Boolean)
+        .join(', ');
+
+// Ungrounded cards come first, least confident first, so they are reviewed
+// before the rest
+const ungroundedFirst = (a: FlashCard, b: FlashCard) => {
+    const rank = (card: FlashCard) =>
+        card.verification && !card.verification.grounded ? card.verification.confidence : 2;
+    return rank(a) - rank(b);
+};
+
 export const CardReview: React.FC = () => {
     const { deckName } = useParams<{ deckName: string }>();
     const [cards, setCards] = useState<FlashCard[]>([]);
@@ -60,7 +106,7 @@ export const CardReview: React.FC = () => {
                 throw new Error('Invalid response format');
             }
             
-            setCards(data.cards);
+            setCards([...data.cards].sort(ungroundedFirst));
             setError(null);
         } catch (error) {
             const errorMessage = error instanceof Error ? error.message : 'Unknown error occurred';
@@ -180,9 +226,26 @@ export const CardReview: React.FC = () => {
 
             <Card sx={{ mb: 3, minHeight: 200 }}>
                   <CardContent>
+                    {currentCard.duplicate && (
+                        <Alert severity="warning" sx={{ mb: 2 }}>
+                            Possible {currentCard.duplicate.kind} duplicate
+                            {currentCard.duplicate.kind === 'semantic' &&
+                                ` (${Math.round(currentCard.duplicate.similarity * 100)}% similar)`}
+                            {' of '}“{currentCard.duplicate.of}”
+                            {currentCard.duplicate.deck && ` in deck ${currentCard.duplicate.deck}`}
+                        </Alert>
+                    )}
+                    {currentCard.verification && !currentCard.verification.grounded && (
+                        <Alert severity="error" sx={{ mb: 2 }}>
+                            Answer may not be supported by the source
+                            {` (${Math.round(currentCard.verification.confidence * 100)}% confidence)`}
+                            {currentCard.verification.justification &&
+                                `: ${currentCard.verification.justification}`}
+                        </Alert>
+                    )}
                     <Box mb={2}>
                         <Typography variant="subtitle1" color="textSecondary" gutterBottom>
-                            Question:
+                            {currentCard.type === 'cloze' ? 'Cloze text ({{c1::...}} marks hidden parts):' : 'Question:'}
                         </Typography>
                         <TextField
                             fullWidth
@@ -200,7 +263,7 @@ export const CardReview: React.FC = () => {
 
                     <Box>
                         <Typography variant="subtitle1" color="textSecondary" gutterBottom>
-                            Answer:
+                            {currentCard.type === 'cloze' ? 'Back extra:' : 'Answer:'}
                         </Typography>
                         <TextField
                             fullWidth
@@ -215,6 +278,72 @@ export const CardReview: React.FC = () => {
                             }
                         />
                     </Box>
+
+                    <Box mt={2}>
+                        <TextField
+                            fullWidth
+                            size="small"
+                            label="Tags (separated by spaces)"
+                            value={(currentCard.tags ?? []).join(' ')}
+                            onChange={(e) =>
+                                handleUpdateCard(currentIndex, {
+                                    ...currentCard,
+                                    tags: e.target.value.split(' '),
+                                })
+                            }
+                            onBlur={() =>
+                                handleUpdateCard(currentIndex, {
+                                    ...currentCard,
+                                    tags: (currentCard.tags ?? []).filter(Boolean),
+                                })
+                            }
+                        />
+                        {currentCard.subdeck && (
+                            <Typography variant="caption" color="textSecondary" display="block" mt={0.5}>
+                                Subdeck: {deckName}::{currentCard.subdeck}
+                            </Typography>
+                        )}
+                    </Box>
+
+                    {currentCard.noteType === 'optional_reversed' && currentCard.type !== 'cloze' && (
+                        <FormControlLabel
+                            sx={{ mt: 1 }}
+                            control={
+                                <Checkbox
+                                    checked={!!currentCard.addReverse}
+                                    onChange={(e) =>
+                                        handleUpdateCard(currentIndex, {
+                                            ...currentCard,
+                                            addReverse: e.target.checked,
+                                        })
+                                    }
+                                />
+                            }
+                            label="Also ask in reverse (answer → question)"
+                        />
+                    )}
+                    {currentCard.noteType === 'reversed' && currentCard.type !== 'cloze' && (
+                        <Typography variant="caption" color="textSecondary" display="block" mt={1}>
+                            Asked in both directions
+                        </Typography>
+                    )}
+
+                    {currentCard.source && (
+                        <Box mt={2}>
+                            <Typography variant="caption" color="textSecondary">
+                                Source: {formatSource(currentCard.source)}
+                            </Typography>
+                            {currentCard.source.excerpt && (
+                                <Typography
+                                    variant="body2"
+                                    color="textSecondary"
+                                    sx={{ fontStyle: 'italic', mt: 0.5 }}
+                                >
+                                    “{currentCard.source.excerpt}”
+                                </Typography>
+                            )}
+                        </Box>
+                    )}
                 </CardContent>
             </Card>
 
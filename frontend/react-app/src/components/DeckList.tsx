@@ -8,19 +8,34 @@ import {
     ListItemText,
     Button,
     CircularProgress,
+    Menu,
+    MenuItem,
 } from '@mui/material';
 import { useNavigate } from 'react-router-dom';
 import EditIcon from '@mui/icons-material/Edit';
 import DownloadIcon from '@mui/icons-material/Download';
+import SchoolIcon from '@mui/icons-material/School';
+import UploadFileIcon from '@mui/icons-material/UploadFile';
+
+const exportFormats = [
+    { format: 'apkg', label: 'Anki package (.apkg)' },
+    { format: 'csv', label: 'CSV' },
+    { format: 'txt', label: 'Anki plain text (.txt)' },
+    { format: 'tsv', label: 'TSV' },
+    { format: 'json', label: 'JSON' },
+];
 
 interface Deck {
+    id: string;
     name: string;
     totalCards: number;
+    updated: string;
 }
 
 export default function DeckList() {
     const [decks, setDecks] = useState<Deck[]>([]);
     const [loading, setLoading] = useState(true);
+    const [menu, setMenu] = useState<{ anchor: HTMLElement; deck: Deck } | null>(null);
     const navigate = useNavigate();
 
     useEffect(() => {
@@ -29,15 +44,10 @@ export default function DeckList() {
 
     const fetchDecks = async () => {
         try {
-            // Get list of CSV files from the cards directory
+            // Get the decks of the card store
             const response = await fetch('/api/cards/list');
             const data = await response.json();
-            // Ensure we only use the base name of each deck
-            const processedDecks = data.decks.map((deck: { name: string; totalCards: number }) => ({
-                ...deck,
-                name: deck.name.split('/').pop() || deck.name // Get just the filename without path
-            }));
-            setDecks(processedDecks);
+            setDecks(data.decks);
             setLoading(false);
         } catch (error) {
             console.error('Error fetching decks:', error);
@@ -45,39 +55,26 @@ export default function DeckList() {
         }
     };
 
-    const handleEdit = (deckName: string) => {
-        console.log('Editing deck:', deckName); // Debug log
-        // Get just the filename without path and extension
-        const baseName = deckName.split('/').pop()?.replace('.csv', '') || deckName;
-        console.log('Base name:', baseName); // Debug log
-        const encodedDeckName = encodeURIComponent(baseName);
-        console.log('Encoded deck name:', encodedDeckName); // Debug log
-        const reviewPath = `/review/${encodedDeckName}`;
+    const handleEdit = (deckId: string) => {
+        console.log('Editing deck:', deckId); // Debug log
+        const reviewPath = `/review/${encodeURIComponent(deckId)}`;
         console.log('Navigating to:', reviewPath); // Debug log
         navigate(reviewPath);
     };
 
-    const handleDownload = async (deckName: string) => {
+    const handleDownload = async (deck: Deck, format: string) => {
+        setMenu(null);
         try {
-            // Get just the filename without path and extension
-            const baseName = deckName.split('/').pop()?.replace('.csv', '') || deckName;
-            const encodedDeckName = encodeURIComponent(baseName);
-            console.log('Downloading deck:', baseName); // Debug log
+            console.log('Downloading deck:', deck.id, format); // Debug log
             
-            const response = await fetch(`/api/cards/apkg/${encodedDeckName}`, {
+            const response = await fetch(`/api/decks/${encodeURIComponent(deck.id)}/export?format=${format}`, {
                 method: 'GET',
             });
             
             if (!response.ok) {
                 const errorText = await response.text();
-                console.error('Failed to generate Anki deck:', response.status, errorText);
-                throw new Error(`Failed to generate Anki deck: ${errorText}`);
-            }
-
-            // Check if we got an actual file
-            const contentType = response.headers.get('content-type');
-            if (!contentType || !contentType.includes('application/octet-stream')) {
-                throw new Error('Invalid response format from server');
+                console.error('Failed to export deck:', response.status, errorText);
+                throw new Error(`Failed to export deck: ${errorText}`);
             }
 
             // Create a blob from the response
@@ -90,7 +87,7 @@ export default function DeckList() {
             const url = window.URL.createObjectURL(blob);
             const a = document.createElement('a');
             a.href = url;
-            a.download = `${baseName}.apkg`;
+            a.download = `${deck.name}.${format}`;
             document.body.appendChild(a);
             a.click();
             document.body.removeChild(a);
@@ -103,6 +100,37 @@ export default function DeckList() {
         }
     };
 
+    const handleImport = async (event: React.ChangeEvent<HTMLInputElement>) => {
+        const file = event.target.files?.[0];
+        event.target.value = '';
+        if (!file) {
+            return;
+        }
+        try {
+            const formData = new FormData();
+            formData.append('file', file);
+            const response = await fetch('/api/import/apkg', {
+                method: 'POST',
+                body: formData,
+            });
+            if (!response.ok) {
+                const data = await response.json();
+                throw new Error(data.error || response.statusText);
+            }
+            await fetchDecks();
+        } catch (error) {
+            console.error('Error importing deck:', error);
+            alert('Failed to import deck: ' + (error instanceof Error ? error.message : 'Unknown error'));
+        }
+    };
+
+    const importButton = (
+        <Button component="label" startIcon={<UploadFileIcon />}>
+            Import .apkg
+            <input type="file" accept=".apkg,.colpkg" hidden onChange={handleImport} />
+        </Button>
+    );
+
     if (loading) {
         return (
             <Box display="flex" justifyContent="center" alignItems="center" minHeight="400px">
@@ -116,38 +144,47 @@ export default function DeckList() {
             <Box textAlign="center" p={4}>
                 <Typography variant="h6">No decks available</Typography>
                 <Typography variant="body1" color="textSecondary">
-                    Upload some PDF files first to generate flashcards.
+                    Upload some PDF files first to generate flashcards, or import an Anki deck.
                 </Typography>
+                <Box mt={2}>{importButton}</Box>
             </Box>
         );
     }
 
     return (
         <Box sx={{ maxWidth: 800, mx: 'auto', p: 3 }}>
-            <Typography variant="h4" gutterBottom>
-                Available Decks
-            </Typography>
+            <Box display="flex" justifyContent="space-between" alignItems="center" mb={1}>
+                <Typography variant="h4">Available Decks</Typography>
+                {importButton}
+            </Box>
             <Paper>
                 <List>
                     {decks.map((deck) => (
                         <ListItem
-                            key={deck.name}
+                            key={deck.id}
                             divider
                             secondaryAction={
                                 <Box>
                                     <Button
                                         startIcon={<EditIcon />}
                                         onClick={() => {
-                                            console.log('Edit button clicked for deck:', deck.name); // Debug log
-                                            handleEdit(deck.name);
+                                            console.log('Edit button clicked for deck:', deck.id); // Debug log
+                                            handleEdit(deck.id);
                                         }}
                                         sx={{ mr: 1 }}
                                     >
                                         Edit
                                     </Button>
+                                    <Button
+                                        startIcon={<SchoolIcon />}
+                                        onClick={() => navigate(`/study/${encodeURIComponent(deck.id)}`)}
+                                        sx={{ mr: 1 }}
+                                    >
+                                        Study
+                                    </Button>
                                     <Button
                                         startIcon={<DownloadIcon />}
-                                        onClick={() => handleDownload(deck.name)}
+                                        onClick={(event) => setMenu({ anchor: event.currentTarget, deck })}
                                         color="primary"
                                     >
                                         Download
@@ -157,12 +194,19 @@ export default function DeckList() {
                         >
                             <ListItemText
                                 primary={deck.name}
-                                secondary={`${deck.totalCards} cards`}
+                                secondary={`${deck.totalCards} cards, updated ${new Date(deck.updated).toLocaleString()}`}
                             />
                         </ListItem>
                     ))}
                 </List>
             </Paper>
+            <Menu anchorEl={menu?.anchor} open={menu !== null} onClose={() => setMenu(null)}>
+                {exportFormats.map(({ format, label }) => (
+                    <MenuItem key={format} onClick={() => menu && handleDownload(menu.deck, format)}>
+                        {label}
+                    </MenuItem>
+                ))}
+            </Menu>
         </Box>
     );
 } 
\ No newline at end of file
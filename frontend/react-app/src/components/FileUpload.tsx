@@ -26,7 +26,15 @@ export default function FileUpload() {
   const { getRootProps, getInputProps, isDragActive } = useDropzone({
     onDrop,
     accept: {
-      'application/pdf': ['.pdf']
+      'application/pdf': ['.pdf'],
+      'application/vnd.openxmlformats-officedocument.wordprocessingml.document': ['.docx'],
+      'application/vnd.openxmlformats-officedocument.presentationml.presentation': ['.pptx'],
+      'application/epub+zip': ['.epub'],
+      'text/html': ['.html', '.htm'],
+      'text/markdown': ['.md', '.markdown'],
+      'text/plain': ['.txt'],
+      'image/png': ['.png'],
+      'image/jpeg': ['.jpg', '.jpeg']
     }
   });
 
@@ -59,7 +67,7 @@ export default function FileUpload() {
   return (
     <Box sx={{ maxWidth: 600, mx: 'auto' }}>
       <Typography variant="h4" gutterBottom align="center">
-        Upload PDF Files
+        Upload Files
       </Typography>
       
       <Paper sx={{ p: 3, mb: 3 }}>
@@ -78,7 +86,7 @@ export default function FileUpload() {
           <Typography align="center">
             {isDragActive
               ? 'Drop the files here...'
-              : 'Drag and drop PDF files here, or click to select files'}
+              : 'Drag and drop PDF, Word, PowerPoint, EPUB, HTML, Markdown, text or image files here, or click to select files'}
           </Typography>
         </Box>
 
@@ -9,7 +9,7 @@ import {
 } from '@mui/material';
 
 interface ProcessingStatus {
-    status: 'processing' | 'completed' | 'failed';
+    status: 'processing' | 'completed' | 'partial' | 'failed';
     progress: number;
     message: string;
     deckName?: string;
@@ -43,15 +43,15 @@ export default function ProcessingStatus() {
 
                 setStatus(data);
 
-                if (data.status === 'completed') {
+                if (data.status === 'completed' || data.status === 'partial') {
                     // Extract filename without extension to use as deck name
                     const deckName = data.deckName || data.filename?.replace(/\.[^/.]+$/, '') || 'default';
                     
                     // Short delay to show completion message
                     setTimeout(() => {
                         navigate(`/review/${deckName}`);
-                    }, 1500);
-                } else if (data.status === 'processing') {
+                    }, data.status === 'partial' ? 4000 : 1500);
+                } else if (data.status === 'processing' || data.status === 'pending') {
                     // Continue polling
                     setTimeout(checkStatus, 2000);
                 }
@@ -113,6 +113,17 @@ export default function ProcessingStatus() {
                     </Alert>
                 )}
 
+                {status.status === 'partial' && (
+                    <Alert severity="warning" sx={{ mt: 2 }}>
+                        Some files could not be processed. Redirecting to card review...
+                        {status.error && (
+                            <Typography variant="body2" sx={{ mt: 1 }}>
+                                Error: {status.error}
+                            </Typography>
+                        )}
+                    </Alert>
+                )}
+
                 {status.status === 'failed' && (
                     <Alert severity="error" sx={{ mt: 2 }}>
                         Processing failed. Please try again.
@@ -0,0 +1,186 @@
+import React, { useState, useEffect } from 'react';
+import {
+    Box,
+    Button,
+    Card,
+    CardContent,
+    CircularProgress,
+    Stack,
+    Typography,
+} from '@mui/material';
+import { useParams } from 'react-router-dom';
+
+interface ReviewState {
+    phase: string;
+    due: string;
+}
+
+interface StudyCard {
+    id: string;
+    question: string;
+    answer: string;
+    type?: string;
+    review?: ReviewState;
+}
+
+type Rating = 'again' | 'hard' | 'good' | 'easy';
+
+const ratings: { rating: Rating; label: string; color: 'error' | 'warning' | 'primary' | 'success' }[] = [
+    { rating: 'again', label: 'Again', color: 'error' },
+    { rating: 'hard', label: 'Hard', color: 'warning' },
+    { rating: 'good', label: 'Good', color: 'primary' },
+    { rating: 'easy', label: 'Easy', color: 'success' },
+];
+
+const clozePattern = /\{\{c\d+::(.*?)(?:::(.*?))?\}\}/g;
+
+// Cloze cards hide every deletion on the front, showing its hint if any
+const front = (card: StudyCard) =>
+    card.type === 'cloze'
+        ? card.question.replace(clozePattern, (_, _text, hint) => `[${hint || '...'}]`)
+        : card.question;
+
+const back = (card: StudyCard) =>
+    card.type === 'cloze'
+        ? [card.question.replace(clozePattern, (_, text) => text), card.answer].filter(Boolean).join('\n\n')
+        : card.answer;
+
+export const Study: React.FC = () => {
+    const { deckId } = useParams<{ deckId: string }>();
+    const [queue, setQueue] = useState<StudyCard[]>([]);
+    const [showAnswer, setShowAnswer] = useState(false);
+    const [loading, setLoading] = useState(true);
+    const [submitting, setSubmitting] = useState(false);
+    const [reviewed, setReviewed] = useState(0);
+    const [error, setError] = useState<string | null>(null);
+
+    useEffect(() => {
+        fetchDue();
+    }, [deckId]);
+
+    const fetchDue = async () => {
+        if (!deckId) {
+            return;
+        }
+        setLoading(true);
+        try {
+            const response = await fetch(`/api/decks/${encodeURIComponent(deckId)}/due`);
+            if (!response.ok) {
+                throw new Error(`Failed to fetch due cards: ${await response.text()}`);
+            }
+            const data = await response.json();
+            setQueue(data.cards || []);
+            setShowAnswer(false);
+            setError(null);
+        } catch (error) {
+            setError(error instanceof Error ? error.message : 'Unknown error occurred');
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const handleRating = async (rating: Rating) => {
+        const card = queue[0];
+        setSubmitting(true);
+        try {
+            const response = await fetch(`/api/cards/${encodeURIComponent(card.id)}/review`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ rating }),
+            });
+            if (!response.ok) {
+                throw new Error(`Failed to save review: ${await response.text()}`);
+            }
+            const updated: StudyCard = await response.json();
+            const rest = queue.slice(1);
+            // Cards still being learned are shown again at the end of the session
+            if (updated.review && updated.review.phase !== 'review') {
+                rest.push(updated);
+            }
+            setQueue(rest);
+            setReviewed(reviewed + 1);
+            setShowAnswer(false);
+        } catch (error) {
+            setError(error instanceof Error ? error.message : 'Unknown error occurred');
+        } finally {
+            setSubmitting(false);
+        }
+    };
+
+    if (error) {
+        return (
+            <Box textAlign="center" p={4}>
+                <Typography color="error">{error}</Typography>
+                <Button variant="contained" onClick={fetchDue} sx={{ mt: 2 }}>
+                    Retry
+                </Button>
+            </Box>
+        );
+    }
+
+    if (loading) {
+        return (
+            <Box display="flex" justifyContent="center" alignItems="center" minHeight="400px">
+                <CircularProgress />
+            </Box>
+        );
+    }
+
+    if (queue.length === 0) {
+        return (
+            <Box textAlign="center" p={4}>
+                <Typography variant="h6">No cards due</Typography>
+                <Typography variant="body1" color="textSecondary">
+                    {reviewed > 0 ? `You reviewed ${reviewed} cards.` : 'Come back later for more reviews.'}
+                </Typography>
+            </Box>
+        );
+    }
+
+    const card = queue[0];
+
+    return (
+        <Box sx={{ maxWidth: 800, mx: 'auto', p: 3 }}>
+            <Typography variant="h5" gutterBottom textAlign="center">
+                Study ({queue.length} left)
+            </Typography>
+
+            <Card sx={{ mb: 3, minHeight: 200 }}>
+                <CardContent>
+                    <Typography variant="h6" sx={{ whiteSpace: 'pre-wrap' }}>
+                        {front(card)}
+                    </Typography>
+                    {showAnswer && (
+                        <Typography variant="body1" sx={{ mt: 3, whiteSpace: 'pre-wrap' }}>
+                            {back(card)}
+                        </Typography>
+                    )}
+                </CardContent>
+            </Card>
+
+            {showAnswer ? (
+                <Stack direction="row" spacing={2} justifyContent="center">
+                    {ratings.map(({ rating, label, color }) => (
+                        <Button
+                            key={rating}
+                            variant="contained"
+                            color={color}
+                            disabled={submitting}
+                            onClick={() => handleRating(rating)}
+                        >
+                            {label}
+                        </Button>
+                    ))}
+                </Stack>
+            ) : (
+                <Box textAlign="center">
+                    <Button variant="contained" onClick={() => setShowAnswer(true)}>
+                        Show Answer
+                    </Button>
+                </Box>
+            )}
+        </Box>
+    );
+};
+
+export default Study;
@@ -80,21 +80,11 @@ echo "Installing Python dependencies..."
     echo "Failed to upgrade pip"
     exit 1
 }
-"$PROJECT_ROOT/venv/bin/pip" install genanki==0.13.0 || {
-    echo "Failed to install genanki"
-    exit 1
-}
 "$PROJECT_ROOT/venv/bin/pip" install -r "$PROJECT_ROOT/requirements.txt" || {
     echo "Failed to install requirements"
     exit 1
 }
 
-# Verify genanki installation
-if ! "$PROJECT_ROOT/venv/bin/python3" -c "import genanki" 2>/dev/null; then
-    echo "Failed to install genanki in virtual environment"
-    exit 1
-fi
-
 # Check and install pdfcpu
 if ! command_exists pdfcpu; then
     echo "Installing pdfcpu..."